@@ -35,12 +35,21 @@ go build -o %{name}
 %install
 install -D -m 0755 %{name} %{buildroot}%{_bindir}/%{name}
 install -D -m 0644 service/%{name}-mount.service %{buildroot}%{_unitdir}/%{name}-mount.service
+install -D -m 0644 service/%{name}-update.service %{buildroot}%{_unitdir}/%{name}-update.service
+install -D -m 0644 service/%{name}-update.timer %{buildroot}%{_unitdir}/%{name}-update.timer
+install -D -m 0644 service/%{name}-staged.service %{buildroot}%{_unitdir}/%{name}-staged.service
 
 %files
 %{_bindir}/%{name}
 %{_unitdir}/%{name}-mount.service
+%{_unitdir}/%{name}-update.service
+%{_unitdir}/%{name}-update.timer
+%{_unitdir}/%{name}-staged.service
 %attr(0755,root,root) %{_bindir}/%{NAME}
 %attr(0644,root,root) %{_exec_prefix}/lib/systemd/system/%{NAME}-mount.service
+%attr(0644,root,root) %{_exec_prefix}/lib/systemd/system/%{NAME}-update.service
+%attr(0644,root,root) %{_exec_prefix}/lib/systemd/system/%{NAME}-update.timer
+%attr(0644,root,root) %{_exec_prefix}/lib/systemd/system/%{NAME}-staged.service
 
 %changelog
 %autochangelog
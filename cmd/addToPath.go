@@ -31,7 +31,7 @@ var (
 )
 
 func init() {
-	fPathPath = AddToPathCmd.Flags().StringP("path", "p", "/tmp/extensions.d/bin", "Path where all shared binaries are being mounted to")
+	fPathPath = AddToPathCmd.Flags().StringP("path", "p", internal.DefaultPathMount, "Path where all shared binaries are being mounted to")
 	fRCPath = AddToPathCmd.Flags().StringP("rc-path", "r", "", "RC path for your chosen shell instead of the default")
 }
 
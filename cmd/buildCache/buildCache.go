@@ -0,0 +1,16 @@
+package buildCache
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/cmd/buildCache/prune"
+)
+
+var BuildCacheCmd = &cobra.Command{
+	Use:   "build-cache",
+	Short: "Manage the nix stores kept between layer builds",
+	Long:  `Manage the podman volumes "layer build --cache-volume" keeps as the build containers' /nix, so successive builds reuse what was already downloaded.`,
+}
+
+func init() {
+	BuildCacheCmd.AddCommand(prune.PruneCmd)
+}
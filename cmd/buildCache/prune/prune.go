@@ -0,0 +1,158 @@
+package prune
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"github.com/containers/podman/v4/pkg/bindings"
+	"github.com/containers/podman/v4/pkg/bindings/volumes"
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/buildcache"
+	"github.com/ublue-os/bext/pkg/output"
+)
+
+var PruneCmd = &cobra.Command{
+	Use:   "prune [NAME...]",
+	Short: "Collect garbage in build caches, or remove them",
+	Long: `Run the nix garbage collector in build caches, pruning every cache bext created when none is specified.
+
+With --remove, the caches are removed instead. Host directories used as caches are left on disk, only the volume bound to them is removed.`,
+	RunE: pruneCmd,
+}
+
+var (
+	fRemove *bool
+	fImage  *string
+)
+
+func init() {
+	fRemove = PruneCmd.Flags().Bool("remove", false, "Remove the caches instead of collecting garbage in them")
+	fImage = PruneCmd.Flags().StringP("image", "i", internal.DefaultBuildImage, "Image used for running the garbage collector, latest when it has no tag")
+}
+
+type Result struct {
+	Cache  string `json:"cache"`
+	Volume string `json:"volume"`
+	Action string `json:"action"`
+	// Sizes are -1 when the volume's mountpoint can not be read from here
+	SizeBefore int64  `json:"size_before"`
+	SizeAfter  int64  `json:"size_after"`
+	Error      string `json:"error,omitempty"`
+}
+
+func pruneCmd(cmd *cobra.Command, args []string) error {
+	conn, err := bindings.NewConnection(context.Background(), "unix:"+internal.PodmanSocketPath())
+	if err != nil {
+		slog.Warn("A podman socket is required, enable it with \"systemctl enable --now --user podman.socket\"")
+		return err
+	}
+
+	caches := args
+	if len(caches) == 0 {
+		reports, err := buildcache.List(conn)
+		if err != nil {
+			return err
+		}
+		for _, report := range reports {
+			caches = append(caches, report.Name)
+		}
+	}
+
+	var (
+		results []Result
+		failed  []string
+	)
+	for _, cache := range caches {
+		result := prune(conn, cache)
+		if result.Error != "" {
+			failed = append(failed, cache)
+		}
+		results = append(results, result)
+	}
+
+	if err := output.Write(os.Stdout, internal.Config.OutputFormat, results, resultsView(results)); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return &internal.BuildCachePruneError{Caches: failed}
+	}
+	return nil
+}
+
+func prune(conn context.Context, cache string) Result {
+	result := Result{Cache: cache, Action: "collected garbage", SizeBefore: -1, SizeAfter: -1}
+	volume, err := buildcache.VolumeName(cache)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Volume = volume
+
+	exists, err := volumes.Exists(conn, volume, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	} else if !exists {
+		result.Error = (&internal.BuildCacheNotFoundError{Name: cache}).Error()
+		return result
+	}
+
+	if size, err := buildcache.Size(conn, volume); err == nil {
+		result.SizeBefore = size
+	}
+
+	if *fRemove {
+		result.Action = "removed"
+		if err := volumes.Remove(conn, volume, nil); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.SizeAfter = 0
+		slog.Info("Removed build cache", slog.String("volume", volume))
+		return result
+	}
+
+	slog.Info("Collecting garbage", slog.String("volume", volume))
+	exit_code, err := buildcache.Run(conn, internal.ImageReference(*fImage, "latest"), volume, []string{"nix-collect-garbage", "--delete-old"})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	} else if exit_code != 0 {
+		result.Error = fmt.Sprintf("garbage collector exited with status %d", exit_code)
+		return result
+	}
+
+	if size, err := buildcache.Size(conn, volume); err == nil {
+		result.SizeAfter = size
+	}
+	return result
+}
+
+func resultsView(results []Result) output.Table {
+	view := output.Table{Title: "Build caches", Header: []string{"Cache", "Volume", "Action", "Freed"}}
+	tsv := internal.Config.OutputFormat == output.FormatTSV
+	if tsv {
+		view.Header = []string{"cache", "volume", "action", "size_before", "size_after", "error"}
+	}
+
+	for _, result := range results {
+		if tsv {
+			view.Rows = append(view.Rows, []string{result.Cache, result.Volume, result.Action, strconv.FormatInt(result.SizeBefore, 10), strconv.FormatInt(result.SizeAfter, 10), result.Error})
+			continue
+		}
+		action := result.Action
+		if result.Error != "" {
+			action = "failed: " + result.Error
+		}
+		freed := "unknown"
+		if result.SizeBefore >= 0 && result.SizeAfter >= 0 {
+			freed = fmt.Sprintf("%.1f MiB", float64(result.SizeBefore-result.SizeAfter)/1024/1024)
+		}
+		view.Rows = append(view.Rows, []string{result.Cache, result.Volume, action, freed})
+	}
+	return view
+}
@@ -0,0 +1,25 @@
+package cache
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/cmd/cache/pack"
+	"github.com/ublue-os/bext/cmd/cache/stats"
+	"github.com/ublue-os/bext/cmd/cache/unpack"
+	"github.com/ublue-os/bext/internal"
+)
+
+var CacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage how blobs are stored in the layer cache",
+	Long: `Manage how blobs are stored in the layer cache.
+
+Blobs other than the current one of their layer can be packed into a chunk store next to the cache, where they are split into content-defined chunks shared by every packed blob, so versions and layers that share most of their closure only store it once.
+Packed blobs are reassembled when they become current again.`,
+}
+
+func init() {
+	CacheCmd.PersistentFlags().StringVar(&internal.Config.CacheDir, "cache-root", internal.DefaultCacheDir, "root directory for the layer cache")
+	CacheCmd.AddCommand(pack.PackCmd)
+	CacheCmd.AddCommand(stats.StatsCmd)
+	CacheCmd.AddCommand(unpack.UnpackCmd)
+}
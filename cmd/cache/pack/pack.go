@@ -0,0 +1,139 @@
+package pack
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/output"
+)
+
+var PackCmd = &cobra.Command{
+	Use:   "pack [LAYER[@HASH]...]",
+	Short: "Move blobs into the chunk store",
+	Long: `Move blobs into the chunk store, every blob but the current one of the given layers (or of every layer) when no hash is specified.
+
+Blobs are checked against their digest before being packed, and chunks no packed blob uses anymore are removed afterwards.`,
+	RunE: packCmd,
+}
+
+type Result struct {
+	Layer  string `json:"layer"`
+	Hash   string `json:"hash"`
+	Size   int64  `json:"size"`
+	Chunks int    `json:"chunks"`
+	Error  string `json:"error,omitempty"`
+}
+
+func packCmd(cmd *cobra.Command, args []string) error {
+	cache_dir, err := filepath.Abs(path.Clean(internal.Config.CacheDir))
+	if err != nil {
+		return err
+	}
+	targets, err := collectBlobs(cache_dir, args)
+	if err != nil {
+		return err
+	}
+
+	results := []Result{}
+	var failed []string
+	for _, target := range targets {
+		slog.Debug("Packing blob", slog.String("layer", target.Layer), slog.String("hash", target.Hash))
+		index, err := internal.PackBlob(target.Layer, target.Hash)
+		if err != nil {
+			slog.Warn("Failed packing "+target.Layer+"@"+target.Hash, slog.String("error", err.Error()))
+			target.Error = err.Error()
+			failed = append(failed, target.Layer+"@"+target.Hash)
+		} else {
+			target.Size, target.Chunks = index.Size, len(index.Chunks)
+		}
+		results = append(results, target)
+	}
+
+	store, err := internal.ChunkStore()
+	if err != nil {
+		return err
+	}
+	if removed, freed, err := store.Prune(); err != nil {
+		return err
+	} else if removed > 0 {
+		slog.Info("Removed unused chunks", slog.Int("chunks", removed), slog.Int64("bytes", freed))
+	}
+
+	if err := output.Write(os.Stdout, internal.Config.OutputFormat, results, resultsView(results)); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return &internal.PackFailedError{Blobs: failed}
+	}
+	return nil
+}
+
+// Explicit hashes are passed on even when current, for PackBlob to refuse them with a clear error
+func collectBlobs(cache_dir string, refs []string) ([]Result, error) {
+	if len(refs) == 0 {
+		entries, err := os.ReadDir(cache_dir)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				refs = append(refs, entry.Name())
+			}
+		}
+	}
+
+	var targets []Result
+	for _, ref := range refs {
+		layer, hash := internal.ParseLayerRef(ref)
+		if hash != "" {
+			targets = append(targets, Result{Layer: layer, Hash: hash})
+			continue
+		}
+
+		layer_dir := path.Join(cache_dir, layer)
+		entries, err := os.ReadDir(layer_dir)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, &internal.LayerNotFoundError{Layer: layer}
+		} else if err != nil {
+			return nil, err
+		}
+		current_hash := ""
+		if current, err := filepath.EvalSymlinks(path.Join(layer_dir, internal.CurrentBlobName)); err == nil {
+			current_hash = path.Base(current)
+		}
+		for _, entry := range entries {
+			if entry.Type().IsRegular() && entry.Name() != current_hash {
+				targets = append(targets, Result{Layer: layer, Hash: entry.Name()})
+			}
+		}
+	}
+	return targets, nil
+}
+
+func resultsView(results []Result) output.Table {
+	view := output.Table{Title: "Pack", Header: []string{"Layer", "Blob", "Size", "Status"}}
+	tsv := internal.Config.OutputFormat == output.FormatTSV
+	if tsv {
+		view.Header = []string{"layer", "hash", "size", "chunks", "error"}
+	}
+	for _, result := range results {
+		if tsv {
+			view.Rows = append(view.Rows, []string{result.Layer, result.Hash, strconv.FormatInt(result.Size, 10), strconv.Itoa(result.Chunks), result.Error})
+			continue
+		}
+		status := "packed into " + strconv.Itoa(result.Chunks) + " chunks"
+		if result.Error != "" {
+			status = "failed: " + result.Error
+		}
+		view.Rows = append(view.Rows, []string{result.Layer, result.Hash, strconv.FormatInt(result.Size, 10), status})
+	}
+	return view
+}
@@ -0,0 +1,175 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/output"
+)
+
+var StatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show how much space the cache and its chunk store use",
+	Long: `Show how many whole and packed blobs every layer has, and how much the chunk store saves by storing chunks shared between packed blobs only once.
+
+The deduplication ratio is the size of every packed blob divided by the size of the chunks they use.`,
+	RunE: statsCmd,
+}
+
+type LayerStats struct {
+	Name       string `json:"name"`
+	Blobs      int    `json:"blobs"`
+	BlobsSize  int64  `json:"blobs_size"`
+	Packed     int    `json:"packed"`
+	PackedSize int64  `json:"packed_size"`
+	// Chunks only packed blobs of this layer use, what removing them would free
+	UniqueSize int64 `json:"unique_size"`
+}
+
+type Stats struct {
+	Layers     []LayerStats `json:"layers"`
+	BlobsSize  int64        `json:"blobs_size"`
+	PackedSize int64        `json:"packed_size"`
+	Chunks     int          `json:"chunks"`
+	ChunksSize int64        `json:"chunks_size"`
+	// Chunks no packed blob uses, removed by the next cache pack or unpack
+	UnusedChunks int     `json:"unused_chunks"`
+	DedupRatio   float64 `json:"dedup_ratio"`
+}
+
+func statsCmd(cmd *cobra.Command, args []string) error {
+	cache_dir, err := filepath.Abs(path.Clean(internal.Config.CacheDir))
+	if err != nil {
+		return err
+	}
+	layers := map[string]*LayerStats{}
+	layer := func(name string) *LayerStats {
+		if _, exists := layers[name]; !exists {
+			layers[name] = &LayerStats{Name: name}
+		}
+		return layers[name]
+	}
+
+	entries, err := os.ReadDir(cache_dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		blobs, err := os.ReadDir(path.Join(cache_dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		layer_stats := layer(entry.Name())
+		for _, blob := range blobs {
+			if !blob.Type().IsRegular() {
+				continue
+			}
+			info, err := blob.Info()
+			if err != nil {
+				return err
+			}
+			layer_stats.Blobs++
+			layer_stats.BlobsSize += info.Size()
+		}
+	}
+
+	store, err := internal.ChunkStore()
+	if err != nil {
+		return err
+	}
+	usage, err := store.Usage()
+	if err != nil {
+		return err
+	}
+	names, err := store.Names()
+	if err != nil {
+		return err
+	}
+
+	// Which layer uses a chunk, empty once a second one does
+	owners := map[[32]byte]string{}
+	for _, name := range names {
+		layer_name, _, _ := strings.Cut(name, "/")
+		index, err := store.Index(name)
+		if err != nil {
+			return err
+		}
+		layer_stats := layer(layer_name)
+		layer_stats.Packed++
+		layer_stats.PackedSize += index.Size
+		for _, ref := range index.Chunks {
+			if owner, exists := owners[ref.Sum]; !exists {
+				owners[ref.Sum] = layer_name
+			} else if owner != layer_name {
+				owners[ref.Sum] = ""
+			}
+		}
+	}
+
+	stats := Stats{Layers: []LayerStats{}}
+	for sum, chunk := range usage {
+		if chunk.References == 0 {
+			stats.UnusedChunks++
+			continue
+		}
+		stats.Chunks++
+		stats.ChunksSize += chunk.Size
+		if owner := owners[sum]; owner != "" {
+			layers[owner].UniqueSize += chunk.Size
+		}
+	}
+	for _, layer_stats := range layers {
+		stats.Layers = append(stats.Layers, *layer_stats)
+		stats.BlobsSize += layer_stats.BlobsSize
+		stats.PackedSize += layer_stats.PackedSize
+	}
+	slices.SortFunc(stats.Layers, func(a, b LayerStats) int { return strings.Compare(a.Name, b.Name) })
+	if stats.ChunksSize > 0 {
+		stats.DedupRatio = float64(stats.PackedSize) / float64(stats.ChunksSize)
+	}
+
+	return output.Write(os.Stdout, internal.Config.OutputFormat, stats, statsView(stats))
+}
+
+func mebibytes(size int64) string {
+	return fmt.Sprintf("%.1f MiB", float64(size)/1024/1024)
+}
+
+func statsView(stats Stats) output.Table {
+	if internal.Config.OutputFormat == output.FormatTSV {
+		view := output.Table{Header: []string{"name", "blobs", "blobs_size", "packed", "packed_size", "unique_size"}}
+		for _, layer := range stats.Layers {
+			view.Rows = append(view.Rows, []string{layer.Name, strconv.Itoa(layer.Blobs), strconv.FormatInt(layer.BlobsSize, 10),
+				strconv.Itoa(layer.Packed), strconv.FormatInt(layer.PackedSize, 10), strconv.FormatInt(layer.UniqueSize, 10)})
+		}
+		return view
+	}
+
+	view := output.Table{Title: "Cache", Header: []string{"Layer", "Blobs", "Packed blobs", "Only used by layer"}}
+	for _, layer := range stats.Layers {
+		view.Rows = append(view.Rows, []string{
+			layer.Name,
+			fmt.Sprintf("%d (%s)", layer.Blobs, mebibytes(layer.BlobsSize)),
+			fmt.Sprintf("%d (%s)", layer.Packed, mebibytes(layer.PackedSize)),
+			mebibytes(layer.UniqueSize),
+		})
+	}
+	view.Rows = append(view.Rows, []string{
+		"total",
+		mebibytes(stats.BlobsSize),
+		fmt.Sprintf("%s in %d chunks of %s, %.2fx deduplication", mebibytes(stats.PackedSize), stats.Chunks, mebibytes(stats.ChunksSize), stats.DedupRatio),
+		"",
+	})
+	return view
+}
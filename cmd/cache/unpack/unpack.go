@@ -0,0 +1,44 @@
+package unpack
+
+import (
+	"log/slog"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/internal"
+)
+
+var UnpackCmd = &cobra.Command{
+	Use:   "unpack LAYER@HASH...",
+	Short: "Reassemble packed blobs into the cache",
+	Long: `Reassemble packed blobs into the cache, checking them against their digest, and remove them from the chunk store.
+
+Blobs are also reassembled on their own when they become the current blob of their layer again.`,
+	RunE: unpackCmd,
+}
+
+func unpackCmd(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return internal.NewPositionalError("LAYER@HASH")
+	}
+	for _, ref := range args {
+		layer, hash := internal.ParseLayerRef(ref)
+		if hash == "" {
+			return internal.NewPositionalError("LAYER@HASH")
+		}
+		if err := internal.UnpackBlob(layer, hash); err != nil {
+			return err
+		}
+		slog.Info("Unpacked blob", slog.String("layer", layer), slog.String("hash", hash))
+	}
+
+	store, err := internal.ChunkStore()
+	if err != nil {
+		return err
+	}
+	removed, freed, err := store.Prune()
+	if err != nil {
+		return err
+	}
+	slog.Info("Removed unused chunks", slog.Int("chunks", removed), slog.Int64("bytes", freed))
+	return nil
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/cmd/config/get"
+	"github.com/ublue-os/bext/cmd/config/set"
+	"github.com/ublue-os/bext/cmd/config/show"
+	"github.com/ublue-os/bext/internal"
+)
+
+var ConfigCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect and change bext's settings",
+	Long: fmt.Sprintf(`Inspect and change the settings that provide defaults for bext's flags.
+
+Settings are read from, lowest precedence first:
+    built-in defaults (per-user paths with --user)
+    %s (skipped with --user)
+    $XDG_CONFIG_HOME/bext/config.yaml
+    %s* environment variables, like %s
+    command line flags
+
+Available settings:
+    %s`, internal.SystemSettingsPath, internal.SettingsEnvPrefix, internal.SettingEnvName("cache-root"), strings.Join(settingDescriptions(), "\n    ")),
+}
+
+func settingDescriptions() []string {
+	var descriptions []string
+	for _, setting := range internal.Settings {
+		descriptions = append(descriptions, fmt.Sprintf("%-18s %s", setting.Key, setting.Description))
+	}
+	return descriptions
+}
+
+func init() {
+	ConfigCmd.AddCommand(get.GetCmd)
+	ConfigCmd.AddCommand(set.SetCmd)
+	ConfigCmd.AddCommand(show.ShowCmd)
+}
@@ -0,0 +1,43 @@
+package get
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/output"
+)
+
+var GetCmd = &cobra.Command{
+	Use:   "get KEY",
+	Short: "Print the effective value of a setting",
+	RunE:  getCmd,
+	Args:  cobra.ExactArgs(1),
+}
+
+type Setting struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+func getCmd(cmd *cobra.Command, args []string) error {
+	if _, err := internal.LookupSetting(args[0]); err != nil {
+		return err
+	}
+
+	for _, resolved := range internal.Config.ResolvedSettings {
+		if resolved.Key != args[0] {
+			continue
+		}
+		if !internal.Config.OutputFormat.IsMachineReadable() {
+			fmt.Println(resolved.Value)
+			return nil
+		}
+		setting := Setting{Key: resolved.Key, Value: resolved.Value, Source: string(resolved.Source)}
+		view := output.Table{Header: []string{"key", "value", "source"}, Rows: [][]string{{setting.Key, setting.Value, setting.Source}}}
+		return output.Write(os.Stdout, internal.Config.OutputFormat, setting, view)
+	}
+	return &internal.UnknownSettingError{Key: args[0]}
+}
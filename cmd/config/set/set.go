@@ -0,0 +1,40 @@
+package set
+
+import (
+	"log/slog"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/internal"
+)
+
+var SetCmd = &cobra.Command{
+	Use:   "set KEY VALUE",
+	Short: "Write a setting to the user's or the system's settings file",
+	RunE:  setCmd,
+	Args:  cobra.ExactArgs(2),
+}
+
+var (
+	fSystem *bool
+)
+
+func init() {
+	fSystem = SetCmd.Flags().Bool("system", false, "Write to "+internal.SystemSettingsPath+" instead of the user's settings file")
+}
+
+func setCmd(cmd *cobra.Command, args []string) error {
+	settings_path := internal.SystemSettingsPath
+	if !*fSystem {
+		var err error
+		settings_path, err = internal.UserSettingsPath()
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := internal.WriteSetting(settings_path, args[0], args[1]); err != nil {
+		return err
+	}
+	slog.Info("Updated setting "+args[0], slog.String("key", args[0]), slog.String("value", args[1]), slog.String("path", settings_path))
+	return nil
+}
@@ -0,0 +1,43 @@
+package show
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/output"
+)
+
+var ShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the effective value of every setting and where it comes from",
+	RunE:  showCmd,
+	Args:  cobra.NoArgs,
+}
+
+type Setting struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+	Origin string `json:"origin,omitempty"`
+	Env    string `json:"env"`
+}
+
+func showCmd(cmd *cobra.Command, args []string) error {
+	var settings []Setting
+	view := output.Table{Title: "Settings", Header: []string{"Key", "Value", "Source", "Origin"}}
+	if internal.Config.OutputFormat == output.FormatTSV {
+		view.Header = []string{"key", "value", "source", "origin"}
+	}
+	for _, resolved := range internal.Config.ResolvedSettings {
+		settings = append(settings, Setting{
+			Key:    resolved.Key,
+			Value:  resolved.Value,
+			Source: string(resolved.Source),
+			Origin: resolved.Origin,
+			Env:    internal.SettingEnvName(resolved.Key),
+		})
+		view.Rows = append(view.Rows, []string{resolved.Key, resolved.Value, string(resolved.Source), resolved.Origin})
+	}
+	return output.Write(os.Stdout, internal.Config.OutputFormat, settings, view)
+}
@@ -0,0 +1,361 @@
+package doctor
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/chattr"
+	"github.com/ublue-os/bext/pkg/mountinfo"
+	"github.com/ublue-os/bext/pkg/osrelease"
+	"github.com/ublue-os/bext/pkg/squashfs"
+	"github.com/ublue-os/bext/pkg/sysext"
+	"golang.org/x/sys/unix"
+)
+
+type Severity string
+
+const (
+	SeverityOK      Severity = "ok"
+	SeverityInfo    Severity = "info"
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+)
+
+type Finding struct {
+	Check    string   `json:"check"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+	Fixable  bool     `json:"fixable"`
+	Fixed    bool     `json:"fixed"`
+	FixError string   `json:"fix_error,omitempty"`
+	// Only set for repairs that cannot make things worse
+	fix func() error
+}
+
+type check struct {
+	Name string
+	Run  func() ([]Finding, error)
+}
+
+var checks = []check{
+	{Name: "cache", Run: checkCache},
+	{Name: "activation", Run: checkActivation},
+	{Name: "root-immutable", Run: checkRootImmutable},
+	{Name: "path-overlay", Run: checkPathOverlay},
+	{Name: "store-selinux", Run: checkStoreLabels},
+	{Name: "podman", Run: checkPodman},
+	{Name: "os-release", Run: checkOSRelease},
+}
+
+const (
+	ostreeBootedPath  = "/run/ostree-booted"
+	selinuxEnforce    = "/sys/fs/selinux/enforce"
+	layeredStorePath  = "/usr/store"
+	selinuxLabelXattr = "security.selinux"
+)
+
+func ok(message string) []Finding {
+	return []Finding{{Severity: SeverityOK, Message: message}}
+}
+
+func checkCache() ([]Finding, error) {
+	cache_dir, err := filepath.Abs(path.Clean(internal.Config.CacheDir))
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(cache_dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Finding{{Severity: SeverityInfo, Message: cache_dir + " does not exist"}}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	state, err := internal.LoadCacheState()
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []Finding
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		layer_dir := path.Join(cache_dir, entry.Name())
+		blobs, err := os.ReadDir(layer_dir)
+		if err != nil {
+			return nil, err
+		}
+		if len(blobs) == 0 {
+			findings = append(findings, Finding{
+				Severity: SeverityInfo,
+				Message:  fmt.Sprintf("layer %s has no blobs", entry.Name()),
+				fix:      func() error { return os.Remove(layer_dir) },
+			})
+			continue
+		}
+
+		current_path := path.Join(layer_dir, internal.CurrentBlobName)
+		if _, err := os.Lstat(current_path); err == nil {
+			if _, err := filepath.EvalSymlinks(current_path); err != nil {
+				findings = append(findings, Finding{
+					Severity: SeverityError,
+					Message:  fmt.Sprintf("%s of layer %s points to a missing blob", internal.CurrentBlobName, entry.Name()),
+					fix:      func() error { return os.Remove(current_path) },
+				})
+			}
+		}
+	}
+
+	for layer, layer_state := range state.Layers {
+		for _, hash := range layer_state.Pinned {
+			if _, err := os.Stat(path.Join(cache_dir, layer, hash)); err == nil || internal.IsPacked(layer, hash) {
+				continue
+			}
+			layer, hash := layer, hash
+			findings = append(findings, Finding{
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("pinned blob %s@%s is not in the cache", layer, hash),
+				fix:      func() error { return unpin(layer, hash) },
+			})
+		}
+	}
+
+	if len(findings) == 0 {
+		return ok("cache is consistent"), nil
+	}
+	return findings, nil
+}
+
+// State is loaded again for every fix so that fixes do not overwrite each other
+func unpin(layer string, hash string) error {
+	state, err := internal.LoadCacheState()
+	if err != nil {
+		return err
+	}
+	layer_state := state.Layer(layer)
+	layer_state.Pinned = slices.DeleteFunc(layer_state.Pinned, func(pinned string) bool { return pinned == hash })
+	return state.Save()
+}
+
+func checkActivation() ([]Finding, error) {
+	extensions_dir, err := filepath.Abs(path.Clean(internal.Config.ExtensionsDir))
+	if err != nil {
+		return nil, err
+	}
+	layers, err := internal.ActiveLayers()
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []Finding
+	for _, layer := range layers {
+		activated_path := path.Join(extensions_dir, layer+internal.ValidSysextExtension)
+		if _, err := filepath.EvalSymlinks(activated_path); err == nil {
+			continue
+		}
+		findings = append(findings, Finding{
+			Severity: SeverityError,
+			Message:  fmt.Sprintf("activated layer %s links to a missing blob", layer),
+			fix:      func() error { return os.Remove(activated_path) },
+		})
+	}
+
+	if len(findings) == 0 {
+		return ok(fmt.Sprintf("%d activated layers link to existing blobs", len(layers))), nil
+	}
+	return findings, nil
+}
+
+// ostree keeps / immutable, an interrupted mkdir-rootfs@ unit can leave it mutable
+func checkRootImmutable() ([]Finding, error) {
+	if _, err := os.Stat(ostreeBootedPath); err != nil {
+		return ok("not an ostree system"), nil
+	}
+
+	root_dir, err := os.Open("/")
+	if err != nil {
+		return nil, err
+	}
+	defer root_dir.Close()
+
+	immutable, err := chattr.IsAttr(root_dir, chattr.FS_IMMUTABLE_FL)
+	if errors.Is(err, syscall.ENOTTY) || errors.Is(err, syscall.EOPNOTSUPP) {
+		return []Finding{{Severity: SeverityInfo, Message: "the root filesystem does not support attributes"}}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if immutable {
+		return ok("/ is immutable"), nil
+	}
+
+	return []Finding{{
+		Severity: SeverityWarning,
+		Message:  "/ is not immutable on an ostree system",
+		fix: func() error {
+			root_dir, err := os.Open("/")
+			if err != nil {
+				return err
+			}
+			defer root_dir.Close()
+			return chattr.SetAttr(root_dir, chattr.FS_IMMUTABLE_FL)
+		},
+	}}, nil
+}
+
+func checkPathOverlay() ([]Finding, error) {
+	path_path, err := filepath.Abs(path.Clean(*fPathPath))
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := mountinfo.Read()
+	if err != nil {
+		return nil, err
+	}
+	path_mount, mounted := mountinfo.Lookup(mounts, path_path)
+	if !mounted {
+		return ok(path_path + " is not mounted"), nil
+	}
+
+	stale_reason := ""
+	if _, err := os.ReadDir(path_path); err != nil {
+		stale_reason = err.Error()
+	} else if path_mount.FSType == "overlay" {
+		for _, option := range path_mount.SuperOptions {
+			lowers, found := strings.CutPrefix(option, "lowerdir=")
+			if !found {
+				continue
+			}
+			for _, lower := range strings.Split(lowers, ":") {
+				if _, err := os.Stat(lower); err != nil {
+					stale_reason = "layer " + lower + " is gone"
+				}
+			}
+		}
+	} else if bins, _ := filepath.Glob(path.Join(internal.Config.ExtensionsMount, "*", "bin")); len(bins) == 0 {
+		stale_reason = "no mounted layer has binaries"
+	}
+
+	if stale_reason == "" {
+		return ok(path_path + " is mounted"), nil
+	}
+	return []Finding{{
+		Severity: SeverityWarning,
+		Message:  fmt.Sprintf("%s is a stale mount: %s", path_path, stale_reason),
+		fix:      func() error { return syscall.Unmount(path_path, syscall.MNT_DETACH) },
+	}}, nil
+}
+
+// Images can not be relabeled after being built, so there is nothing to fix here
+func checkStoreLabels() ([]Finding, error) {
+	enforce, err := os.ReadFile(selinuxEnforce)
+	if err != nil {
+		return ok("SELinux is disabled"), nil
+	}
+	if _, err := os.Stat(layeredStorePath); err != nil {
+		return ok(layeredStorePath + " does not exist"), nil
+	}
+
+	severity := SeverityWarning
+	if strings.TrimSpace(string(enforce)) == "1" {
+		severity = SeverityError
+	}
+
+	label := make([]byte, 256)
+	size, err := unix.Lgetxattr(layeredStorePath, selinuxLabelXattr, label)
+	if err != nil {
+		return []Finding{{Severity: severity, Message: fmt.Sprintf("%s has no SELinux label: %s", layeredStorePath, err)}}, nil
+	}
+	context := strings.TrimRight(string(label[:size]), "\x00")
+	if strings.Contains(context, "unlabeled_t") {
+		return []Finding{{Severity: severity, Message: fmt.Sprintf("%s is unlabeled (%s), layered binaries will be denied", layeredStorePath, context)}}, nil
+	}
+	return ok(fmt.Sprintf("%s is labeled %s", layeredStorePath, context)), nil
+}
+
+// Only building needs podman, so it is never an error
+func checkPodman() ([]Finding, error) {
+	socket := internal.PodmanSocketPath()
+	conn, err := net.DialTimeout("unix", socket, time.Second)
+	if err != nil {
+		return []Finding{{
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf("podman socket %s is not available, building layers needs \"systemctl enable --now --user podman.socket\"", socket),
+		}}, nil
+	}
+	conn.Close()
+	return ok("podman socket " + socket + " is available"), nil
+}
+
+func checkOSRelease() ([]Finding, error) {
+	host, err := osrelease.ReadHost()
+	if err != nil {
+		return []Finding{{Severity: SeverityError, Message: "no os-release file found on the host"}}, nil
+	}
+	layers, err := internal.ActiveLayers()
+	if err != nil {
+		return nil, err
+	}
+	merged, err := sysext.MergedExtensions()
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []Finding
+	for _, layer := range layers {
+		var release osrelease.Release
+		if slices.Contains(merged, layer) {
+			release, err = osrelease.Read(path.Join("/", sysext.ReleaseFilePath(layer)))
+		} else {
+			release, err = readImageRelease(layer)
+		}
+		var not_found *internal.LayerNotFoundError
+		if errors.As(err, &not_found) {
+			// Already reported by the activation check
+			continue
+		} else if errors.Is(err, os.ErrPermission) {
+			findings = append(findings, Finding{Severity: SeverityInfo, Message: fmt.Sprintf("layer %s is not merged and cannot be checked without root", layer)})
+			continue
+		} else if err != nil {
+			findings = append(findings, Finding{Severity: SeverityWarning, Message: fmt.Sprintf("could not read the extension-release of layer %s: %s", layer, err)})
+			continue
+		}
+
+		if err := osrelease.Compatible(host, release); err != nil {
+			findings = append(findings, Finding{Severity: SeverityError, Message: fmt.Sprintf("layer %s is incompatible with this system: %s", layer, err)})
+		}
+	}
+
+	if len(findings) == 0 {
+		return ok(fmt.Sprintf("%d activated layers are compatible with %s %s", len(layers), host["ID"], host["VERSION_ID"])), nil
+	}
+	return findings, nil
+}
+
+// Unmerged layers are read straight from their image, like layer diff does, so nothing is mounted
+func readImageRelease(layer string) (osrelease.Release, error) {
+	image_path, err := internal.LayerImagePath(layer)
+	if err != nil {
+		return nil, err
+	}
+	image, err := squashfs.Open(image_path)
+	if err != nil {
+		return nil, err
+	}
+	defer image.Close()
+
+	release_file, err := image.Open(sysext.ReleaseFilePath(layer))
+	if err != nil {
+		return nil, err
+	}
+	defer release_file.Close()
+	return osrelease.Parse(release_file)
+}
@@ -0,0 +1,94 @@
+package doctor
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ublue-os/bext/internal"
+)
+
+func useCache(t *testing.T) string {
+	t.Helper()
+	cache_dir := filepath.Join(t.TempDir(), "cache")
+	previous := internal.Config.CacheDir
+	internal.Config.CacheDir = cache_dir
+	t.Cleanup(func() { internal.Config.CacheDir = previous })
+	return cache_dir
+}
+
+func addBlob(t *testing.T, cache_dir string, layer string, data []byte) string {
+	t.Helper()
+	sum := md5.Sum(data)
+	hash := hex.EncodeToString(sum[:])
+	if err := os.MkdirAll(filepath.Join(cache_dir, layer), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cache_dir, layer, hash), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func pin(t *testing.T, layer string, hash string) {
+	t.Helper()
+	state, err := internal.LoadCacheState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer_state := state.Layer(layer)
+	layer_state.Pinned = append(layer_state.Pinned, hash)
+	if err := state.Save(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func pinnedFindings(t *testing.T) []Finding {
+	t.Helper()
+	findings, err := checkCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pinned []Finding
+	for _, finding := range findings {
+		if strings.HasPrefix(finding.Message, "pinned blob") {
+			pinned = append(pinned, finding)
+		}
+	}
+	return pinned
+}
+
+func TestCheckCachePackedPinnedBlob(t *testing.T) {
+	cache_dir := useCache(t)
+	hash := addBlob(t, cache_dir, "example", []byte("an older version of the layer"))
+	addBlob(t, cache_dir, "example", []byte("the current version of the layer"))
+	pin(t, "example", hash)
+
+	if _, err := internal.PackBlob("example", hash); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(cache_dir, "example", hash)); !os.IsNotExist(err) {
+		t.Fatalf("packed blob is still whole: %v", err)
+	}
+
+	if findings := pinnedFindings(t); len(findings) != 0 {
+		t.Errorf("packed pinned blob reported as missing: %+v", findings)
+	}
+}
+
+func TestCheckCacheMissingPinnedBlob(t *testing.T) {
+	cache_dir := useCache(t)
+	addBlob(t, cache_dir, "example", []byte("the current version of the layer"))
+	pin(t, "example", "0123456789abcdef0123456789abcdef")
+
+	findings := pinnedFindings(t)
+	if len(findings) != 1 {
+		t.Fatalf("got %d findings for a missing pinned blob, want 1", len(findings))
+	}
+	if findings[0].Severity != SeverityWarning || findings[0].fix == nil {
+		t.Errorf("unexpected finding %+v", findings[0])
+	}
+}
@@ -0,0 +1,111 @@
+package doctor
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/output"
+)
+
+var DoctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the cache, activated layers and mounts for problems",
+	Long: `Check the cache, the activation directory, mounts, SELinux labels on /usr/store, podman socket availability and whether activated layers are compatible with the host's os-release.
+
+Every finding has a severity, findings with the error severity make the command exit with a non-zero status.
+With --fix, safe repairs are applied: removing dangling symlinks and stale pins, setting the immutable bit back on / for ostree systems and unmounting a stale PATH overlay.`,
+	RunE: doctorCmd,
+}
+
+var (
+	fFix      *bool
+	fPathPath *string
+)
+
+func init() {
+	fFix = DoctorCmd.Flags().Bool("fix", false, "Apply safe repairs for the problems found")
+	fPathPath = DoctorCmd.Flags().StringP("path", "p", internal.DefaultPathMount, "Path where the PATH overlay is mounted to")
+	DoctorCmd.Flags().StringVar(&internal.Config.CacheDir, "cache-root", internal.DefaultCacheDir, "root directory for the layer cache")
+	DoctorCmd.Flags().StringVar(&internal.Config.ExtensionsDir, "extensions-root", internal.DefaultExtensionsDir, "root directory for the systemd-sysext layers")
+	DoctorCmd.Flags().StringVar(&internal.Config.ExtensionsMount, "extensions-mount", internal.DefaultExtensionsMount, "directory where systemd-sysext layers will be mounted to")
+}
+
+func doctorCmd(cmd *cobra.Command, args []string) error {
+	var findings []Finding
+	for _, check := range checks {
+		slog.Debug("Running check", slog.String("check", check.Name))
+		check_findings, err := check.Run()
+		if err != nil {
+			check_findings = []Finding{{Severity: SeverityError, Message: fmt.Sprintf("check failed: %s", err)}}
+		}
+		for i := range check_findings {
+			check_findings[i].Check = check.Name
+			check_findings[i].Fixable = check_findings[i].fix != nil
+		}
+		findings = append(findings, check_findings...)
+	}
+
+	if *fFix {
+		applyFixes(findings)
+	}
+
+	if err := output.Write(os.Stdout, internal.Config.OutputFormat, findings, findingsView(findings)); err != nil {
+		return err
+	}
+
+	var problems []string
+	for _, finding := range findings {
+		if finding.Severity == SeverityError && !finding.Fixed {
+			problems = append(problems, finding.Check+": "+finding.Message)
+		}
+	}
+	if len(problems) > 0 {
+		return &internal.UnhealthyError{Problems: problems}
+	}
+	return nil
+}
+
+// Fixes are applied one by one, a failing fix does not stop the others
+func applyFixes(findings []Finding) {
+	for i := range findings {
+		if findings[i].fix == nil {
+			continue
+		}
+		slog.Debug("Fixing", slog.String("check", findings[i].Check), slog.String("finding", findings[i].Message))
+		if err := findings[i].fix(); err != nil {
+			slog.Warn("Failed fixing "+findings[i].Message, slog.String("check", findings[i].Check), slog.String("error", err.Error()))
+			findings[i].FixError = err.Error()
+			continue
+		}
+		findings[i].Fixed = true
+	}
+}
+
+func findingsView(findings []Finding) output.Table {
+	view := output.Table{Title: "Doctor", Header: []string{"Check", "Severity", "Finding", "Fix"}}
+	if internal.Config.OutputFormat == output.FormatTSV {
+		view.Header = []string{"check", "severity", "message", "fixable", "fixed"}
+		for _, finding := range findings {
+			view.Rows = append(view.Rows, []string{finding.Check, string(finding.Severity), finding.Message, strconv.FormatBool(finding.Fixable), strconv.FormatBool(finding.Fixed)})
+		}
+		return view
+	}
+
+	for _, finding := range findings {
+		fix := ""
+		switch {
+		case finding.Fixed:
+			fix = "fixed"
+		case finding.FixError != "":
+			fix = "failed: " + finding.FixError
+		case finding.Fixable:
+			fix = "run with --fix"
+		}
+		view.Rows = append(view.Rows, []string{finding.Check, string(finding.Severity), finding.Message, fix})
+	}
+	return view
+}
@@ -0,0 +1,21 @@
+package env
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/cmd/env/install"
+	"github.com/ublue-os/bext/cmd/env/print"
+	"github.com/ublue-os/bext/internal"
+)
+
+var EnvCmd = &cobra.Command{
+	Use:   "env",
+	Short: "Integrate activated layers into the environment",
+	Long:  `Print or install environment snippets that add the binaries, manpages and data directories of activated layers to PATH, MANPATH and XDG_DATA_DIRS.`,
+}
+
+func init() {
+	EnvCmd.PersistentFlags().StringVar(&internal.Config.ExtensionsDir, "extensions-root", internal.DefaultExtensionsDir, "root directory for the systemd-sysext layers")
+	EnvCmd.PersistentFlags().StringVar(&internal.Config.ExtensionsMount, "extensions-mount", internal.DefaultExtensionsMount, "directory where systemd-sysext layers will be mounted to")
+	EnvCmd.AddCommand(install.InstallCmd)
+	EnvCmd.AddCommand(print.PrintCmd)
+}
@@ -0,0 +1,147 @@
+package install
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/environment"
+)
+
+var InstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Install environment snippets for the activated layers",
+	Long: `Install snippets that keep PATH, MANPATH and XDG_DATA_DIRS in sync with the activated layers.
+
+With --system, writes to:
+    /etc/profile.d/bext.sh
+    /etc/fish/conf.d/bext.fish
+    /etc/systemd/user-environment-generators/60-bext
+
+Otherwise, writes to the current user's configuration directory:
+    $XDG_CONFIG_HOME/fish/conf.d/bext.fish
+    $XDG_CONFIG_HOME/environment.d/60-bext.conf (snapshot, re-run after activating layers)`,
+	RunE: installCmd,
+}
+
+var (
+	fSystem     *bool
+	fNoOverride *bool
+	fSysconfig  *string
+)
+
+func init() {
+	fSystem = InstallCmd.Flags().Bool("system", false, "Install system-wide snippets for every user")
+	fNoOverride = InstallCmd.Flags().Bool("no-override", false, "Keep already installed snippets instead of writing over them")
+	fSysconfig = InstallCmd.Flags().String("sysconfdir", "/etc", "System configuration directory used with --system")
+}
+
+type snippetFile struct {
+	Path    string
+	Content string
+	Mode    os.FileMode
+}
+
+const generatedHeader = "# Generated by bext env install, do not edit\n"
+
+func installCmd(cmd *cobra.Command, args []string) error {
+	extensions_dir, err := filepath.Abs(path.Clean(internal.Config.ExtensionsDir))
+	if err != nil {
+		return err
+	}
+	extensions_mount, err := filepath.Abs(path.Clean(internal.Config.ExtensionsMount))
+	if err != nil {
+		return err
+	}
+	// The paths end up in scripts, so they are quoted for the shell running the command rather than the one it prints for
+	print_command := func(script_shell string, shell string) string {
+		return fmt.Sprintf("bext env print --extensions-root %s --extensions-mount %s --shell %s", environment.Quote(script_shell, extensions_dir), environment.Quote(script_shell, extensions_mount), shell)
+	}
+	fish_snippet := generatedHeader +
+		"if command -q bext\n" +
+		fmt.Sprintf("    %s 2>/dev/null | source\n", print_command("fish", "fish")) +
+		"end\n"
+
+	var snippets []snippetFile
+	if *fSystem {
+		sysconfdir := path.Clean(*fSysconfig)
+		snippets = []snippetFile{
+			{
+				Path: path.Join(sysconfdir, "profile.d", "bext.sh"),
+				Content: generatedHeader +
+					"if command -v bext >/dev/null 2>&1; then\n" +
+					fmt.Sprintf("    eval \"$(%s 2>/dev/null)\"\n", print_command("sh", "sh")) +
+					"fi\n",
+				Mode: 0644,
+			},
+			{
+				Path:    path.Join(sysconfdir, "fish", "conf.d", "bext.fish"),
+				Content: fish_snippet,
+				Mode:    0644,
+			},
+			{
+				Path: path.Join(sysconfdir, "systemd", "user-environment-generators", "60-bext"),
+				Content: "#!/bin/sh\n" + generatedHeader +
+					"command -v bext >/dev/null 2>&1 || exit 0\n" +
+					fmt.Sprintf("exec %s 2>/dev/null\n", print_command("sh", "systemd")),
+				Mode: 0755,
+			},
+		}
+	} else {
+		user_config, err := os.UserConfigDir()
+		if err != nil {
+			return err
+		}
+
+		layers, err := internal.ActiveLayers()
+		if err != nil {
+			return err
+		}
+		// environment.d cannot run commands, so the best we can do is a snapshot of the current state
+		environment_snapshot, err := environment.Render("systemd", environment.FromLayers(extensions_mount, layers))
+		if err != nil {
+			return err
+		}
+
+		snippets = []snippetFile{
+			{
+				Path:    path.Join(user_config, "fish", "conf.d", "bext.fish"),
+				Content: fish_snippet,
+				Mode:    0644,
+			},
+			{
+				Path:    path.Join(user_config, "environment.d", "60-bext.conf"),
+				Content: generatedHeader + environment_snapshot,
+				Mode:    0644,
+			},
+		}
+	}
+
+	for _, snippet := range snippets {
+		if _, err := os.Stat(snippet.Path); err == nil && *fNoOverride {
+			slog.Warn("Snippet already exists, skipping", slog.String("target", snippet.Path))
+			continue
+		}
+
+		if err := os.MkdirAll(path.Dir(snippet.Path), 0755); err != nil {
+			slog.Warn("Failed creating snippet directory", slog.String("target", path.Dir(snippet.Path)))
+			return err
+		}
+
+		if err := os.WriteFile(snippet.Path, []byte(snippet.Content), snippet.Mode); err != nil {
+			slog.Warn("Failed writing snippet", slog.String("target", snippet.Path))
+			return err
+		}
+		// WriteFile does not change the mode of already existing files
+		if err := os.Chmod(snippet.Path, snippet.Mode); err != nil {
+			return err
+		}
+		slog.Info(fmt.Sprintf("Successfully written snippet to %s", snippet.Path), slog.String("target", snippet.Path))
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package print
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/environment"
+	"github.com/ublue-os/bext/pkg/logging"
+)
+
+var PrintCmd = &cobra.Command{
+	Use:   "print",
+	Short: "Print environment variables for the activated layers",
+	Long: `Print a snippet that extends PATH, MANPATH and XDG_DATA_DIRS with the activated layers, meant to be evaluated by your shell.
+
+Example:
+    eval "$(bext env print --shell bash)"
+    bext env print --shell fish | source`,
+	RunE: printCmd,
+}
+
+var (
+	fShell *string
+)
+
+func init() {
+	default_shell := "sh"
+	if user_shell := os.Getenv("SHELL"); user_shell != "" {
+		default_shell = path.Base(user_shell)
+	}
+	fShell = PrintCmd.Flags().String("shell", default_shell, "Shell the snippet will be evaluated by")
+}
+
+func printCmd(cmd *cobra.Command, args []string) error {
+	// Anything other than the snippet would break eval-style usage
+	slog.SetDefault(logging.NewMuteLogger())
+
+	extensions_mount, err := filepath.Abs(path.Clean(internal.Config.ExtensionsMount))
+	if err != nil {
+		return err
+	}
+
+	layers, err := internal.ActiveLayers()
+	if err != nil {
+		return err
+	}
+
+	snippet, err := environment.Render(*fShell, environment.FromLayers(extensions_mount, layers))
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(snippet)
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 
@@ -17,22 +18,40 @@ import (
 var ActivateCmd = &cobra.Command{
 	Use:   "activate [TARGET...]",
 	Short: "Activate layers and refresh sysext",
-	Long:  `Activate selected layers and refresh the system extensions store.`,
-	RunE:  activateCmd,
-	Args:  cobra.MinimumNArgs(1),
+	Long: `Activate selected layers and refresh the system extensions store.
+
+Layers listed in the depends of a layer are activated together with it, and have to be in the cache.
+With --on-next-boot layers are only activated on the next boot, before systemd-sysext merges them, so running programs never see the change.`,
+	RunE: activateCmd,
+	Args: cobra.MinimumNArgs(1),
 }
 
 var (
-	fFromFile bool
-	fOverride bool
+	fFromFile   bool
+	fOverride   bool
+	fOnNextBoot bool
 )
 
 func init() {
 	ActivateCmd.Flags().BoolVarP(&fFromFile, "file", "f", false, "Parse positional arguments as files instead of layers")
 	ActivateCmd.Flags().BoolVar(&fOverride, "override", true, "Write over old symlinks")
+	ActivateCmd.Flags().BoolVar(&fOnNextBoot, "on-next-boot", false, "Activate layers on the next boot instead of now")
 }
 
 func activateCmd(cmd *cobra.Command, args []string) error {
+	if fOnNextBoot && fFromFile {
+		return internal.NewInvalidOptionError("--file", "--on-next-boot")
+	}
+	if !fFromFile {
+		var err error
+		if args, err = withDependencies(args); err != nil {
+			return err
+		}
+	}
+	if fOnNextBoot {
+		return activateOnNextBoot(args)
+	}
+
 	extensions_dir, err := filepath.Abs(path.Clean(internal.Config.ExtensionsDir))
 	if err != nil {
 		return err
@@ -109,5 +128,71 @@ func activateCmd(cmd *cobra.Command, args []string) error {
 	if len(errChan) == 0 {
 		slog.Info("Successfully activated layers", slog.String("layers", strings.Join(args, " ")))
 	}
+	if fFromFile {
+		return nil
+	}
+	return clearPending(args)
+}
+
+// Adds every layer the targets depend on, transitively, so they are activated together
+func withDependencies(layers []string) ([]string, error) {
+	resolved := slices.Clone(layers)
+	for i := 0; i < len(resolved); i++ {
+		depends, err := internal.LayerDependencies(resolved[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, dependency := range depends {
+			if slices.Contains(resolved, dependency) {
+				continue
+			}
+			if _, err := os.Stat(path.Join(internal.Config.CacheDir, dependency, internal.CurrentBlobName)); err != nil {
+				return nil, &internal.MissingDependencyError{Layer: resolved[i], Dependency: dependency}
+			}
+			slog.Info("Activating dependency "+dependency, slog.String("layer", resolved[i]), slog.String("dependency", dependency))
+			resolved = append(resolved, dependency)
+		}
+	}
+	return resolved, nil
+}
+
+func activateOnNextBoot(layers []string) error {
+	state, err := internal.LoadCacheState()
+	if err != nil {
+		return err
+	}
+	for _, layer := range layers {
+		// The boot unit applies pending changes as root, a name leaving the cache must never get there
+		if !internal.ValidLayerName(layer) {
+			return &internal.InvalidLayerNameError{Layer: layer}
+		}
+		if _, err := os.Stat(path.Join(internal.Config.CacheDir, layer, internal.CurrentBlobName)); err != nil {
+			return &internal.LayerNotFoundError{Layer: layer}
+		}
+		state.Layer(layer).Pending = internal.PendingActivate
+	}
+	if err := state.Save(); err != nil {
+		return err
+	}
+	slog.Info("Layers will be activated on the next boot", slog.String("layers", strings.Join(layers, " ")))
 	return nil
 }
+
+// Activating a layer now overrides whatever was pending for it
+func clearPending(layers []string) error {
+	state, err := internal.LoadCacheState()
+	if err != nil {
+		return err
+	}
+	cleared := false
+	for _, layer := range layers {
+		if state.PendingChange(layer) != "" {
+			state.Layer(layer).Pending = ""
+			cleared = true
+		}
+	}
+	if !cleared {
+		return nil
+	}
+	return state.Save()
+}
@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/jedib0t/go-pretty/v6/progress"
 	"github.com/spf13/cobra"
@@ -25,21 +26,27 @@ import (
 var AddCmd = &cobra.Command{
 	Use:   "add [TARGET...]",
 	Short: "Add a built layer onto the cache and activate it",
-	Long:  `Copy TARGET over to cache-dir as a blob with the TARGET's sha256 as the filename`,
-	RunE:  addCmd,
-	Args:  cobra.MinimumNArgs(1),
+	Long: `Copy TARGET over to cache-dir as a blob with the TARGET's sha256 as the filename
+
+Blobs are reflinked when the filesystem supports it (btrfs, XFS), so adding them takes no time and no extra space.`,
+	RunE: addCmd,
+	Args: cobra.MinimumNArgs(1),
 }
 
 var (
 	fNoSymlink  bool
 	fNoChecksum bool
 	fOverride   bool
+	fForce      bool
+	fChunkStore bool
 )
 
 func init() {
 	AddCmd.Flags().BoolVar(&fNoSymlink, "no-symlink", false, "Do not activate layer once added to cache")
 	AddCmd.Flags().BoolVar(&fNoChecksum, "no-checksum", false, "Do not check if layer was properly added to cache")
 	AddCmd.Flags().BoolVar(&fOverride, "override", false, "Override blob if they are already written to cache")
+	AddCmd.Flags().BoolVar(&fForce, "force", false, "Move the current blob even if the layer is held")
+	AddCmd.Flags().BoolVar(&fChunkStore, "chunk-store", false, "Pack the previous current blob into the chunk store, deduplicating it against every other packed blob")
 }
 
 func CheckBlobIntegrity(expectedSum []byte, target string) (bool, error) {
@@ -55,6 +62,7 @@ func CheckBlobIntegrity(expectedSum []byte, target string) (bool, error) {
 
 func addCmd(cmd *cobra.Command, args []string) error {
 	pw := percent.NewProgressWriter()
+	logger := slog.Default()
 	if !*internal.Config.NoProgress {
 		go pw.Render()
 		slog.SetDefault(logging.NewMuteLogger())
@@ -65,12 +73,19 @@ func addCmd(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	state, err := internal.LoadCacheState()
+	if err != nil {
+		return err
+	}
+
 	var wg sync.WaitGroup
 	errChan := make(chan error, len(args))
+	// How every blob was copied, empty for the ones that failed before
+	strategies := make([]fileio.CopyStrategy, len(args))
 
-	for _, layer := range args {
+	for i, layer := range args {
 		wg.Add(1)
-		go func(layer string, errorChan chan<- error) {
+		go func(i int, layer string, errorChan chan<- error) {
 			defer wg.Done()
 			target_layer := &internal.TargetLayerInfo{}
 			target_layer.Path = path.Clean(layer)
@@ -130,10 +145,13 @@ func addCmd(cmd *cobra.Command, args []string) error {
 
 			add_tracker.IncrementSection()
 			slog.Warn(fmt.Sprintf("Copying blob %s %s", target_layer.Path, blob_filepath))
-			if err := fileio.FileCopy(target_layer.Path, blob_filepath); err != nil {
+			strategy, err := fileio.FileCopy(target_layer.Path, blob_filepath)
+			if err != nil {
 				errChan <- err
 				return
 			}
+			slog.Info("Copied blob", slog.String("path", blob_filepath), slog.String("strategy", string(strategy)))
+			strategies[i] = strategy
 
 			if !fNoChecksum {
 				add_tracker.Tracker.Message = "Checking blob"
@@ -149,6 +167,7 @@ func addCmd(cmd *cobra.Command, args []string) error {
 			}
 
 			if fNoSymlink {
+				add_tracker.Tracker.Message = fmt.Sprintf("Added (%s)", strategy)
 				add_tracker.Tracker.MarkAsDone()
 				return
 			}
@@ -161,7 +180,15 @@ func addCmd(cmd *cobra.Command, args []string) error {
 			}
 			slog.Debug("Refreshing symlink", slog.String("path", current_blob_path))
 			add_tracker.IncrementSection()
-			if _, err := os.Lstat(current_blob_path); err == nil {
+			previous_hash := ""
+			if previous, err := filepath.EvalSymlinks(current_blob_path); err == nil {
+				previous_hash = path.Base(previous)
+			}
+			if _, err := os.Lstat(current_blob_path); err == nil && state.IsHeld(target_layer.LayerName) && !fForce {
+				add_tracker.Tracker.MarkAsErrored()
+				errChan <- fmt.Errorf("layer %s is held, blob was added but the current blob was not moved (use --force)", target_layer.LayerName)
+				return
+			} else if err == nil {
 				err = os.Remove(current_blob_path)
 				if err != nil {
 					add_tracker.Tracker.MarkAsErrored()
@@ -182,8 +209,16 @@ func addCmd(cmd *cobra.Command, args []string) error {
 				errChan <- err
 				return
 			}
+
+			// The new blob is already current, so failing to pack the previous one only costs space
+			if fChunkStore && previous_hash != "" && previous_hash != path.Base(blob_filepath) {
+				if _, err := internal.PackBlob(target_layer.LayerName, previous_hash); err != nil {
+					slog.Warn("Failed packing previous blob "+previous_hash, slog.String("layer", target_layer.LayerName), slog.String("error", err.Error()))
+				}
+			}
+			add_tracker.Tracker.Message = fmt.Sprintf("Added (%s)", strategy)
 			add_tracker.Tracker.MarkAsDone()
-		}(layer, errChan)
+		}(i, layer, errChan)
 	}
 
 	go func() {
@@ -191,12 +226,28 @@ func addCmd(cmd *cobra.Command, args []string) error {
 		close(errChan)
 	}()
 
+	// The logger is muted while progress is rendered, so errors are returned for them to be shown at all
+	var errs []error
 	for err := range errChan {
 		slog.Warn(fmt.Sprintf("Error encountered when adding blobs: %s", err.Error()), slog.String("error", err.Error()))
+		errs = append(errs, err)
+	}
+
+	if !*internal.Config.NoProgress {
+		pw.Stop()
+		for pw.IsRenderInProgress() {
+			time.Sleep(10 * time.Millisecond)
+		}
+		slog.SetDefault(logger)
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
 	}
 
-	if len(errChan) == 0 {
-		slog.Info("Successfully added blobs to cache", slog.String("blobs", strings.Join(args, " ")))
+	var added []string
+	for i, layer := range args {
+		added = append(added, fmt.Sprintf("%s (%s)", layer, strategies[i]))
 	}
+	slog.Info("Successfully added blobs to cache: "+strings.Join(added, ", "), slog.String("blobs", strings.Join(args, " ")))
 	return nil
 }
@@ -0,0 +1,178 @@
+package applyDelta
+
+import (
+	"bufio"
+	"log/slog"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/delta"
+	"github.com/ublue-os/bext/pkg/output"
+)
+
+var ApplyDeltaCmd = &cobra.Command{
+	Use:   "apply-delta DELTA...",
+	Short: "Reconstruct blobs from deltas and their cached base blobs",
+	Long: `Reconstruct the blob every delta was made for from its base blob, which has to be in the cache, and add it to the cache.
+
+The reconstructed blob is checked against the digest it was made for before it is added, and becomes the current blob of its layer unless --no-symlink is passed.`,
+	RunE: applyDeltaCmd,
+}
+
+var (
+	fNoSymlink *bool
+	fForce     *bool
+)
+
+func init() {
+	fNoSymlink = ApplyDeltaCmd.Flags().Bool("no-symlink", false, "Do not make the reconstructed blob the current one")
+	fForce = ApplyDeltaCmd.Flags().Bool("force", false, "Move the current blob even if the layer is held")
+}
+
+type Status string
+
+const (
+	StatusAdded Status = "added"
+	// The target was already in the cache, so nothing was reconstructed
+	StatusCached Status = "cached"
+	StatusFailed Status = "failed"
+)
+
+type Result struct {
+	Delta   string `json:"delta"`
+	Layer   string `json:"layer"`
+	Base    string `json:"base"`
+	Target  string `json:"target"`
+	Status  Status `json:"status"`
+	Current bool   `json:"current"`
+	Error   string `json:"error,omitempty"`
+}
+
+func applyDeltaCmd(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return internal.NewPositionalError("DELTA")
+	}
+	if err := os.MkdirAll(internal.Config.CacheDir, 0755); err != nil {
+		return err
+	}
+
+	var results []Result
+	var failed []string
+	for _, delta_path := range args {
+		result := Result{Delta: delta_path}
+		if err := applyDelta(delta_path, &result); err != nil {
+			slog.Warn("Failed applying delta "+delta_path, slog.String("error", err.Error()))
+			result.Status, result.Error = StatusFailed, err.Error()
+			failed = append(failed, delta_path)
+		}
+		results = append(results, result)
+	}
+
+	if err := output.Write(os.Stdout, internal.Config.OutputFormat, results, resultsView(results)); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return &internal.DeltaFailedError{Deltas: failed}
+	}
+	return nil
+}
+
+func applyDelta(delta_path string, result *Result) error {
+	delta_file, err := os.Open(delta_path)
+	if err != nil {
+		return err
+	}
+	defer delta_file.Close()
+	reader := bufio.NewReader(delta_file)
+	header, err := delta.ReadHeader(reader)
+	if err != nil {
+		return err
+	}
+	result.Layer, result.Base, result.Target = header.Layer, header.Base, header.Target
+	if !internal.ValidLayerName(header.Layer) || !internal.ValidLayerName(header.Base) || !internal.ValidLayerName(header.Target) {
+		return &delta.InvalidDeltaError{Reason: "bad layer or digest in header"}
+	}
+
+	layer_dir := path.Join(internal.Config.CacheDir, header.Layer)
+	target_path := path.Join(layer_dir, header.Target)
+	if _, err := os.Stat(target_path); err == nil {
+		result.Status = StatusCached
+	} else {
+		if err := reconstruct(reader, header, layer_dir, target_path); err != nil {
+			return err
+		}
+		slog.Info("Reconstructed blob", slog.String("layer", header.Layer), slog.String("hash", header.Target))
+		result.Status = StatusAdded
+	}
+
+	if *fNoSymlink {
+		return nil
+	}
+	if err := internal.SetCurrentBlob(header.Layer, header.Target, *fForce); err != nil {
+		return err
+	}
+	result.Current = true
+	return nil
+}
+
+// Written next to the layer's directory first, so that the cache never has a blob whose content is not its digest
+func reconstruct(reader *bufio.Reader, header delta.Header, layer_dir string, target_path string) error {
+	base_path := path.Join(layer_dir, header.Base)
+	if _, err := os.Stat(base_path); err != nil && internal.IsPacked(header.Layer, header.Base) {
+		if err := internal.UnpackBlob(header.Layer, header.Base); err != nil {
+			return err
+		}
+	}
+	base, err := os.Open(base_path)
+	if err != nil {
+		return &internal.BlobNotFoundError{Layer: header.Layer, Hash: header.Base}
+	}
+	defer base.Close()
+
+	partial, err := os.CreateTemp(internal.Config.CacheDir, ".delta-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(partial.Name())
+	writer := bufio.NewWriter(partial)
+	if err := delta.Apply(reader, header, base, writer); err != nil {
+		partial.Close()
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		partial.Close()
+		return err
+	}
+	if err := partial.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(partial.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(partial.Name(), target_path)
+}
+
+func resultsView(results []Result) output.Table {
+	view := output.Table{Title: "Apply delta", Header: []string{"Delta", "Layer", "Target", "Status"}}
+	tsv := internal.Config.OutputFormat == output.FormatTSV
+	if tsv {
+		view.Header = []string{"delta", "layer", "base", "target", "status", "current", "error"}
+	}
+	for _, result := range results {
+		if tsv {
+			view.Rows = append(view.Rows, []string{result.Delta, result.Layer, result.Base, result.Target, string(result.Status), strconv.FormatBool(result.Current), result.Error})
+			continue
+		}
+		status := string(result.Status)
+		if result.Error != "" {
+			status += ": " + result.Error
+		} else if result.Current {
+			status += ", current"
+		}
+		view.Rows = append(view.Rows, []string{result.Delta, result.Layer, result.Target, status})
+	}
+	return view
+}
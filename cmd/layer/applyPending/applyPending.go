@@ -0,0 +1,106 @@
+package applyPending
+
+import (
+	"log/slog"
+	"os"
+	"slices"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/output"
+)
+
+var ApplyPendingCmd = &cobra.Command{
+	Use:   "apply-pending",
+	Short: "Apply activations and deactivations scheduled for the next boot",
+	Long: `Apply the activations and deactivations scheduled with "activate --on-next-boot" and "deactivate --on-next-boot".
+
+Meant to run before systemd-sysext merges the layers, which bext-staged.service does on every boot. Changes that fail stay pending.`,
+	RunE: applyPendingCmd,
+}
+
+type Result struct {
+	Layer  string                 `json:"layer"`
+	Change internal.PendingChange `json:"change"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+func applyPendingCmd(cmd *cobra.Command, args []string) error {
+	state, err := internal.LoadCacheState()
+	if err != nil {
+		return err
+	}
+
+	var layers []string
+	for name, layer_state := range state.Layers {
+		if layer_state.Pending != "" {
+			layers = append(layers, name)
+		}
+	}
+	slices.Sort(layers)
+
+	results := []Result{}
+	var failed []string
+	for _, layer := range layers {
+		result := Result{Layer: layer, Change: state.PendingChange(layer)}
+		if result.Change == internal.PendingActivate {
+			err = internal.ActivateLayer(layer)
+		} else {
+			err = internal.DeactivateLayer(layer)
+		}
+		if err != nil {
+			slog.Warn("Failed applying pending change to "+layer, slog.String("change", string(result.Change)), slog.String("error", err.Error()))
+			result.Error = err.Error()
+			failed = append(failed, layer)
+		} else {
+			state.Layer(layer).Pending = ""
+		}
+		results = append(results, result)
+	}
+	warnMissingDependencies(results)
+
+	if err := state.Save(); err != nil {
+		return err
+	}
+	if err := output.Write(os.Stdout, internal.Config.OutputFormat, results, resultsView(results)); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return &internal.PendingFailedError{Layers: failed}
+	}
+	return nil
+}
+
+// activate schedules dependencies together with a layer, but the layer might have been updated since
+func warnMissingDependencies(results []Result) {
+	active, err := internal.ActiveLayers()
+	if err != nil {
+		return
+	}
+	for _, result := range results {
+		if result.Change != internal.PendingActivate || result.Error != "" {
+			continue
+		}
+		depends, err := internal.LayerDependencies(result.Layer)
+		if err != nil {
+			slog.Warn("Failed reading dependencies of "+result.Layer, slog.String("error", err.Error()))
+			continue
+		}
+		for _, dependency := range depends {
+			if !slices.Contains(active, dependency) {
+				slog.Warn("Layer "+result.Layer+" depends on "+dependency+", which is not activated", slog.String("layer", result.Layer), slog.String("dependency", dependency))
+			}
+		}
+	}
+}
+
+func resultsView(results []Result) output.Table {
+	view := output.Table{Title: "Pending changes", Header: []string{"Layer", "Change", "Error"}}
+	if internal.Config.OutputFormat == output.FormatTSV {
+		view = output.Table{Header: []string{"layer", "change", "error"}}
+	}
+	for _, result := range results {
+		view.Rows = append(view.Rows, []string{result.Layer, string(result.Change), result.Error})
+	}
+	return view
+}
@@ -10,25 +10,35 @@ import (
 	"path"
 	"path/filepath"
 	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/containers/podman/v4/libpod/define"
 	"github.com/containers/podman/v4/pkg/bindings"
-	"github.com/containers/podman/v4/pkg/bindings/containers"
 	"github.com/containers/podman/v4/pkg/bindings/images"
-	"github.com/containers/podman/v4/pkg/specgen"
 	"github.com/jedib0t/go-pretty/v6/progress"
-	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/spf13/cobra"
 	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/buildcache"
+	"github.com/ublue-os/bext/pkg/imagediff"
 	"github.com/ublue-os/bext/pkg/logging"
+	"github.com/ublue-os/bext/pkg/output"
 	"github.com/ublue-os/bext/pkg/percentmanager"
+	"github.com/ublue-os/bext/pkg/structures"
 )
 
 var BuildCmd = &cobra.Command{
-	Use:   "build [CONFIG]",
-	Short: "Build an image from a configuration file",
-	Long:  `Build an image from a configuration file`,
-	RunE:  buildCmd,
+	Use:   "build CONFIG|MANIFEST|DIR...",
+	Short: "Build images from configuration files",
+	Long: `Build images from configuration files in json, yaml or toml
+
+Besides configurations, manifests (files with a "layers" list of configurations or directories, relative to the manifest) and directories of configurations are accepted.
+Every layer is built in its own container over the same podman connection, --jobs of them at the same time, and a summary of outputs, digests and durations is printed once all are done.
+
+With --check-reproducible, every image is built a second time and the digests of both are compared. The second build rebuilds the image in the same container with nix's --rebuild, or with --check-separately the whole layer in a new container without the build cache.
+When the digests differ, the second image is kept next to the first one with a .rebuild suffix, the files that differ between both are listed and the command fails.`,
+	RunE: buildCmd,
 }
 
 var (
@@ -37,190 +47,325 @@ var (
 	fRecipeMakerFlake  *string
 	fRecipeMakerAction *string
 	fOutputPath        *string
+	fOutputDir         *string
 	fNoPull            *bool
 	fKeep              *bool
+	fJobs              *int
+	fReport            *string
+	fCacheVolume       *string
+	fCheckReproducible *bool
+	fCheckSeparately   *bool
 )
 
 func init() {
-	fNixosImage = BuildCmd.Flags().StringP("image", "i", "docker.io/nixos/nix", "Image that will be used for building the nix image")
+	fNixosImage = BuildCmd.Flags().StringP("image", "i", internal.DefaultBuildImage, "Image that will be used for building the nix image, --tag is added when it has no tag")
 	fNixosImageTag = BuildCmd.Flags().StringP("tag", "t", "latest", "Image tag used for the building container")
-	fRecipeMakerFlake = BuildCmd.Flags().StringP("recipe-flake", "r", "github:ublue-os/bext", "Nix flake that will be used as base for building the image")
+	fRecipeMakerFlake = BuildCmd.Flags().StringP("recipe-flake", "r", internal.DefaultRecipeFlake, "Nix flake that will be used as base for building the image, local paths are mounted into the build container")
 	fRecipeMakerAction = BuildCmd.Flags().StringP("recipe-action", "a", "bake-recipe", "Derivation that will be built on recipe-flake")
-	fOutputPath = BuildCmd.Flags().StringP("output-path", "o", "", "Path of the file for the image")
+	fOutputPath = BuildCmd.Flags().StringP("output-path", "o", "", "Path of the file for the image, only when building a single layer")
+	fOutputDir = BuildCmd.Flags().String("output-dir", "", "Directory images are written to, unless their manifest sets output-dir (default current directory)")
 	fNoPull = BuildCmd.Flags().Bool("no-pull", false, "Do not pull the nix image even if conditions are met")
 	fKeep = BuildCmd.Flags().Bool("keep", false, "Keep the build containers instead of getting rid of them (Mostly for debugging issues)")
+	fJobs = BuildCmd.Flags().IntP("jobs", "j", 2, "How many layers are built at the same time")
+	fReport = BuildCmd.Flags().String("report", "", "Also write the summary as json to this file")
+	fCacheVolume = BuildCmd.Flags().String("cache-volume", "", "Podman volume (or host directory, when it contains a slash) kept as the containers' /nix so builds reuse the store")
+	fCheckReproducible = BuildCmd.Flags().Bool("check-reproducible", false, "Build every image twice and compare both, failing when they differ")
+	fCheckSeparately = BuildCmd.Flags().Bool("check-separately", false, "Do the second build of --check-reproducible in a new container without the build cache")
+}
+
+type Result struct {
+	Layer  string `json:"layer"`
+	Config string `json:"config"`
+	Output string `json:"output"`
+	// md5, the same digest cached blobs are named after
+	Digest      string  `json:"digest,omitempty"`
+	Size        int64   `json:"size,omitempty"`
+	Duration    float64 `json:"duration_seconds"`
+	ContainerID string  `json:"container_id,omitempty"`
+	Error       string  `json:"error,omitempty"`
+	// Only set with --check-reproducible
+	Reproducible  *bool              `json:"reproducible,omitempty"`
+	RebuildDigest string             `json:"rebuild_digest,omitempty"`
+	Rebuild       string             `json:"rebuild,omitempty"`
+	Differences   []imagediff.Change `json:"differences,omitempty"`
+}
+
+type Report struct {
+	Image       string    `json:"image"`
+	CacheVolume string    `json:"cache_volume,omitempty"`
+	Recipe      string    `json:"recipe"`
+	Started     time.Time `json:"started"`
+	Duration    float64   `json:"duration_seconds"`
+	Results     []Result  `json:"results"`
 }
 
 func buildCmd(cmd *cobra.Command, args []string) error {
 	if len(args) < 1 {
 		return internal.NewPositionalError("CONFIG")
 	}
-
-	pw := percent.NewProgressWriter()
-
-	var (
-		expectedSections = 6
-		expectedTrackers = 1
-	)
-
-	if *fKeep {
-		expectedSections--
+	if *fJobs < 1 {
+		return internal.NewInvalidOptionError("jobs")
 	}
-	if !*fNoPull {
-		expectedTrackers++
+	if *fCheckSeparately && !*fCheckReproducible {
+		return internal.NewInvalidOptionError("check-separately")
 	}
-
-	pw.SetNumTrackersExpected(expectedTrackers)
-	build_tracker := percent.NewIncrementTracker(&progress.Tracker{
-		Message: "Building image",
-		Total:   int64(100),
-		Units:   progress.UnitsDefault},
-		expectedSections)
-
-	if !*internal.Config.NoProgress {
-		go pw.Render()
-		slog.SetDefault(logging.NewMuteLogger())
+	// The tag would be silently ignored otherwise
+	if cmd.Flags().Changed("tag") && internal.HasImageTag(*fNixosImage) {
+		return internal.NewInvalidOptionError("tag")
+	}
+	check := checkNone
+	if *fCheckSeparately {
+		check = checkSeparate
+	} else if *fCheckReproducible {
+		check = checkRebuild
 	}
-	pw.AppendTracker(build_tracker.Tracker)
 
-	config_file_path, err := filepath.Abs(path.Clean(args[0]))
+	output_dir := *fOutputDir
+	if output_dir == "" {
+		var err error
+		output_dir, err = os.Getwd()
+		if err != nil {
+			return err
+		}
+	}
+	targets, err := collectTargets(args, output_dir)
 	if err != nil {
 		return err
 	}
-	config_data, err := os.ReadFile(config_file_path)
+	if len(targets) == 0 {
+		return internal.NewPositionalError("CONFIG")
+	}
+	out_paths, err := outputPaths(targets)
 	if err != nil {
 		return err
 	}
-
-	configuration := &internal.LayerConfiguration{}
-
-	err = json.Unmarshal(config_data, configuration)
+	layer_recipe, err := resolveRecipe(*fRecipeMakerFlake)
 	if err != nil {
 		return err
 	}
 
-	sock_dir := os.Getenv("XDG_RUNTIME_DIR")
-	if sock_dir == "" {
-		sock_dir = "/var/run"
+	pw := percent.NewProgressWriter()
+	expectedTrackers := len(targets)
+	if !*fNoPull {
+		expectedTrackers++
+	}
+	pw.SetNumTrackersExpected(expectedTrackers)
+	if !*internal.Config.NoProgress {
+		go pw.Render()
+		slog.SetDefault(logging.NewMuteLogger())
 	}
-	socket := "unix:" + sock_dir + "/podman/podman.sock"
+
+	socket := "unix:" + internal.PodmanSocketPath()
 
 	conn, err := bindings.NewConnection(context.Background(), socket)
 	if err != nil {
 		slog.Warn("A podman socket is required, enable it with \"systemctl enable --now --user podman.socket\"")
 		return err
 	}
-	build_tracker.IncrementSection()
 
-	full_image_name := *fNixosImage + ":" + *fNixosImageTag
+	full_image_name := internal.ImageReference(*fNixosImage, *fNixosImageTag)
 
+	// Pulled once up front, every build shares the image
 	if !*fNoPull {
-		image_summary, err := images.List(conn, &images.ListOptions{All: &[]bool{true}[0]})
-		if err != nil {
+		if err := pullImage(conn, pw, full_image_name); err != nil {
 			return err
 		}
+	}
 
-		var already_has_image = false
-		for _, image := range image_summary {
-			if slices.Contains(image.History, full_image_name) {
-				already_has_image = true
+	cache_volume := ""
+	if *fCacheVolume != "" {
+		var created bool
+		cache_volume, created, err = buildcache.Ensure(conn, *fCacheVolume)
+		if err != nil {
+			return err
+		}
+		// Podman fills the volume from the image when it is first mounted, which should not happen in several builds at once
+		if created {
+			slog.Info("Initializing build cache", slog.String("volume", cache_volume))
+			if _, err := buildcache.Run(conn, full_image_name, cache_volume, []string{"true"}); err != nil {
+				return err
 			}
 		}
+	}
 
-		if !already_has_image {
-			slog.Info("Pulling image", slog.String("image name", full_image_name))
-			tracker := progress.Tracker{Message: "Pulling image", Total: int64(100), Units: progress.UnitsDefault}
-			pw.AppendTracker(&tracker)
-
-			var pull_opt = &images.PullOptions{ProgressWriter: &io.Discard}
-			if *internal.Config.NoProgress {
-				pull_opt = &images.PullOptions{}
+	env := buildEnvironment{conn: conn, image: full_image_name, cache_volume: cache_volume, recipe: layer_recipe, check: check}
+	report := Report{Image: full_image_name, CacheVolume: cache_volume, Recipe: *fRecipeMakerFlake, Started: time.Now()}
+	report.Results = make([]Result, len(targets))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < min(*fJobs, len(targets)); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				tracker := newBuildTracker(targets[i].configuration.Name, check)
+				pw.AppendTracker(tracker.Tracker)
+				report.Results[i] = buildLayer(env, targets[i], out_paths[i], tracker)
 			}
+		}()
+	}
+	for i := range targets {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	report.Duration = time.Since(report.Started).Round(time.Millisecond).Seconds()
 
-			tracker.Increment(0)
-			if _, err := images.Pull(conn, full_image_name, pull_opt); err != nil {
-				return err
-			}
-			tracker.Increment(100)
+	if !*internal.Config.NoProgress {
+		pw.Stop()
+		for pw.IsRenderInProgress() {
+			time.Sleep(10 * time.Millisecond)
 		}
 	}
-	build_tracker.IncrementSection()
 
-	pwd, err := os.Getwd()
-	if err != nil {
+	if *fReport != "" {
+		json_report, err := json.MarshalIndent(report, "", structures.INDENTATION)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*fReport, json_report, 0644); err != nil {
+			return err
+		}
+	}
+	if err := output.Write(os.Stdout, internal.Config.OutputFormat, report, reportView(report)); err != nil {
 		return err
 	}
 
-	var out_path string
+	var failed, not_reproducible []string
+	for _, result := range report.Results {
+		if result.Error != "" {
+			failed = append(failed, result.Layer)
+		} else if result.Reproducible != nil && !*result.Reproducible {
+			not_reproducible = append(not_reproducible, result.Layer)
+			// Machine-readable formats already have the differences in their results
+			if internal.Config.OutputFormat == output.FormatTable {
+				if err := output.Write(os.Stdout, output.FormatTable, result.Differences, differencesView(result)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return &internal.BuildFailedError{Layers: failed}
+	}
+	if len(not_reproducible) > 0 {
+		return &internal.NotReproducibleError{Layers: not_reproducible}
+	}
+	return nil
+}
+
+// Images are named after their layer, so two layers of the same name can not be written to the same directory
+func outputPaths(targets []target) ([]string, error) {
 	if *fOutputPath != "" {
-		out_path, err = filepath.Abs(path.Clean(*fOutputPath))
-		if err != nil {
-			build_tracker.Tracker.MarkAsErrored()
-			return err
+		if len(targets) != 1 {
+			return nil, internal.NewInvalidOptionError("output-path")
 		}
-	} else {
-		out_path, err = filepath.Abs(path.Join(pwd, configuration.Name+internal.ValidSysextExtension))
+		out_path, err := filepath.Abs(path.Clean(*fOutputPath))
 		if err != nil {
-			build_tracker.Tracker.MarkAsErrored()
-			return err
+			return nil, err
 		}
+		return []string{out_path}, nil
 	}
 
-	nix_flags := "-L --extra-experimental-features nix-command --extra-experimental-features flakes --impure"
+	var out_paths []string
+	for _, layer := range targets {
+		out_path, err := filepath.Abs(path.Join(layer.output_dir, layer.configuration.Name+internal.ValidSysextExtension))
+		if err != nil {
+			return nil, err
+		}
+		if slices.Contains(out_paths, out_path) {
+			return nil, &internal.DuplicateOutputError{Path: out_path}
+		}
+		out_paths = append(out_paths, out_path)
+	}
+	return out_paths, nil
+}
 
-	spec := specgen.NewSpecGenerator(full_image_name, false)
-	spec.Mounts = append(spec.Mounts, specs.Mount{
-		Source:      path.Dir(out_path),
-		Destination: "/out",
-		Type:        define.TypeBind,
-		Options:     []string{"Z", "rw"},
-	})
-	spec.Mounts = append(spec.Mounts, specs.Mount{
-		Source:      config_file_path,
-		Destination: "/config.json",
-		Type:        define.TypeBind,
-		Options:     []string{"Z", "ro"},
-	})
+func pullImage(conn context.Context, pw progress.Writer, full_image_name string) error {
+	image_summary, err := images.List(conn, &images.ListOptions{All: &[]bool{true}[0]})
+	if err != nil {
+		return err
+	}
 
-	build_tracker.IncrementSection()
-	spec.Env = map[string]string{"BEXT_CONFIG_FILE": "/config.json"}
-	spec.WorkDir = "/out"
+	for _, image := range image_summary {
+		if slices.Contains(image.History, full_image_name) {
+			return nil
+		}
+	}
 
-	container_command := fmt.Sprintf(`set -eux ; NIXPKGS_ALLOW_UNFREE=1 nix build %s %s#%s -o result && cp -f ./result ./%s && rm ./result`, nix_flags, *fRecipeMakerFlake, *fRecipeMakerAction, path.Base(out_path))
+	slog.Info("Pulling image", slog.String("image name", full_image_name))
+	tracker := progress.Tracker{Message: "Pulling image", Total: int64(100), Units: progress.UnitsDefault}
+	pw.AppendTracker(&tracker)
 
-	spec.Command = []string{"/bin/sh", "-c", container_command}
-	createResponse, err := containers.CreateWithSpec(conn, spec, nil)
-	if err != nil {
-		build_tracker.Tracker.MarkAsErrored()
-		return err
+	var pull_opt = &images.PullOptions{ProgressWriter: &io.Discard}
+	if *internal.Config.NoProgress {
+		pull_opt = &images.PullOptions{}
 	}
 
-	slog.Info("Starting build container", slog.String("containerID", createResponse.ID))
-	build_tracker.IncrementSection()
-	if err := containers.Start(conn, createResponse.ID, nil); err != nil {
-		build_tracker.Tracker.MarkAsErrored()
+	tracker.Increment(0)
+	if _, err := images.Pull(conn, full_image_name, pull_opt); err != nil {
+		tracker.MarkAsErrored()
 		return err
 	}
+	tracker.Increment(100)
+	return nil
+}
 
-	slog.Info("Waiting for container response", slog.String("containerID", createResponse.ID))
-	build_tracker.IncrementSection()
-	if _, err := containers.Wait(conn, createResponse.ID, nil); err != nil {
-		build_tracker.Tracker.MarkAsErrored()
-		return err
+func reportView(report Report) output.Table {
+	view := output.Table{Title: "Build", Header: []string{"Layer", "Output", "Digest", "Size", "Duration", "Status"}}
+	tsv := internal.Config.OutputFormat == output.FormatTSV
+	if tsv {
+		view.Header = []string{"layer", "config", "output", "digest", "size", "duration_seconds", "error", "reproducible", "rebuild_digest", "differences"}
 	}
 
-	build_tracker.IncrementSection()
-	if !*fKeep {
-		slog.Debug("Deleting build container", slog.String("containerID", createResponse.ID))
-		if _, err := containers.Remove(conn, createResponse.ID, nil); err != nil {
-			build_tracker.Tracker.MarkAsErrored()
-			return err
+	for _, result := range report.Results {
+		if tsv {
+			reproducible := ""
+			if result.Reproducible != nil {
+				reproducible = strconv.FormatBool(*result.Reproducible)
+			}
+			view.Rows = append(view.Rows, []string{result.Layer, result.Config, result.Output, result.Digest, strconv.FormatInt(result.Size, 10), strconv.FormatFloat(result.Duration, 'f', 3, 64), result.Error,
+				reproducible, result.RebuildDigest, strconv.Itoa(len(result.Differences))})
+			continue
 		}
-		build_tracker.Tracker.MarkAsDone()
+		status := "built"
+		switch {
+		case result.Error != "":
+			status = "failed: " + result.Error
+		case result.Reproducible == nil:
+		case *result.Reproducible:
+			status = "built, reproducible"
+		case len(result.Differences) == 0:
+			// Files are the same, so the difference is in the filesystem itself, like its creation time
+			status = "built, not reproducible: images differ outside of their files"
+		default:
+			status = fmt.Sprintf("built, not reproducible: %d paths differ", len(result.Differences))
+		}
+		size := ""
+		if result.Size > 0 {
+			size = strconv.FormatInt(result.Size/1024/1024, 10) + " MiB"
+		}
+		view.Rows = append(view.Rows, []string{result.Layer, result.Output, result.Digest, size, (time.Duration(result.Duration * float64(time.Second))).String(), status})
 	}
-	build_tracker.Tracker.MarkAsDone()
-
-	slog.Info(fmt.Sprintf("Successfully built %s", path.Base(out_path)), slog.String("containerId", createResponse.ID), slog.String("imagename", out_path))
+	return view
+}
 
-	return nil
+func differencesView(result Result) output.Table {
+	view := output.Table{Title: "Differences of " + result.Layer + " (" + result.Digest + " and " + result.RebuildDigest + ")", Header: []string{"Path", "Change", "Details", "Size"}}
+	for _, change := range result.Differences {
+		size := ""
+		switch change.Kind {
+		case imagediff.KindAdded:
+			size = strconv.FormatInt(change.NewSize, 10)
+		case imagediff.KindRemoved:
+			size = strconv.FormatInt(change.OldSize, 10)
+		case imagediff.KindModified:
+			if change.OldSize != change.NewSize {
+				size = strconv.FormatInt(change.OldSize, 10) + " -> " + strconv.FormatInt(change.NewSize, 10)
+			}
+		}
+		view.Rows = append(view.Rows, []string{change.Path, string(change.Kind), strings.Join(change.Details, ", "), size})
+	}
+	return view
 }
@@ -0,0 +1,180 @@
+package build
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"time"
+
+	"github.com/containers/podman/v4/libpod/define"
+	"github.com/containers/podman/v4/pkg/bindings/containers"
+	"github.com/containers/podman/v4/pkg/specgen"
+	"github.com/jedib0t/go-pretty/v6/progress"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/buildcache"
+	"github.com/ublue-os/bext/pkg/filecomp"
+	"github.com/ublue-os/bext/pkg/percentmanager"
+	"github.com/ublue-os/bext/pkg/structures"
+)
+
+// What every build of a run shares
+type buildEnvironment struct {
+	conn         context.Context
+	image        string
+	cache_volume string
+	recipe       recipe
+	check        reproducibilityCheck
+}
+
+// Builds a single layer in its own container, every failure ends up in the result instead of stopping other builds
+func buildLayer(env buildEnvironment, layer target, out_path string, tracker *percent.IncrementTracker) Result {
+	result := Result{Layer: layer.configuration.Name, Config: layer.config_path, Output: out_path}
+	started := time.Now()
+
+	container_id, err := runBuildContainer(env, layer, out_path, tracker)
+	result.ContainerID = container_id
+	result.Duration = time.Since(started).Round(time.Millisecond).Seconds()
+	if err != nil {
+		tracker.Tracker.MarkAsErrored()
+		result.Error = err.Error()
+		slog.Warn("Failed building "+layer.configuration.Name, slog.String("config", layer.config_path), slog.String("error", err.Error()))
+		return result
+	}
+
+	image_file, err := os.Open(out_path)
+	if err != nil {
+		tracker.Tracker.MarkAsErrored()
+		result.Error = err.Error()
+		return result
+	}
+	defer image_file.Close()
+	if info, err := image_file.Stat(); err == nil {
+		result.Size = info.Size()
+	}
+	// Same digest layer add names cached blobs after
+	digest, err := filecomp.StreamChecksum(image_file, md5.New())
+	if err != nil {
+		tracker.Tracker.MarkAsErrored()
+		result.Error = err.Error()
+		return result
+	}
+	result.Digest = hex.EncodeToString(digest)
+
+	if env.check != checkNone {
+		if err := checkReproducible(env, layer, out_path, &result, tracker); err != nil {
+			tracker.Tracker.MarkAsErrored()
+			result.Error = err.Error()
+			slog.Warn("Failed checking whether "+layer.configuration.Name+" is reproducible", slog.String("error", err.Error()))
+			return result
+		}
+	}
+
+	tracker.Tracker.MarkAsDone()
+	slog.Info(fmt.Sprintf("Successfully built %s", path.Base(out_path)), slog.String("containerId", container_id), slog.String("imagename", out_path))
+	return result
+}
+
+func runBuildContainer(env buildEnvironment, layer target, out_path string, tracker *percent.IncrementTracker) (string, error) {
+	// The recipe flake only reads json, so yaml and toml configurations are handed over converted
+	json_config, err := json.MarshalIndent(layer.configuration, "", structures.INDENTATION)
+	if err != nil {
+		return "", err
+	}
+	json_config_file, err := os.CreateTemp("", "bext-config-*.json")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(json_config_file.Name())
+	if _, err := json_config_file.Write(json_config); err != nil {
+		json_config_file.Close()
+		return "", err
+	}
+	if err := json_config_file.Close(); err != nil {
+		return "", err
+	}
+
+	spec := specgen.NewSpecGenerator(env.image, false)
+	// Concurrent builds share the output directory, a private label would take it away from the ones already running
+	spec.Mounts = append(spec.Mounts, specs.Mount{
+		Source:      path.Dir(out_path),
+		Destination: "/out",
+		Type:        define.TypeBind,
+		Options:     []string{"z", "rw"},
+	})
+	spec.Mounts = append(spec.Mounts, specs.Mount{
+		Source:      json_config_file.Name(),
+		Destination: "/config.json",
+		Type:        define.TypeBind,
+		Options:     []string{"Z", "ro"},
+	})
+	if env.recipe.Source != "" {
+		spec.Mounts = append(spec.Mounts, specs.Mount{
+			Source:      env.recipe.Source,
+			Destination: recipeMountPath,
+			Type:        define.TypeBind,
+			Options:     []string{"z", "ro"},
+		})
+	}
+	if env.cache_volume != "" {
+		spec.Volumes = append(spec.Volumes, buildcache.Mount(env.cache_volume))
+	}
+	spec.Env = map[string]string{"BEXT_CONFIG_FILE": "/config.json", "BEXT_FLAKE_METADATA_FILE": flakeMetadataPath}
+	spec.WorkDir = "/out"
+
+	rebuild_name := ""
+	if env.check == checkRebuild {
+		rebuild_name = path.Base(rebuildPath(out_path))
+	}
+	spec.Command = []string{"/bin/sh", "-c", buildScript(env.recipe, *fRecipeMakerAction, layer.configuration, path.Base(out_path), rebuild_name)}
+
+	tracker.IncrementSection()
+	createResponse, err := containers.CreateWithSpec(env.conn, spec, nil)
+	if err != nil {
+		return "", err
+	}
+
+	slog.Info("Starting build container", slog.String("containerID", createResponse.ID), slog.String("layer", layer.configuration.Name))
+	tracker.IncrementSection()
+	if err := containers.Start(env.conn, createResponse.ID, nil); err != nil {
+		return createResponse.ID, err
+	}
+
+	slog.Info("Waiting for container response", slog.String("containerID", createResponse.ID), slog.String("layer", layer.configuration.Name))
+	tracker.IncrementSection()
+	exit_code, err := containers.Wait(env.conn, createResponse.ID, nil)
+	if err != nil {
+		return createResponse.ID, err
+	}
+
+	tracker.IncrementSection()
+	if !*fKeep {
+		slog.Debug("Deleting build container", slog.String("containerID", createResponse.ID))
+		if _, err := containers.Remove(env.conn, createResponse.ID, nil); err != nil {
+			return createResponse.ID, err
+		}
+	}
+
+	if exit_code != 0 {
+		return createResponse.ID, &internal.BuildContainerError{ContainerID: createResponse.ID, ExitCode: int(exit_code)}
+	}
+	return createResponse.ID, nil
+}
+
+// Separate containers for checking reproducibility go through every section twice
+func newBuildTracker(layer string, check reproducibilityCheck) *percent.IncrementTracker {
+	sections := 4
+	if check == checkSeparate {
+		sections = 8
+	}
+	return percent.NewIncrementTracker(&progress.Tracker{
+		Message: "Building " + layer,
+		Total:   int64(100),
+		Units:   progress.UnitsDefault},
+		sections)
+}
@@ -0,0 +1,120 @@
+package build
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+
+	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/structures"
+)
+
+// A manifest lists configurations (or directories of them) built together, relative paths are relative to the manifest
+type Manifest struct {
+	Layers []string `json:"layers"`
+	// Where the images of the listed layers are written to, --output-dir when not set
+	OutputDir string `json:"output-dir,omitempty"`
+}
+
+// A configuration that will be built and where its image goes
+type target struct {
+	config_path   string
+	output_dir    string
+	configuration *internal.LayerConfiguration
+}
+
+// Expands manifests and directories into the configurations they list, every configuration is loaded (and validated) once
+func collectTargets(paths []string, output_dir string) ([]target, error) {
+	var (
+		targets []target
+		visited []string
+	)
+
+	var collect func(entry string, output_dir string) error
+	collect = func(entry string, output_dir string) error {
+		entry, err := filepath.Abs(path.Clean(entry))
+		if err != nil {
+			return err
+		}
+		if slices.Contains(visited, entry) {
+			return nil
+		}
+		visited = append(visited, entry)
+
+		info, err := os.Stat(entry)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			dir_entries, err := os.ReadDir(entry)
+			if err != nil {
+				return err
+			}
+			for _, dir_entry := range dir_entries {
+				if dir_entry.IsDir() || structures.FormatFromPath(dir_entry.Name()) == "" {
+					continue
+				}
+				if err := collect(path.Join(entry, dir_entry.Name()), output_dir); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		data, err := internal.ReadLayerConfiguration(entry)
+		if err != nil {
+			return err
+		}
+		if manifest, is_manifest := parseManifest(data); is_manifest {
+			manifest_output := output_dir
+			if manifest.OutputDir != "" {
+				manifest_output = resolveRelative(path.Dir(entry), manifest.OutputDir)
+			}
+			for _, layer := range manifest.Layers {
+				if err := collect(resolveRelative(path.Dir(entry), layer), manifest_output); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		configuration, err := internal.LoadLayerConfiguration(entry)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, target{config_path: entry, output_dir: output_dir, configuration: configuration})
+		return nil
+	}
+
+	for _, entry := range paths {
+		if err := collect(entry, output_dir); err != nil {
+			return nil, err
+		}
+	}
+	return targets, nil
+}
+
+// Manifests are told apart from configurations by their layers key
+func parseManifest(data []byte) (*Manifest, bool) {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, false
+	}
+	if _, exists := keys["layers"]; !exists {
+		return nil, false
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, false
+	}
+	return manifest, true
+}
+
+func resolveRelative(base string, target string) string {
+	if path.IsAbs(target) {
+		return target
+	}
+	return path.Join(base, target)
+}
@@ -0,0 +1,83 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ublue-os/bext/internal"
+)
+
+const (
+	nixFeatures = "--extra-experimental-features nix-command --extra-experimental-features flakes"
+	// Where local recipe flakes are mounted in build containers
+	recipeMountPath = "/recipe"
+	// Written by nix flake metadata, read by the recipe flake for metadata.json
+	flakeMetadataPath = "/tmp/flake-metadata.json"
+)
+
+// The flake layers are built with, Source is only set for local flakes that have to be mounted
+type recipe struct {
+	Flake  string
+	Source string
+}
+
+// Local paths (absolute, relative or path: references to existing directories) are mounted, everything else is handed to nix as it is
+func resolveRecipe(flake string) (recipe, error) {
+	local_path, has_prefix := strings.CutPrefix(flake, "path:")
+	is_path := has_prefix || strings.HasPrefix(flake, "/") || strings.HasPrefix(flake, ".")
+	if !is_path {
+		return recipe{Flake: flake}, nil
+	}
+
+	source, err := filepath.Abs(filepath.Clean(local_path))
+	if err != nil {
+		return recipe{}, err
+	}
+	if _, err := os.Stat(filepath.Join(source, "flake.nix")); err != nil {
+		return recipe{}, &internal.RecipeNotFoundError{Path: source}
+	}
+	// path: instead of letting nix pick git+file, which would need the repository to be owned by the container's user
+	return recipe{Flake: "path:" + recipeMountPath, Source: source}, nil
+}
+
+// A bare revision or branch means nixpkgs on GitHub, anything with a colon is already a flake reference
+func nixpkgsInput(nixpkgs string) string {
+	if strings.Contains(nixpkgs, ":") {
+		return nixpkgs
+	}
+	return "github:NixOS/nixpkgs/" + nixpkgs
+}
+
+// Records the locked inputs first, then builds with the same inputs. Local flakes are mounted read-only, so their lock file is never written.
+// With a rebuild_name, the image is built a second time with --rebuild, nix keeps a differing result next to the first one as .check.
+func buildScript(layer_recipe recipe, action string, configuration *internal.LayerConfiguration, image_name string, rebuild_name string) string {
+	flake_args := []string{shellQuote(layer_recipe.Flake)}
+	if configuration.Nixpkgs != "" {
+		flake_args = append(flake_args, "--override-input", "nixpkgs", shellQuote(nixpkgsInput(configuration.Nixpkgs)))
+	}
+	if layer_recipe.Source != "" {
+		flake_args = append(flake_args, "--no-write-lock-file")
+	}
+
+	build_args := append([]string{shellQuote(layer_recipe.Flake + "#" + action)}, flake_args[1:]...)
+
+	steps := []string{
+		"set -eux",
+		"nix " + nixFeatures + " flake metadata --json " + strings.Join(flake_args, " ") + " > " + flakeMetadataPath,
+		// The result link stays inside of the container, since other builds may share /out
+		"NIXPKGS_ALLOW_UNFREE=1 nix build -L " + nixFeatures + " --impure " + strings.Join(build_args, " ") + " -o /tmp/result",
+		"cp -f /tmp/result ./" + shellQuote(image_name),
+	}
+	if rebuild_name != "" {
+		// Without a .check there was a failure other than a differing result, so copying it fails the build
+		steps = append(steps, "if NIXPKGS_ALLOW_UNFREE=1 nix build -L "+nixFeatures+" --impure --rebuild --keep-failed "+strings.Join(build_args, " ")+" -o /tmp/result ; "+
+			"then cp -f /tmp/result ./"+shellQuote(rebuild_name)+" ; "+
+			"else cp -f \"$(readlink -f /tmp/result).check\" ./"+shellQuote(rebuild_name)+" ; fi")
+	}
+	return strings.Join(steps, " ; ")
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,89 @@
+package build
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+
+	"github.com/ublue-os/bext/pkg/filecomp"
+	"github.com/ublue-os/bext/pkg/imagediff"
+	"github.com/ublue-os/bext/pkg/percentmanager"
+	"github.com/ublue-os/bext/pkg/squashfs"
+)
+
+type reproducibilityCheck int
+
+const (
+	checkNone reproducibilityCheck = iota
+	// nix rebuilds the image derivation in the same container, reusing everything it depends on
+	checkRebuild
+	// The whole build runs again in a new container without the build cache
+	checkSeparate
+)
+
+// The second image is kept next to the first one when they differ
+func rebuildPath(out_path string) string {
+	return out_path + ".rebuild"
+}
+
+func checkReproducible(env buildEnvironment, layer target, out_path string, result *Result, tracker *percent.IncrementTracker) error {
+	rebuild_path := rebuildPath(out_path)
+	if env.check == checkSeparate {
+		separate := env
+		separate.cache_volume = ""
+		separate.check = checkNone
+		slog.Info("Building again in a separate container", slog.String("layer", layer.configuration.Name))
+		if _, err := runBuildContainer(separate, layer, rebuild_path, tracker); err != nil {
+			return err
+		}
+	}
+
+	rebuild_digest, err := fileDigest(rebuild_path)
+	if err != nil {
+		return err
+	}
+	result.RebuildDigest = rebuild_digest
+	reproducible := rebuild_digest == result.Digest
+	result.Reproducible = &reproducible
+	if reproducible {
+		return os.Remove(rebuild_path)
+	}
+
+	result.Rebuild = rebuild_path
+	result.Differences, err = compareImages(out_path, rebuild_path)
+	if err != nil {
+		return err
+	}
+	slog.Warn(fmt.Sprintf("%s is not reproducible, %d paths differ", path.Base(out_path), len(result.Differences)), slog.String("first", result.Digest), slog.String("second", rebuild_digest))
+	return nil
+}
+
+func fileDigest(file_path string) (string, error) {
+	file, err := os.Open(file_path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	digest, err := filecomp.StreamChecksum(file, md5.New())
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(digest), nil
+}
+
+func compareImages(first_path string, second_path string) ([]imagediff.Change, error) {
+	first, err := squashfs.Open(first_path)
+	if err != nil {
+		return nil, err
+	}
+	defer first.Close()
+	second, err := squashfs.Open(second_path)
+	if err != nil {
+		return nil, err
+	}
+	defer second.Close()
+	return imagediff.Compare(first, second)
+}
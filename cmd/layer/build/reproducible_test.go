@@ -0,0 +1,157 @@
+package build
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ublue-os/bext/internal"
+)
+
+const testImage = "../../../pkg/squashfs/testdata/layer.sqfs"
+
+func copyImage(t *testing.T, dest string, trailing []byte) {
+	t.Helper()
+	data, err := os.ReadFile(testImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Bytes past the end of the filesystem change the digest, not the contents
+	if err := os.WriteFile(dest, append(data, trailing...), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkRebuilt(t *testing.T, out_path string) Result {
+	t.Helper()
+	digest, err := fileDigest(out_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := Result{Output: out_path, Digest: digest}
+	if err := checkReproducible(buildEnvironment{check: checkRebuild}, target{}, out_path, &result, nil); err != nil {
+		t.Fatal(err)
+	}
+	if result.Reproducible == nil {
+		t.Fatal("reproducible is not set")
+	}
+	return result
+}
+
+func TestCheckReproducible(t *testing.T) {
+	out_path := filepath.Join(t.TempDir(), "example.sysext.raw")
+	copyImage(t, out_path, nil)
+	copyImage(t, rebuildPath(out_path), nil)
+
+	result := checkRebuilt(t, out_path)
+	if !*result.Reproducible || result.RebuildDigest != result.Digest {
+		t.Errorf("identical images are not reproducible: %s and %s", result.Digest, result.RebuildDigest)
+	}
+	if result.Rebuild != "" || len(result.Differences) != 0 {
+		t.Errorf("identical images reported %s with %d differences", result.Rebuild, len(result.Differences))
+	}
+	if _, err := os.Stat(rebuildPath(out_path)); !os.IsNotExist(err) {
+		t.Errorf("the identical rebuild was kept: %v", err)
+	}
+}
+
+func TestCheckNotReproducible(t *testing.T) {
+	out_path := filepath.Join(t.TempDir(), "example.sysext.raw")
+	copyImage(t, out_path, nil)
+	copyImage(t, rebuildPath(out_path), make([]byte, 4096))
+
+	result := checkRebuilt(t, out_path)
+	if *result.Reproducible || result.RebuildDigest == result.Digest {
+		t.Errorf("differing images are reproducible: %s and %s", result.Digest, result.RebuildDigest)
+	}
+	if result.Rebuild != rebuildPath(out_path) {
+		t.Errorf("rebuild is %q, want %q", result.Rebuild, rebuildPath(out_path))
+	}
+	if _, err := os.Stat(result.Rebuild); err != nil {
+		t.Errorf("the differing rebuild was not kept: %v", err)
+	}
+	// Only the padding differs, the files inside are the same
+	if len(result.Differences) != 0 {
+		t.Errorf("got %d differences between images with the same files: %+v", len(result.Differences), result.Differences)
+	}
+}
+
+func TestCheckReproducibleInvalidImage(t *testing.T) {
+	out_path := filepath.Join(t.TempDir(), "example.sysext.raw")
+	if err := os.WriteFile(out_path, []byte("not an image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(rebuildPath(out_path), []byte("not an image either"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	digest, err := fileDigest(out_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := Result{Output: out_path, Digest: digest}
+	if err := checkReproducible(buildEnvironment{check: checkRebuild}, target{}, out_path, &result, nil); err == nil {
+		t.Error("comparing files that are not images succeeded")
+	}
+}
+
+func TestBuildScript(t *testing.T) {
+	for _, test := range []struct {
+		name         string
+		recipe       recipe
+		nixpkgs      string
+		image_name   string
+		rebuild_name string
+		contains     []string
+		not_contains []string
+	}{
+		{
+			name:         "single build",
+			recipe:       recipe{Flake: "github:ublue-os/bext"},
+			image_name:   "example.sysext.raw",
+			contains:     []string{"'github:ublue-os/bext#build'", "./'example.sysext.raw'"},
+			not_contains: []string{"--rebuild", "--no-write-lock-file", "--override-input"},
+		},
+		{
+			name:         "rebuild",
+			recipe:       recipe{Flake: "github:ublue-os/bext"},
+			image_name:   "example.sysext.raw",
+			rebuild_name: "example.sysext.raw.rebuild",
+			contains:     []string{"--rebuild --keep-failed", "./'example.sysext.raw.rebuild'", `"$(readlink -f /tmp/result).check"`},
+		},
+		{
+			name:       "local recipe and pinned nixpkgs",
+			recipe:     recipe{Flake: "path:" + recipeMountPath, Source: "/home/user/recipe"},
+			nixpkgs:    "nixos-24.05",
+			image_name: "example.sysext.raw",
+			contains:   []string{"--no-write-lock-file", "--override-input nixpkgs 'github:NixOS/nixpkgs/nixos-24.05'", "'path:/recipe#build'"},
+		},
+		{
+			name:         "quoted names",
+			recipe:       recipe{Flake: "github:ublue-os/bext"},
+			image_name:   "it's.sysext.raw",
+			rebuild_name: "it's.sysext.raw.rebuild",
+			contains:     []string{`./'it'\''s.sysext.raw'`, `./'it'\''s.sysext.raw.rebuild'`},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			configuration := &internal.LayerConfiguration{Nixpkgs: test.nixpkgs}
+			script := buildScript(test.recipe, "build", configuration, test.image_name, test.rebuild_name)
+			for _, part := range test.contains {
+				if !strings.Contains(script, part) {
+					t.Errorf("script does not contain %s:\n%s", part, script)
+				}
+			}
+			for _, part := range test.not_contains {
+				if strings.Contains(script, part) {
+					t.Errorf("script contains %s:\n%s", part, script)
+				}
+			}
+			if out, err := exec.Command("sh", "-n", "-c", script).CombinedOutput(); err != nil {
+				t.Errorf("invalid script: %s\n%s", out, script)
+			}
+		})
+	}
+}
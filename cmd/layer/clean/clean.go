@@ -2,31 +2,121 @@ package clean
 
 import (
 	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 	"path"
 	"path/filepath"
-	"sync"
+	"strconv"
+	"strings"
 
+	"github.com/jedib0t/go-pretty/v6/progress"
 	"github.com/spf13/cobra"
 	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/output"
+	"github.com/ublue-os/bext/pkg/retention"
 )
 
 var CleanCmd = &cobra.Command{
 	Use:   "clean",
 	Short: "Clean every unused cache blob",
-	Long:  `Clean every unused blob from cache except the current blob and its symlink`,
-	RunE:  cleanCmd,
+	Long: `Clean unused blobs from cache according to retention policies.
+
+Current blobs, activated blobs, pinned blobs, staged blobs, rollback points and excluded paths are never cleaned. Without any policy, every other blob is cleaned.
+Packed blobs are cleaned like whole ones, and chunks no blob uses anymore are removed afterwards.
+Per-layer policies override the global ones, for example:
+    bext layer clean --keep-last 2 --layer-policy firefox:keep-last=5,keep-newer-than=30d
+
+Global policies can be kept in the keep-last, keep-newer-than and max-cache-size settings, and per-layer ones with "bext layer retention", which --layer-policy overrides.`,
+	RunE: cleanCmd,
 }
 
 var (
-	fExclude *[]string
-	fDryRun  *bool
+	fExclude       *[]string
+	fDryRun        *bool
+	fKeepLast      *int
+	fKeepNewerThan *string
+	fMaxCacheSize  *string
+	fLayerPolicies *[]string
 )
 
 func init() {
 	fExclude = CleanCmd.Flags().StringSliceP("exclude", "e", make([]string, 0), "Exclude directories from cleaning")
 	fDryRun = CleanCmd.Flags().Bool("dry-run", false, "Do not actually clean anything, just print what would be deleted")
+	fKeepLast = CleanCmd.Flags().Int("keep-last", 0, "Keep the last N blobs of each layer")
+	fKeepNewerThan = CleanCmd.Flags().String("keep-newer-than", "", "Keep blobs newer than this age (e.g. 12h, 7d, 2w)")
+	fMaxCacheSize = CleanCmd.Flags().String("max-cache-size", "", "Clean the oldest blobs until the cache fits this size (e.g. 500M, 10G)")
+	fLayerPolicies = CleanCmd.Flags().StringArray("layer-policy", []string{}, "Per-layer policy as LAYER:keep-last=N,keep-newer-than=AGE")
+}
+
+// What a dry run would do to every blob
+type Report struct {
+	Blobs      []BlobDecision `json:"blobs"`
+	FreedBytes int64          `json:"freed_bytes"`
+}
+
+type BlobDecision struct {
+	Layer  string `json:"layer"`
+	Hash   string `json:"hash"`
+	Size   int64  `json:"size"`
+	Packed bool   `json:"packed"`
+	Action string `json:"action"`
+	Reason string `json:"reason"`
+}
+
+func newEngine(state *internal.CacheState) (*retention.Engine, error) {
+	global := retention.Policy{KeepLast: *fKeepLast}
+	if *fKeepNewerThan != "" {
+		age, err := retention.ParseAge(*fKeepNewerThan)
+		if err != nil {
+			return nil, err
+		}
+		global.KeepNewerThan = age
+	}
+
+	engine := retention.NewEngine(global)
+	if *fMaxCacheSize != "" {
+		max_size, err := retention.ParseSize(*fMaxCacheSize)
+		if err != nil {
+			return nil, err
+		}
+		engine.MaxCacheSize = max_size
+	}
+
+	for layer, layer_state := range state.Layers {
+		if layer_state.Retention == "" {
+			continue
+		}
+		policy, err := retention.ParsePolicy(layer_state.Retention, global)
+		if err != nil {
+			return nil, fmt.Errorf("retention policy of %s: %w", layer, err)
+		}
+		engine.Layers[layer] = policy
+	}
+	for _, layer_policy := range *fLayerPolicies {
+		layer, policy, err := retention.ParseLayerPolicy(layer_policy, global)
+		if err != nil {
+			return nil, err
+		}
+		engine.Layers[layer] = policy
+	}
+	return engine, nil
+}
+
+// Protects every blob an activated layer links to, even if it is not the current blob anymore
+func protectActivated(engine *retention.Engine) error {
+	layers, err := internal.ActiveLayers()
+	if err != nil {
+		return err
+	}
+	for _, layer := range layers {
+		target, err := filepath.EvalSymlinks(path.Join(internal.Config.ExtensionsDir, layer+internal.ValidSysextExtension))
+		if err != nil {
+			continue
+		}
+		engine.Protect(target, "activated")
+	}
+	return nil
 }
 
 func cleanCmd(cmd *cobra.Command, args []string) error {
@@ -39,13 +129,53 @@ func cleanCmd(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	var wg sync.WaitGroup
+	state, err := internal.LoadCacheState()
+	if err != nil {
+		return err
+	}
+	engine, err := newEngine(state)
+	if err != nil {
+		return err
+	}
 
+	var excluded []string
+	for _, provided_path := range *fExclude {
+		managed_path, err := filepath.Abs(path.Clean(provided_path))
+		if err != nil {
+			return err
+		}
+		excluded = append(excluded, managed_path)
+	}
+	if err := protectActivated(engine); err != nil {
+		return err
+	}
+
+	var (
+		blobs     []retention.Blob
+		clean_err error
+	)
+	add := func(blob retention.Blob) {
+		hash := path.Base(blob.Path)
+		if state.IsPinned(blob.Layer, hash) {
+			engine.Protect(blob.Path, "pinned")
+		}
+		if state.IsStaged(blob.Layer, hash) {
+			engine.Protect(blob.Path, "staged")
+		}
+		if state.IsRollback(blob.Layer, hash) {
+			engine.Protect(blob.Path, "rollback point")
+		}
+		for _, excluded_path := range excluded {
+			if blob.Path == excluded_path || strings.HasPrefix(blob.Path, excluded_path+"/") {
+				engine.Protect(blob.Path, "excluded")
+			}
+		}
+		blobs = append(blobs, blob)
+	}
 	for _, entry := range target_cache {
 		if !entry.IsDir() {
 			continue
 		}
-		slog.Info("Cleaning layer " + entry.Name())
 
 		entry_dir_path := path.Join(cache_dir, entry.Name())
 		entry_dir, err := os.ReadDir(entry_dir_path)
@@ -54,22 +184,11 @@ func cleanCmd(cmd *cobra.Command, args []string) error {
 		}
 
 		if len(entry_dir) < 1 {
-			wg.Add(1)
-			go func() {
-				wg.Done()
-				os.Remove(entry_dir_path)
-			}()
-			continue
-		}
-
-		var do_not_clean map[string]bool = make(map[string]bool)
-
-		for _, provided_path := range *fExclude {
-			managed_path, err := filepath.Abs(path.Clean(provided_path))
-			if err != nil {
-				return err
+			slog.Debug("Cleaned empty layer", slog.String("path", entry_dir_path))
+			if !*fDryRun {
+				clean_err = errors.Join(clean_err, os.Remove(entry_dir_path))
 			}
-			do_not_clean[managed_path] = true
+			continue
 		}
 
 		for _, cache_blob := range entry_dir {
@@ -84,30 +203,154 @@ func cleanCmd(cmd *cobra.Command, args []string) error {
 				return err
 			}
 
-			if fstat.Mode().Type() == os.ModeSymlink && fstat.Name() == internal.CurrentBlobName {
+			if fstat.Mode().Type() == os.ModeSymlink {
+				if fstat.Name() != internal.CurrentBlobName {
+					continue
+				}
 				eval_link, err := filepath.EvalSymlinks(cleanpath)
 				if err != nil && !errors.Is(err, os.ErrNotExist) {
 					return err
-				} else if errors.Is(err, os.ErrNotExist) {
-					continue
+				} else if err == nil {
+					engine.Protect(eval_link, "current blob")
 				}
-				do_not_clean[eval_link] = true
-				do_not_clean[cleanpath] = true
 				continue
 			}
-			if _, exists := do_not_clean[cleanpath]; exists || *fDryRun {
+
+			add(retention.Blob{
+				Layer:   entry.Name(),
+				Path:    cleanpath,
+				Size:    fstat.Size(),
+				ModTime: fstat.ModTime(),
+			})
+		}
+	}
+
+	// Packed blobs are cleaned like whole ones, under the path they are unpacked to
+	packed_blobs, err := internal.PackedBlobs()
+	if err != nil {
+		return err
+	}
+	packed := map[string]bool{}
+	for _, packed_blob := range packed_blobs {
+		cleanpath := path.Join(cache_dir, packed_blob.Layer, packed_blob.Hash)
+		packed[cleanpath] = true
+		add(retention.Blob{
+			Layer:   packed_blob.Layer,
+			Path:    cleanpath,
+			Size:    packed_blob.Size,
+			ModTime: packed_blob.ModTime,
+		})
+	}
+
+	decisions := engine.Evaluate(blobs)
+	// Packed blobs only free the chunks no other blob shares, which pruning reports
+	var (
+		freed           int64
+		deleting_packed []string
+	)
+	for _, decision := range decisions {
+		if !decision.Delete {
+			continue
+		}
+		if packed[decision.Blob.Path] {
+			deleting_packed = append(deleting_packed, internal.PackedBlobName(decision.Blob.Layer, path.Base(decision.Blob.Path)))
+			continue
+		}
+		freed += decision.Blob.Size
+	}
+
+	if *fDryRun {
+		if len(packed_blobs) > 0 {
+			store, err := internal.ChunkStore()
+			if err != nil {
+				return errors.Join(clean_err, err)
+			}
+			_, prunable, err := store.Prunable(deleting_packed)
+			if err != nil {
+				return errors.Join(clean_err, err)
+			}
+			freed += prunable
+		}
+
+		report := Report{Blobs: []BlobDecision{}, FreedBytes: freed}
+		for _, decision := range decisions {
+			action := "keep"
+			if decision.Delete {
+				action = "delete"
+			}
+			report.Blobs = append(report.Blobs, BlobDecision{
+				Layer:  decision.Blob.Layer,
+				Hash:   path.Base(decision.Blob.Path),
+				Size:   decision.Blob.Size,
+				Packed: packed[decision.Blob.Path],
+				Action: action,
+				Reason: decision.Reason,
+			})
+		}
+		if err := output.Write(os.Stdout, internal.Config.OutputFormat, report, reportView(report)); err != nil {
+			return errors.Join(clean_err, err)
+		}
+		return clean_err
+	}
+
+	var deleted []string
+	for _, decision := range decisions {
+		if !decision.Delete {
+			continue
+		}
+		slog.Debug("Cleaned path", slog.String("path", decision.Blob.Path), slog.String("reason", decision.Reason))
+		if packed[decision.Blob.Path] {
+			if err := internal.RemovePackedBlob(decision.Blob.Layer, path.Base(decision.Blob.Path)); err != nil {
+				slog.Warn("Failed cleaning packed blob "+decision.Blob.Path, slog.String("error", err.Error()))
+				clean_err = errors.Join(clean_err, err)
 				continue
 			}
+			deleted = append(deleted, path.Base(decision.Blob.Path))
+			continue
+		}
+		if err := os.Remove(decision.Blob.Path); err != nil {
+			slog.Warn("Failed cleaning blob "+decision.Blob.Path, slog.String("error", err.Error()))
+			clean_err = errors.Join(clean_err, err)
+			freed -= decision.Blob.Size
+			continue
+		}
+		deleted = append(deleted, path.Base(decision.Blob.Path))
+	}
 
-			slog.Debug("Cleaned path", slog.String("path", cleanpath))
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				os.Remove(cleanpath)
-			}()
+	if len(packed_blobs) > 0 {
+		store, err := internal.ChunkStore()
+		if err != nil {
+			return errors.Join(clean_err, err)
 		}
+		_, pruned, err := store.Prune()
+		clean_err = errors.Join(clean_err, err)
+		freed += pruned
 	}
-	wg.Wait()
 
-	return nil
+	slog.Info(fmt.Sprintf("Cleaned %d blobs, freed %s", len(deleted), progress.FormatBytes(freed)), slog.String("blobs", strings.Join(deleted, " ")), slog.Int64("freed_bytes", freed))
+	return clean_err
+}
+
+func reportView(report Report) output.Table {
+	view := output.Table{
+		Title:  fmt.Sprintf("Dry run, %s would be freed", progress.FormatBytes(report.FreedBytes)),
+		Header: []string{"Layer", "Blob", "Size", "Action", "Reason"},
+	}
+	tsv := internal.Config.OutputFormat == output.FormatTSV
+	if tsv {
+		view.Header = []string{"layer", "hash", "size", "packed", "action", "reason"}
+	}
+
+	for _, blob := range report.Blobs {
+		if tsv {
+			view.Rows = append(view.Rows, []string{blob.Layer, blob.Hash, strconv.FormatInt(blob.Size, 10), strconv.FormatBool(blob.Packed), blob.Action, blob.Reason})
+			continue
+		}
+		blob_name := blob.Hash
+		if blob.Packed {
+			blob_name += " (packed)"
+		}
+		view.Rows = append(view.Rows, []string{blob.Layer, blob_name, progress.FormatBytes(blob.Size), blob.Action, blob.Reason})
+	}
+	return view
 }
@@ -0,0 +1,88 @@
+package convertConfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/structures"
+)
+
+var ConvertConfigCmd = &cobra.Command{
+	Use:   "convert-config CONFIG",
+	Short: "Convert a layer configuration between json, yaml and toml",
+	Long: `Convert a layer configuration between json, yaml and toml, writing it to stdout unless --output-path is set.
+
+The configuration is validated first, since unknown fields would otherwise be lost on the way.`,
+	RunE: convertConfigCmd,
+	Args: cobra.ExactArgs(1),
+}
+
+var (
+	fTo       *string
+	fOutPath  *string
+	fOverride *bool
+)
+
+func init() {
+	fTo = ConvertConfigCmd.Flags().StringP("to", "t", "", "Format to convert to: "+strings.Join(structures.ValidFormats, ", ")+" (default from the extension of --output-path)")
+	fOutPath = ConvertConfigCmd.Flags().StringP("output-path", "o", "", "Write the converted configuration to this file instead of stdout")
+	fOverride = ConvertConfigCmd.Flags().Bool("override", false, "Override the output file if it already exists")
+}
+
+func convertConfigCmd(cmd *cobra.Command, args []string) error {
+	format := structures.FormatFromPath(*fOutPath)
+	if *fTo != "" {
+		var err error
+		format, err = structures.ParseFormat(*fTo)
+		if err != nil {
+			return err
+		}
+	} else if format == "" {
+		return internal.NewInvalidOptionError("to")
+	}
+
+	configuration, err := internal.LoadLayerConfiguration(path.Clean(args[0]))
+	if err != nil {
+		return err
+	}
+	// Going through the struct keeps fields in the order they are documented
+	json_config, err := json.Marshal(configuration)
+	if err != nil {
+		return err
+	}
+	converted, err := structures.FromJson(json_config, format)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.HasSuffix(converted, []byte("\n")) {
+		converted = append(converted, '\n')
+	}
+
+	if *fOutPath == "" {
+		_, err := os.Stdout.Write(converted)
+		return err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !*fOverride {
+		flags |= os.O_EXCL
+	}
+	out_file, err := os.OpenFile(*fOutPath, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer out_file.Close()
+	if _, err := out_file.Write(converted); err != nil {
+		return err
+	}
+
+	slog.Info("Converted configuration", slog.String("from", args[0]), slog.String("to", *fOutPath), slog.String("format", string(format)))
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 
@@ -16,12 +17,44 @@ import (
 var DeactivateCmd = &cobra.Command{
 	Use:   "deactivate [TARGET...]",
 	Short: "Deactivate a layer and refresh sysext",
-	Long:  `Deativate a selected layer (unsymlink it from /var/lib/extensions) and refresh the system extensions store.`,
-	RunE:  deactivateCmd,
-	Args:  cobra.MinimumNArgs(1),
+	Long: `Deativate a selected layer (unsymlink it from /var/lib/extensions) and refresh the system extensions store.
+
+With --on-next-boot layers are only deactivated on the next boot, before systemd-sysext merges them, so running programs never see the change.`,
+	RunE: deactivateCmd,
+	Args: cobra.MinimumNArgs(1),
+}
+
+var (
+	fOnNextBoot *bool
+)
+
+func init() {
+	fOnNextBoot = DeactivateCmd.Flags().Bool("on-next-boot", false, "Deactivate layers on the next boot instead of now")
 }
 
 func deactivateCmd(cmd *cobra.Command, args []string) error {
+	state, err := internal.LoadCacheState()
+	if err != nil {
+		return err
+	}
+	if *fOnNextBoot {
+		for _, layer := range args {
+			// Same checks as activate --on-next-boot, the boot unit trusts whatever is pending
+			if !internal.ValidLayerName(layer) {
+				return &internal.InvalidLayerNameError{Layer: layer}
+			}
+			if _, err := os.Stat(path.Join(internal.Config.CacheDir, layer)); err != nil {
+				return &internal.LayerNotFoundError{Layer: layer}
+			}
+			state.Layer(layer).Pending = internal.PendingDeactivate
+		}
+		if err := state.Save(); err != nil {
+			return err
+		}
+		slog.Info("Layers will be deactivated on the next boot", slog.String("layers", strings.Join(args, " ")))
+		return nil
+	}
+
 	extensions_dir, err := filepath.Abs(path.Clean(internal.Config.ExtensionsDir))
 	if err != nil {
 		return err
@@ -56,6 +89,37 @@ func deactivateCmd(cmd *cobra.Command, args []string) error {
 	if len(errChan) == 0 {
 		slog.Info("Successfully deactivated layers", slog.String("layers", strings.Join(args, " ")))
 	}
+	warnDependents(args)
 
+	// Deactivating a layer now overrides whatever was pending for it
+	cleared := false
+	for _, layer := range args {
+		if state.PendingChange(layer) != "" {
+			state.Layer(layer).Pending = ""
+			cleared = true
+		}
+	}
+	if cleared {
+		return state.Save()
+	}
 	return nil
 }
+
+// Layers that are still activated keep working without their dependencies as far as sysext is concerned, so only warn
+func warnDependents(deactivated []string) {
+	active, err := internal.ActiveLayers()
+	if err != nil {
+		return
+	}
+	for _, layer := range active {
+		depends, err := internal.LayerDependencies(layer)
+		if err != nil {
+			continue
+		}
+		for _, dependency := range depends {
+			if slices.Contains(deactivated, dependency) {
+				slog.Warn("Layer "+layer+" depends on "+dependency+", which was deactivated", slog.String("layer", layer), slog.String("dependency", dependency))
+			}
+		}
+	}
+}
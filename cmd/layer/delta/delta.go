@@ -0,0 +1,118 @@
+package delta
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/delta"
+	"github.com/ublue-os/bext/pkg/output"
+)
+
+var DeltaCmd = &cobra.Command{
+	Use:   "delta LAYER BASE_HASH TARGET_HASH",
+	Short: "Write a binary delta between two cached blobs of a layer",
+	Long: `Write a binary delta that turns the blob BASE_HASH of a layer into TARGET_HASH, so that only the delta has to be copied to systems that already have the base.
+
+Both blobs are split into content-defined chunks, chunks of the target found in the base are referenced and everything else is stored compressed.
+Deltas are applied with "layer apply-delta", which checks the reconstructed blob against TARGET_HASH.`,
+	RunE: deltaCmd,
+	Args: cobra.MaximumNArgs(3),
+}
+
+var fOutputPath *string
+
+func init() {
+	fOutputPath = DeltaCmd.Flags().StringP("output-path", "o", "", "Path of the delta (default LAYER_BASE_TARGET.delta in the current directory)")
+}
+
+type Result struct {
+	Layer      string `json:"layer"`
+	Base       string `json:"base"`
+	Target     string `json:"target"`
+	Output     string `json:"output"`
+	TargetSize int64  `json:"target_size"`
+	delta.Stats
+}
+
+func deltaCmd(cmd *cobra.Command, args []string) error {
+	if len(args) < 3 {
+		return internal.NewPositionalError("LAYER", "BASE_HASH", "TARGET_HASH")
+	}
+	layer, base_hash, target_hash := args[0], args[1], args[2]
+
+	if _, err := os.Stat(path.Join(internal.Config.CacheDir, layer)); errors.Is(err, os.ErrNotExist) {
+		return &internal.LayerNotFoundError{Layer: layer}
+	}
+
+	out_path := *fOutputPath
+	if out_path == "" {
+		out_path = fmt.Sprintf("%s_%.12s_%.12s.delta", layer, base_hash, target_hash)
+	}
+	out_path, err := filepath.Abs(path.Clean(out_path))
+	if err != nil {
+		return err
+	}
+
+	// Packed blobs are reassembled while the delta is written
+	base, _, err := internal.OpenBlob(layer, base_hash)
+	if err != nil {
+		return err
+	}
+	defer base.Close()
+	target, target_size, err := internal.OpenBlob(layer, target_hash)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	out, err := os.Create(out_path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	slog.Info("Writing delta", slog.String("layer", layer), slog.String("base", base_hash), slog.String("target", target_hash), slog.String("output", out_path))
+	header := delta.Header{Layer: layer, Base: base_hash, Target: target_hash, TargetSize: target_size}
+	stats, err := delta.Create(out, header, base, target)
+	if err != nil {
+		os.Remove(out_path)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	result := Result{Layer: layer, Base: base_hash, Target: target_hash, Output: out_path, TargetSize: target_size, Stats: stats}
+	return output.Write(os.Stdout, internal.Config.OutputFormat, result, resultView(result))
+}
+
+func resultView(result Result) output.Table {
+	if internal.Config.OutputFormat == output.FormatTSV {
+		return output.Table{
+			Header: []string{"layer", "base", "target", "output", "target_size", "size", "copied", "added"},
+			Rows: [][]string{{result.Layer, result.Base, result.Target, result.Output, strconv.FormatInt(result.TargetSize, 10),
+				strconv.FormatInt(result.Size, 10), strconv.FormatInt(result.Copied, 10), strconv.FormatInt(result.Added, 10)}},
+		}
+	}
+
+	ratio := ""
+	if result.TargetSize > 0 {
+		ratio = fmt.Sprintf(" (%.1f%% of the target)", float64(result.Size)*100/float64(result.TargetSize))
+	}
+	return output.Table{Title: "Delta", Rows: [][]string{
+		{"Layer", result.Layer},
+		{"Base", result.Base},
+		{"Target", result.Target},
+		{"Output", result.Output},
+		{"Size", strconv.FormatInt(result.Size, 10) + " bytes" + ratio},
+		{"Copied from base", strconv.FormatInt(result.Copied, 10) + " bytes"},
+		{"Stored in delta", strconv.FormatInt(result.Added, 10) + " bytes"},
+	}}
+}
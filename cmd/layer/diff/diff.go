@@ -0,0 +1,264 @@
+package diff
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/imagediff"
+	"github.com/ublue-os/bext/pkg/osrelease"
+	"github.com/ublue-os/bext/pkg/output"
+	"github.com/ublue-os/bext/pkg/squashfs"
+	"github.com/ublue-os/bext/pkg/sysext"
+)
+
+var DiffCmd = &cobra.Command{
+	Use:   "diff LAYER HASH_A HASH_B | diff IMAGE_A IMAGE_B",
+	Short: "Show what changed between two blobs of a layer or two images",
+	Long: `Show what changed going from the first to the second of two cached blobs of a layer, or two image files.
+
+Files that were added, removed or modified are listed with their size difference, along with the packages that changed in metadata.json and the fields that changed in the extension-release file.
+Images are read directly, without mounting them.`,
+	RunE: diffCmd,
+	Args: cobra.MaximumNArgs(3),
+}
+
+type PackageChanges struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+type ReleaseChange struct {
+	Key string `json:"key"`
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+type Diff struct {
+	Old       string             `json:"old"`
+	New       string             `json:"new"`
+	Files     []imagediff.Change `json:"files"`
+	SizeDelta int64              `json:"size_delta"`
+	Packages  PackageChanges     `json:"packages"`
+	Release   []ReleaseChange    `json:"release"`
+}
+
+// What is read from an image besides its files
+type imageInfo struct {
+	packages []string
+	release  osrelease.Release
+}
+
+func diffCmd(cmd *cobra.Command, args []string) error {
+	var old_path, new_path string
+	var old_image, new_image *squashfs.Image
+	var err error
+	switch len(args) {
+	case 2:
+		old_path, new_path = args[0], args[1]
+		if old_image, err = squashfs.Open(old_path); err != nil {
+			return err
+		}
+		defer old_image.Close()
+		if new_image, err = squashfs.Open(new_path); err != nil {
+			return err
+		}
+		defer new_image.Close()
+	case 3:
+		if old_image, old_path, err = openBlob(args[0], args[1]); err != nil {
+			return err
+		}
+		defer old_image.Close()
+		if new_image, new_path, err = openBlob(args[0], args[2]); err != nil {
+			return err
+		}
+		defer new_image.Close()
+	default:
+		return internal.NewPositionalError("IMAGE_A", "IMAGE_B")
+	}
+
+	result := Diff{Old: old_path, New: new_path, Files: []imagediff.Change{}, Release: []ReleaseChange{}}
+	result.Files, err = imagediff.Compare(old_image, new_image)
+	if err != nil {
+		return err
+	}
+	for _, change := range result.Files {
+		result.SizeDelta += change.NewSize - change.OldSize
+	}
+
+	old_info, err := readImageInfo(old_image)
+	if err != nil {
+		return err
+	}
+	new_info, err := readImageInfo(new_image)
+	if err != nil {
+		return err
+	}
+	result.Packages = comparePackages(old_info.packages, new_info.packages)
+	result.Release = compareReleases(old_info.release, new_info.release)
+
+	return output.Write(os.Stdout, internal.Config.OutputFormat, result, diffView(result))
+}
+
+// Opens a cached blob along with the name it is shown as, its path when whole and LAYER@HASH when packed
+func openBlob(layer string, hash string) (*squashfs.Image, string, error) {
+	blob_path := path.Join(internal.Config.CacheDir, layer, hash)
+	if _, err := os.Stat(blob_path); err == nil {
+		blob_path, err = filepath.Abs(blob_path)
+		if err != nil {
+			return nil, "", err
+		}
+		image, err := squashfs.Open(blob_path)
+		return image, blob_path, err
+	}
+	if !internal.IsPacked(layer, hash) {
+		if _, err := os.Stat(path.Join(internal.Config.CacheDir, layer)); errors.Is(err, os.ErrNotExist) {
+			return nil, "", &internal.LayerNotFoundError{Layer: layer}
+		}
+		return nil, "", &internal.BlobNotFoundError{Layer: layer, Hash: hash}
+	}
+
+	// Images are read at random offsets, so a packed blob is reassembled into a file first
+	blob, _, err := internal.OpenBlob(layer, hash)
+	if err != nil {
+		return nil, "", err
+	}
+	defer blob.Close()
+	unpacked, err := os.CreateTemp("", "bext-diff-*")
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.Remove(unpacked.Name())
+	defer unpacked.Close()
+	if _, err := io.Copy(unpacked, blob); err != nil {
+		return nil, "", err
+	}
+	// The image keeps its own descriptor, so the file is gone as soon as it is closed
+	image, err := squashfs.Open(unpacked.Name())
+	return image, layer + "@" + hash, err
+}
+
+// Images built by bext have a single extension-release file, whatever their layer is called
+func readImageInfo(image *squashfs.Image) (imageInfo, error) {
+	info := imageInfo{release: osrelease.Release{}}
+
+	metadata, err := internal.ImageMetadata(image)
+	if err != nil {
+		return info, err
+	}
+	if metadata != nil {
+		info.packages = metadata.Packages
+	}
+
+	release_paths, err := fs.Glob(image, path.Join(strings.TrimPrefix(sysext.ExtensionReleaseDir, "/"), "extension-release.*"))
+	if err != nil {
+		return info, err
+	}
+	if len(release_paths) > 0 {
+		release_file, err := image.Open(release_paths[0])
+		if err != nil {
+			return info, err
+		}
+		defer release_file.Close()
+		if info.release, err = osrelease.Parse(release_file); err != nil {
+			return info, err
+		}
+	}
+	return info, nil
+}
+
+func comparePackages(old_packages []string, new_packages []string) PackageChanges {
+	changes := PackageChanges{Added: []string{}, Removed: []string{}}
+	for _, name := range new_packages {
+		if !slices.Contains(old_packages, name) {
+			changes.Added = append(changes.Added, name)
+		}
+	}
+	for _, name := range old_packages {
+		if !slices.Contains(new_packages, name) {
+			changes.Removed = append(changes.Removed, name)
+		}
+	}
+	return changes
+}
+
+// Keys missing from one of both releases are compared as empty values
+func compareReleases(old_release osrelease.Release, new_release osrelease.Release) []ReleaseChange {
+	changes := []ReleaseChange{}
+	for key, value := range old_release {
+		if new_release[key] != value {
+			changes = append(changes, ReleaseChange{Key: key, Old: value, New: new_release[key]})
+		}
+	}
+	for key, value := range new_release {
+		if _, exists := old_release[key]; !exists {
+			changes = append(changes, ReleaseChange{Key: key, New: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+func sizeDelta(delta int64) string {
+	if delta > 0 {
+		return "+" + strconv.FormatInt(delta, 10)
+	}
+	return strconv.FormatInt(delta, 10)
+}
+
+func diffView(result Diff) output.Table {
+	view := output.Table{Title: "Diff", Header: []string{"Type", "Name", "Change", "Details"}}
+	tsv := internal.Config.OutputFormat == output.FormatTSV
+	if tsv {
+		view.Header = []string{"type", "name", "change", "details", "old", "new", "size_delta"}
+	}
+	add := func(kind string, name string, change string, details string, old_value string, new_value string, delta string) {
+		if tsv {
+			view.Rows = append(view.Rows, []string{kind, name, change, details, old_value, new_value, delta})
+			return
+		}
+		view.Rows = append(view.Rows, []string{kind, name, change, details})
+	}
+
+	for _, name := range result.Packages.Added {
+		add("package", name, "added", "", "", "", "")
+	}
+	for _, name := range result.Packages.Removed {
+		add("package", name, "removed", "", "", "", "")
+	}
+	for _, change := range result.Release {
+		kind := "modified"
+		if change.Old == "" {
+			kind = "added"
+		} else if change.New == "" {
+			kind = "removed"
+		}
+		add("release", change.Key, kind, change.Old+" -> "+change.New, change.Old, change.New, "")
+	}
+	for _, change := range result.Files {
+		details := strings.Join(change.Details, ", ")
+		delta := change.NewSize - change.OldSize
+		if tsv {
+			add("file", change.Path, string(change.Kind), details, strconv.FormatInt(change.OldSize, 10), strconv.FormatInt(change.NewSize, 10), strconv.FormatInt(delta, 10))
+			continue
+		}
+		if delta != 0 {
+			details = strings.TrimPrefix(details+", "+sizeDelta(delta)+" bytes", ", ")
+		}
+		add("file", change.Path, string(change.Kind), details, "", "", "")
+	}
+
+	if !tsv {
+		view.Rows = append(view.Rows, []string{"total", "", "", sizeDelta(result.SizeDelta) + " bytes"})
+	}
+	return view
+}
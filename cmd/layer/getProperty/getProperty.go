@@ -6,18 +6,18 @@ import (
 	"log/slog"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 
-	"github.com/jedib0t/go-pretty/v6/table"
-	"github.com/jedib0t/go-pretty/v6/text"
 	"github.com/spf13/cobra"
 	"github.com/ublue-os/bext/internal"
 	"github.com/ublue-os/bext/pkg/logging"
+	"github.com/ublue-os/bext/pkg/output"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 )
 
-var validOptions []string = []string{"NAME", "PACKAGES", "ARCH", "OS", "BINARIES", "ISMOUNTED"}
+var validOptions []string = []string{"NAME", "PACKAGES", "ARCH", "OS", "BINARIES", "ISMOUNTED", "FLAKE"}
 
 var GetPropertyCmd = &cobra.Command{
 	Use:   "get-property [LAYER]",
@@ -37,7 +37,7 @@ var (
 )
 
 func init() {
-	fFromFile = GetPropertyCmd.Flags().BoolP("from-file", "f", false, "Read data from a file instead of layer")
+	fFromFile = GetPropertyCmd.Flags().BoolP("from-file", "f", false, "Read data from a json, yaml or toml file instead of layer")
 	fLogOnly = GetPropertyCmd.Flags().Bool("log", false, "Do not make a table, just log everything")
 	fSeparator = GetPropertyCmd.Flags().StringP("separator", "s", "\n", "Separator for listing things like arrays")
 }
@@ -50,7 +50,7 @@ func getPropertyCmd(cmd *cobra.Command, args []string) error {
 	var (
 		config_file_path    string
 		raw_configuration   []byte
-		unmarshalled_config = &internal.LayerConfiguration{}
+		unmarshalled_config = &internal.LayerMetadata{}
 	)
 	if len(args) < 1 && !*fFromFile {
 		return internal.NewPositionalError("LAYER")
@@ -72,7 +72,7 @@ func getPropertyCmd(cmd *cobra.Command, args []string) error {
 		config_file_path = path.Clean(target_file)
 	}
 
-	raw_configuration, err := os.ReadFile(config_file_path)
+	raw_configuration, err := internal.ReadLayerConfiguration(config_file_path)
 	if err != nil {
 		return err
 	}
@@ -80,60 +80,75 @@ func getPropertyCmd(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	t := table.NewWriter()
-	t.SetStyle(table.StyleRounded)
-	t.SetTitle(unmarshalled_config.Name)
-	t.Style().Options.SeparateRows = true
-	t.SetColumnConfigs([]table.ColumnConfig{
-		{Name: unmarshalled_config.Name, Align: text.AlignCenter, VAlign: text.VAlignMiddle},
-	})
-
 	if len(args) == 0 {
 		args = validOptions
 	}
 
+	var layer_mounted bool = true
+	if _, err := os.Stat(path.Join(internal.Config.ExtensionsMount, unmarshalled_config.Name)); err != nil {
+		layer_mounted = false
+	}
+
+	properties := map[string]any{}
+	view := output.Table{Title: unmarshalled_config.Name}
+	if internal.Config.OutputFormat == output.FormatTSV {
+		view.Header = []string{"property", "value"}
+	}
+
 	for _, property := range args {
 		upper_prop_name := strings.ToUpper(property)
 		property_name := cases.Title(language.English).String(property)
-
-		var layer_mounted bool = true
-		if _, err := os.Stat(path.Join(internal.Config.ExtensionsMount, unmarshalled_config.Name)); err != nil {
-			layer_mounted = false
-		}
+		json_name := strings.ToLower(property)
 
 		switch upper_prop_name {
 		case "PACKAGES":
 			{
 				packages := strings.Join(unmarshalled_config.Packages, *fSeparator)
 				slog.Info("packages", slog.String("value", packages))
-				t.AppendRow(table.Row{property_name, packages})
+				properties[json_name] = unmarshalled_config.Packages
+				view.Rows = append(view.Rows, []string{property_name, packages})
 				continue
 			}
 		case "BINARIES":
 			{
 				if !layer_mounted {
 					slog.Info("binaries", slog.String("value", "Layer not mounted"))
-					t.AppendRow(table.Row{"Binaries", "Layer not mounted"})
+					properties[json_name] = nil
+					view.Rows = append(view.Rows, []string{"Binaries", "Layer not mounted"})
 					continue
 				}
 				list_dir, err := os.ReadDir(path.Join(internal.Config.ExtensionsMount, unmarshalled_config.Name, "bin"))
 				if err != nil {
 					return err
 				}
-				var dir_contents []string
+				dir_contents := []string{}
 				for _, file := range list_dir {
 					dir_contents = append(dir_contents, file.Name())
 				}
 
 				binaries := strings.Join(dir_contents, *fSeparator)
 				slog.Info("binaries", slog.String("value", binaries))
-				t.AppendRow(table.Row{"Binaries", binaries})
+				properties[json_name] = dir_contents
+				view.Rows = append(view.Rows, []string{"Binaries", binaries})
+				continue
+			}
+		case "FLAKE":
+			{
+				// Only layers built with a recipe flake that records its inputs have this
+				flake_url := ""
+				if unmarshalled_config.Flake != nil {
+					flake_url = unmarshalled_config.Flake.URL
+				}
+				slog.Info("flake", slog.String("value", flake_url))
+				properties[json_name] = unmarshalled_config.Flake
+				view.Rows = append(view.Rows, []string{"Flake", flake_url})
 				continue
 			}
 		case "ISMOUNTED":
 			{
 				slog.Info("mounted", slog.Bool("value", layer_mounted))
-				t.AppendRow(table.Row{"IsMounted", layer_mounted})
+				properties[json_name] = layer_mounted
+				view.Rows = append(view.Rows, []string{"IsMounted", strconv.FormatBool(layer_mounted)})
 				continue
 			}
 		}
@@ -143,12 +158,12 @@ func getPropertyCmd(cmd *cobra.Command, args []string) error {
 			return internal.NewInvalidOptionError(property_name)
 		}
 		slog.Info(property_name, slog.String("value", value_get))
-		t.AppendRow(table.Row{property_name, value_get})
+		properties[json_name] = value_get
+		view.Rows = append(view.Rows, []string{property_name, value_get})
 	}
 
-	if !*fLogOnly {
-		fmt.Printf("%s\n", t.Render())
+	if *fLogOnly && !internal.Config.OutputFormat.IsMachineReadable() {
+		return nil
 	}
-
-	return nil
+	return output.Write(os.Stdout, internal.Config.OutputFormat, properties, view)
 }
@@ -0,0 +1,58 @@
+package hold
+
+import (
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/internal"
+)
+
+var HoldCmd = &cobra.Command{
+	Use:   "hold [LAYER...]",
+	Short: "Hold layers at their current blob",
+	Long:  `Hold layers so that their current blob is never moved unless forced.`,
+	RunE:  holdCmd,
+	Args:  cobra.MinimumNArgs(1),
+}
+
+var (
+	fRemove *bool
+)
+
+func init() {
+	fRemove = HoldCmd.Flags().BoolP("remove", "r", false, "Release held layers instead of holding them")
+}
+
+func holdCmd(cmd *cobra.Command, args []string) error {
+	state, err := internal.LoadCacheState()
+	if err != nil {
+		return err
+	}
+
+	for _, layer := range args {
+		// Releasing stays possible for layers that were removed while held
+		if !*fRemove {
+			if !internal.ValidLayerName(layer) {
+				return &internal.InvalidLayerNameError{Layer: layer}
+			}
+			if _, err := os.Stat(path.Join(internal.Config.CacheDir, layer)); err != nil {
+				return &internal.LayerNotFoundError{Layer: layer}
+			}
+		}
+		state.Layer(layer).Held = !*fRemove
+	}
+
+	if err := state.Save(); err != nil {
+		return err
+	}
+
+	if *fRemove {
+		slog.Info("Successfully released layers", slog.String("layers", strings.Join(args, " ")))
+	} else {
+		slog.Info("Successfully held layers", slog.String("layers", strings.Join(args, " ")))
+	}
+	return nil
+}
@@ -6,33 +6,40 @@ import (
 	"log/slog"
 	"os"
 	"path"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/ublue-os/bext/internal"
 	"github.com/ublue-os/bext/pkg/fileio"
 	"github.com/ublue-os/bext/pkg/structures"
+	"gopkg.in/yaml.v3"
 )
 
 var InitCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initialize an example configuration for building a sample layer",
-	Long:  `Initialize a configuration file for later building a layer`,
-	RunE:  initCmd,
+	Long: `Initialize a configuration file for later building a layer
+
+The format is taken from --format or the extension of --output-path, yaml configurations come with comments describing every field.`,
+	RunE: initCmd,
 }
 
 var (
 	fOutPath  *string
 	fTemplate *string
 	fOverride *bool
+	fFormat   *string
 )
 
 func init() {
-	fOutPath = InitCmd.Flags().StringP("output-path", "o", "config.json", "Output path for new configuration")
+	fOutPath = InitCmd.Flags().StringP("output-path", "o", "", "Output path for new configuration (default \"config.FORMAT\")")
 	fTemplate = InitCmd.Flags().StringP("template", "t", "", "URL for template configuration")
 	fOverride = InitCmd.Flags().Bool("override", false, "Override configuration if it already exists in output-path")
+	fFormat = InitCmd.Flags().StringP("format", "f", "", "Format of the configuration: "+strings.Join(structures.ValidFormats, ", "))
 }
 
 var defaultConfiguration = &internal.LayerConfiguration{
+	Version:  internal.LayerConfigurationVersion,
 	Name:     "example",
 	Arch:     "x86-64",
 	Os:       "_any",
@@ -40,39 +47,104 @@ var defaultConfiguration = &internal.LayerConfiguration{
 }
 
 func initCmd(cmd *cobra.Command, args []string) error {
-	var json_config *[]byte
-	if *fTemplate == "" {
-		slog.Debug("Using default template")
+	format := structures.FormatFromPath(*fOutPath)
+	if *fFormat != "" {
 		var err error
-		json_default, err := json.MarshalIndent(defaultConfiguration, "", structures.INDENTATION)
+		format, err = structures.ParseFormat(*fFormat)
 		if err != nil {
 			return err
 		}
-		json_config = &json_default
+	} else if format == "" {
+		format = structures.FormatJSON
+	}
+	out_path := *fOutPath
+	if out_path == "" {
+		out_path = "config." + string(format)
+	}
+
+	configuration := defaultConfiguration
+	if *fTemplate == "" {
+		slog.Debug("Using default template")
 	} else {
 		slog.Debug("Using remote template", slog.String("remote_url", *fTemplate))
-		var fetchedConfig = &internal.LayerConfiguration{}
-		json_conf, err := structures.FetchJsonConfig(*fTemplate, fetchedConfig)
+		raw_template, err := structures.FetchConfig(*fTemplate)
+		if err != nil {
+			return err
+		}
+		json_template, err := structures.ToJson(raw_template, structures.DetectFormat(*fTemplate, raw_template))
 		if err != nil {
 			return err
 		}
-		json_config = &json_conf
+		configuration = &internal.LayerConfiguration{}
+		if err := json.Unmarshal(json_template, configuration); err != nil {
+			return err
+		}
+	}
+
+	json_config, err := json.Marshal(configuration)
+	if err != nil {
+		return err
+	}
+	var out_config []byte
+	if format == structures.FormatYAML {
+		out_config, err = commentedYaml(json_config)
+	} else {
+		out_config, err = structures.FromJson(json_config, format)
+	}
+	if err != nil {
+		return err
 	}
 
-	if fileio.FileExist(*fOutPath) && !*fOverride {
+	if fileio.FileExist(out_path) && !*fOverride {
 		slog.Warn("Failed writing, file already exists")
 		return nil
 	}
 
-	os.Remove(*fOutPath)
+	os.Remove(out_path)
 
-	bytes_written, err := fileio.FileAppend(*fOutPath, *json_config)
+	bytes_written, err := fileio.FileAppend(out_path, out_config)
 	if err != nil {
 		slog.Warn(fmt.Sprintf("Failed writing configuration file, bytes written: %d\n", bytes_written))
 		return err
 	}
 
-	slog.Debug("configuration", slog.String("value", string(*json_config)))
-	slog.Info("Successfully written configuration file "+path.Base(*fOutPath), slog.String("out_path", *fOutPath))
+	slog.Debug("configuration", slog.String("value", string(out_config)))
+	slog.Info("Successfully written configuration file "+path.Base(out_path), slog.String("out_path", out_path))
 	return nil
 }
+
+// Every field gets its schema description as a comment, optional fields that are not set are listed at the end
+func commentedYaml(json_config []byte) ([]byte, error) {
+	document, err := structures.YamlNodeFromJson(json_config)
+	if err != nil {
+		return nil, err
+	}
+	schema := internal.LayerConfigurationSchema()
+	mapping := document.Content[0]
+
+	set := map[string]bool{}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		key := mapping.Content[i]
+		set[key.Value] = true
+		if property, exists := schema.Properties[key.Value]; exists {
+			key.HeadComment = property.Description
+		}
+	}
+
+	var optional []string
+	for _, name := range schema.PropertyOrder() {
+		if !set[name] && !strings.HasPrefix(name, "$") {
+			optional = append(optional, fmt.Sprintf("  %s: %s", name, schema.Properties[name].Description))
+		}
+	}
+	if len(optional) > 0 {
+		document.FootComment = "Optional fields, see docs/layer-config.md:\n" + strings.Join(optional, "\n")
+	}
+
+	header := "# Layer configuration, check it with \"bext layer validate\" before building\n"
+	out, err := yaml.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(header), out...), nil
+}
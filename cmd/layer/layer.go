@@ -4,13 +4,24 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/ublue-os/bext/cmd/layer/activate"
 	"github.com/ublue-os/bext/cmd/layer/add"
+	"github.com/ublue-os/bext/cmd/layer/applyDelta"
+	"github.com/ublue-os/bext/cmd/layer/applyPending"
 	"github.com/ublue-os/bext/cmd/layer/build"
 	"github.com/ublue-os/bext/cmd/layer/clean"
+	"github.com/ublue-os/bext/cmd/layer/convertConfig"
 	"github.com/ublue-os/bext/cmd/layer/deactivate"
+	"github.com/ublue-os/bext/cmd/layer/delta"
+	"github.com/ublue-os/bext/cmd/layer/diff"
 	"github.com/ublue-os/bext/cmd/layer/getProperty"
+	"github.com/ublue-os/bext/cmd/layer/hold"
 	"github.com/ublue-os/bext/cmd/layer/initcmd"
 	"github.com/ublue-os/bext/cmd/layer/list"
+	"github.com/ublue-os/bext/cmd/layer/pin"
 	"github.com/ublue-os/bext/cmd/layer/remove"
+	"github.com/ublue-os/bext/cmd/layer/retention"
+	"github.com/ublue-os/bext/cmd/layer/source"
+	"github.com/ublue-os/bext/cmd/layer/validate"
+	"github.com/ublue-os/bext/cmd/layer/verify"
 	"github.com/ublue-os/bext/internal"
 )
 
@@ -20,16 +31,27 @@ var LayerCmd = &cobra.Command{
 }
 
 func init() {
-	LayerCmd.PersistentFlags().StringVar(&internal.Config.CacheDir, "cache-root", "/var/cache/extensions/blobs", "root directory for the layer cache")
-	LayerCmd.PersistentFlags().StringVar(&internal.Config.ExtensionsDir, "extensions-root", "/var/lib/extensions", "root directory for the systemd-sysext layers")
-	LayerCmd.PersistentFlags().StringVar(&internal.Config.ExtensionsMount, "extensions-mount", "/usr/extensions.d", "directory where systemd-sysext layers will be mounted to")
+	LayerCmd.PersistentFlags().StringVar(&internal.Config.CacheDir, "cache-root", internal.DefaultCacheDir, "root directory for the layer cache")
+	LayerCmd.PersistentFlags().StringVar(&internal.Config.ExtensionsDir, "extensions-root", internal.DefaultExtensionsDir, "root directory for the systemd-sysext layers")
+	LayerCmd.PersistentFlags().StringVar(&internal.Config.ExtensionsMount, "extensions-mount", internal.DefaultExtensionsMount, "directory where systemd-sysext layers will be mounted to")
 	LayerCmd.AddCommand(activate.ActivateCmd)
 	LayerCmd.AddCommand(add.AddCmd)
+	LayerCmd.AddCommand(applyDelta.ApplyDeltaCmd)
+	LayerCmd.AddCommand(applyPending.ApplyPendingCmd)
 	LayerCmd.AddCommand(clean.CleanCmd)
+	LayerCmd.AddCommand(convertConfig.ConvertConfigCmd)
 	LayerCmd.AddCommand(deactivate.DeactivateCmd)
+	LayerCmd.AddCommand(delta.DeltaCmd)
+	LayerCmd.AddCommand(diff.DiffCmd)
 	LayerCmd.AddCommand(getProperty.GetPropertyCmd)
+	LayerCmd.AddCommand(hold.HoldCmd)
 	LayerCmd.AddCommand(initcmd.InitCmd)
 	LayerCmd.AddCommand(list.ListCmd)
+	LayerCmd.AddCommand(pin.PinCmd)
 	LayerCmd.AddCommand(build.BuildCmd)
 	LayerCmd.AddCommand(remove.RemoveCmd)
+	LayerCmd.AddCommand(retention.RetentionCmd)
+	LayerCmd.AddCommand(source.SourceCmd)
+	LayerCmd.AddCommand(validate.ValidateCmd)
+	LayerCmd.AddCommand(verify.VerifyCmd)
 }
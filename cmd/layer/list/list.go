@@ -6,21 +6,23 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 
-	"github.com/jedib0t/go-pretty/v6/table"
-	"github.com/jedib0t/go-pretty/v6/text"
 	"github.com/spf13/cobra"
 	"github.com/ublue-os/bext/internal"
 	"github.com/ublue-os/bext/pkg/fileio"
 	"github.com/ublue-os/bext/pkg/logging"
+	"github.com/ublue-os/bext/pkg/output"
 )
 
 var ListCmd = &cobra.Command{
 	Use:   "list [LAYER/HASH]",
 	Short: "List layers in cache and in activation",
-	Long:  `List layers in the cache directory, their blobs and symlinks in their cache. Can also single check a layer or hash specified.`,
-	RunE:  listCmd,
+	Long: `List layers in the cache directory, their blobs and symlinks in their cache. Can also single check a layer or hash specified.
+
+Checking exits with a non-zero status when the layer or hash does not exist. With --output json, yaml or tsv every blob is listed, see docs/output.md for the schema.`,
+	RunE: listCmd,
 }
 
 var (
@@ -41,11 +43,32 @@ func init() {
 	fLogOnly = ListCmd.Flags().Bool("log", false, "Do not make a table, just log everything")
 }
 
-func Btoi(b bool) int {
-	if b {
-		return 0
-	}
-	return 1
+type Blob struct {
+	Hash    string `json:"hash"`
+	Current bool   `json:"current"`
+	Pinned  bool   `json:"pinned"`
+	// Stored in the chunk store instead of the layer's directory
+	Packed bool `json:"packed"`
+}
+
+type Layer struct {
+	Name        string `json:"name"`
+	CurrentBlob string `json:"current_blob"`
+	Activated   bool   `json:"activated"`
+	Held        bool   `json:"held"`
+	// Activation or deactivation waiting for the next boot
+	Pending string `json:"pending,omitempty"`
+	Blobs   []Blob `json:"blobs"`
+}
+
+type Listing struct {
+	Layers []Layer `json:"layers"`
+}
+
+type CheckResult struct {
+	Layer  string `json:"layer"`
+	Hash   string `json:"hash,omitempty"`
+	Exists bool   `json:"exists"`
 }
 
 func listCmd(cmd *cobra.Command, args []string) error {
@@ -62,12 +85,7 @@ func listCmd(cmd *cobra.Command, args []string) error {
 		if len(args) < 1 {
 			return internal.NewPositionalError("LAYER")
 		}
-		layer := args[0]
-		if len(args) > 1 {
-			hash := args[1]
-			os.Exit(Btoi(fileio.FileExist(path.Join(cache_dir, layer, hash))))
-		}
-		os.Exit(Btoi(fileio.FileExist(path.Join(cache_dir, layer))))
+		return checkCmd(cache_dir, args)
 	}
 
 	dirdata, err := os.ReadDir(cache_dir)
@@ -75,15 +93,21 @@ func listCmd(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	t := table.NewWriter()
-	t.SetStyle(table.StyleRounded)
-	t.SetTitle("Layers")
-	t.Style().Options.SeparateRows = true
-	t.SetColumnConfigs([]table.ColumnConfig{
-		{Name: "Layers", Align: text.AlignCenter, VAlign: text.VAlignMiddle},
-		{Name: "Binaries", Align: text.AlignCenter, VAlign: text.VAlignMiddle},
-	})
+	state, err := internal.LoadCacheState()
+	if err != nil {
+		return err
+	}
+	store, err := internal.ChunkStore()
+	if err != nil {
+		return err
+	}
+	packed, err := store.Names()
+	if err != nil {
+		return err
+	}
 
+	format := internal.Config.OutputFormat
+	listing := Listing{Layers: []Layer{}}
 	for _, dir := range dirdata {
 		if !dir.IsDir() {
 			continue
@@ -91,46 +115,131 @@ func listCmd(cmd *cobra.Command, args []string) error {
 		if *fLayer != "" && dir.Name() != *fLayer {
 			continue
 		}
-		if _, err := os.Stat(path.Join(internal.Config.ExtensionsDir, dir.Name()+internal.ValidSysextExtension)); err != nil && *fActivated {
+		_, err := os.Stat(path.Join(internal.Config.ExtensionsDir, dir.Name()+internal.ValidSysextExtension))
+		activated := err == nil
+		if !activated && *fActivated {
 			continue
 		}
 
-		var blobs []string
-		if *fVerbose {
+		layer := Layer{Name: dir.Name(), Activated: activated, Held: state.IsHeld(dir.Name()), Pending: string(state.PendingChange(dir.Name())), Blobs: []Blob{}}
+		if current, err := filepath.EvalSymlinks(path.Join(cache_dir, dir.Name(), internal.CurrentBlobName)); err == nil {
+			layer.CurrentBlob = path.Base(current)
+		}
+
+		if *fVerbose || format.IsMachineReadable() {
 			layerdir, err := os.ReadDir(path.Join(cache_dir, dir.Name()))
 			if err != nil {
 				return err
 			}
-
 			for _, blob := range layerdir {
 				if blob.Name() == internal.CurrentBlobName {
-					fstat, err := filepath.EvalSymlinks(path.Join(cache_dir, dir.Name(), blob.Name()))
-					if err != nil {
-						return err
-					}
-					blobs = append(blobs, fmt.Sprintf("%s -> %s", blob.Name(), path.Base(fstat)))
 					continue
 				}
-				blobs = append(blobs, blob.Name())
+				layer.Blobs = append(layer.Blobs, Blob{
+					Hash:    blob.Name(),
+					Current: blob.Name() == layer.CurrentBlob,
+					Pinned:  state.IsPinned(dir.Name(), blob.Name()),
+				})
+			}
+			for _, name := range packed {
+				if hash, found := strings.CutPrefix(name, dir.Name()+"/"); found {
+					layer.Blobs = append(layer.Blobs, Blob{Hash: hash, Pinned: state.IsPinned(dir.Name(), hash), Packed: true})
+				}
 			}
 		}
 
-		if len(blobs) == 0 {
-			slog.Info(dir.Name(), slog.String("blobs", ""))
-			t.AppendRow(table.Row{dir.Name()})
-			continue
-		}
-		slog.Info(dir.Name(), slog.String("blobs", strings.Join(blobs, ":")))
-		t.AppendRow(table.Row{dir.Name(), strings.Join(blobs, *fSeparator)})
+		slog.Info(layer.Name, slog.String("blobs", strings.Join(blobLabels(layer), ":")), slog.Bool("held", layer.Held))
+		listing.Layers = append(listing.Layers, layer)
 	}
 
-	if t.Length() == 0 {
+	if len(listing.Layers) == 0 && !format.IsMachineReadable() {
 		slog.Warn("No layers found")
 		return nil
 	}
 
-	if !*fLogOnly {
-		fmt.Printf("%s\n", t.Render())
+	if *fLogOnly && !format.IsMachineReadable() {
+		return nil
+	}
+	return output.Write(os.Stdout, format, listing, listingView(listing, format))
+}
+
+func checkCmd(cache_dir string, args []string) error {
+	result := CheckResult{Layer: args[0]}
+	var missing error = &internal.LayerNotFoundError{Layer: result.Layer}
+	if len(args) > 1 {
+		result.Hash = args[1]
+		result.Exists = fileio.FileExist(path.Join(cache_dir, result.Layer, result.Hash)) || internal.IsPacked(result.Layer, result.Hash)
+		missing = &internal.BlobNotFoundError{Layer: result.Layer, Hash: result.Hash}
+	} else {
+		result.Exists = fileio.FileExist(path.Join(cache_dir, result.Layer))
+	}
+
+	if internal.Config.OutputFormat.IsMachineReadable() {
+		view := output.Table{
+			Header: []string{"layer", "hash", "exists"},
+			Rows:   [][]string{{result.Layer, result.Hash, strconv.FormatBool(result.Exists)}},
+		}
+		if err := output.Write(os.Stdout, internal.Config.OutputFormat, result, view); err != nil {
+			return err
+		}
+	}
+
+	if !result.Exists {
+		return missing
 	}
 	return nil
 }
+
+// Blobs as shown to humans, like "current_blob -> HASH" and "HASH (pinned, packed)"
+func blobLabels(layer Layer) []string {
+	var labels []string
+	if len(layer.Blobs) > 0 && layer.CurrentBlob != "" {
+		labels = append(labels, fmt.Sprintf("%s -> %s", internal.CurrentBlobName, layer.CurrentBlob))
+	}
+	for _, blob := range layer.Blobs {
+		var notes []string
+		if blob.Pinned {
+			notes = append(notes, "pinned")
+		}
+		if blob.Packed {
+			notes = append(notes, "packed")
+		}
+		if len(notes) == 0 {
+			labels = append(labels, blob.Hash)
+			continue
+		}
+		labels = append(labels, blob.Hash+" ("+strings.Join(notes, ", ")+")")
+	}
+	return labels
+}
+
+func listingView(listing Listing, format output.Format) output.Table {
+	if format == output.FormatTSV {
+		view := output.Table{Header: []string{"name", "current_blob", "activated", "held", "blobs"}}
+		for _, layer := range listing.Layers {
+			var hashes []string
+			for _, blob := range layer.Blobs {
+				hashes = append(hashes, blob.Hash)
+			}
+			view.Rows = append(view.Rows, []string{layer.Name, layer.CurrentBlob, strconv.FormatBool(layer.Activated), strconv.FormatBool(layer.Held), strings.Join(hashes, ",")})
+		}
+		return view
+	}
+
+	view := output.Table{Title: "Layers"}
+	for _, layer := range listing.Layers {
+		layer_name := layer.Name
+		if layer.Held {
+			layer_name += " (held)"
+		}
+		if layer.Pending != "" {
+			layer_name += " (" + layer.Pending + "s on next boot)"
+		}
+		if len(layer.Blobs) == 0 {
+			view.Rows = append(view.Rows, []string{layer_name})
+			continue
+		}
+		view.Rows = append(view.Rows, []string{layer_name, strings.Join(blobLabels(layer), *fSeparator)})
+	}
+	return view
+}
@@ -0,0 +1,76 @@
+package pin
+
+import (
+	"fmt"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/fileio"
+)
+
+var PinCmd = &cobra.Command{
+	Use:   "pin [LAYER[@HASH]...]",
+	Short: "Pin layer blobs so they are never cleaned",
+	Long:  `Pin blobs so that clean never deletes them. Pins the current blob of a layer when no hash is specified.`,
+	RunE:  pinCmd,
+	Args:  cobra.MinimumNArgs(1),
+}
+
+var (
+	fRemove *bool
+)
+
+func init() {
+	fRemove = PinCmd.Flags().BoolP("remove", "r", false, "Unpin blobs instead of pinning them")
+}
+
+func pinCmd(cmd *cobra.Command, args []string) error {
+	cache_dir, err := filepath.Abs(path.Clean(internal.Config.CacheDir))
+	if err != nil {
+		return err
+	}
+
+	state, err := internal.LoadCacheState()
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range args {
+		layer, hash := internal.ParseLayerRef(ref)
+		if hash == "" {
+			current_blob, err := filepath.EvalSymlinks(path.Join(cache_dir, layer, internal.CurrentBlobName))
+			if err != nil {
+				return fmt.Errorf("layer %s has no current blob to pin", layer)
+			}
+			hash = path.Base(current_blob)
+		}
+
+		layer_state := state.Layer(layer)
+		if *fRemove {
+			layer_state.Pinned = slices.DeleteFunc(layer_state.Pinned, func(pinned string) bool {
+				return pinned == hash
+			})
+			slog.Info(fmt.Sprintf("Unpinned %s@%s", layer, hash), slog.String("layer", layer), slog.String("hash", hash))
+			continue
+		}
+
+		if !fileio.FileExist(path.Join(cache_dir, layer, hash)) && !internal.IsPacked(layer, hash) {
+			return fmt.Errorf("blob %s@%s is not in cache", layer, hash)
+		}
+		if !slices.Contains(layer_state.Pinned, hash) {
+			layer_state.Pinned = append(layer_state.Pinned, hash)
+		}
+		slog.Info(fmt.Sprintf("Pinned %s@%s", layer, hash), slog.String("layer", layer), slog.String("hash", hash))
+	}
+
+	if err := state.Save(); err != nil {
+		slog.Warn("Failed saving pins", slog.String("refs", strings.Join(args, " ")))
+		return err
+	}
+	return nil
+}
@@ -32,7 +32,7 @@ var (
 )
 
 func init() {
-	RemoveCmd.Flags().StringSliceVarP(&fHash, "hash", "h", []string{}, "Remove specific hash from storage")
+	RemoveCmd.Flags().StringSliceVar(&fHash, "hash", []string{}, "Remove specific hash from storage")
 	RemoveCmd.Flags().BoolVar(&fDryRun, "dry-run", false, "Do not remove anything")
 }
 
@@ -55,16 +55,25 @@ func removeCmd(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	if len(args) > 1 && len(fHash) > 0 {
+		return errors.New("when removing hashes, it is required to only specify one layer")
+	}
+
+	targets := fHash
+	if len(fHash) == 0 {
+		targets = args
+	}
+	packed_blobs, err := internal.PackedBlobs()
+	if err != nil {
+		return err
+	}
+
 	var (
 		wg      sync.WaitGroup
-		errChan = make(chan error, len(fHash))
+		errChan = make(chan error, len(targets))
 	)
 
-	if len(args) > 1 && len(fHash) > 1 {
-		return errors.New("when removing hashes, it is required to only specify one layer")
-	}
-
-	for _, hash := range fHash {
+	for _, target := range targets {
 		wg.Add(1)
 		go func(errChan chan<- error, target string) {
 			defer wg.Done()
@@ -74,14 +83,25 @@ func removeCmd(cmd *cobra.Command, args []string) error {
 
 			if len(fHash) > 0 {
 				err := os.Remove(path.Join(cache_dir, args[0], target))
+				if errors.Is(err, os.ErrNotExist) && internal.IsPacked(args[0], target) {
+					err = internal.RemovePackedBlob(args[0], target)
+				}
 				if err != nil {
 					errChan <- err
-					return
 				}
 				return
-			} else {
-				err := os.RemoveAll(path.Join(cache_dir, target))
-				if err != nil {
+			}
+
+			if err := os.RemoveAll(path.Join(cache_dir, target)); err != nil {
+				errChan <- err
+				return
+			}
+			// Packed blobs are outside of the layer directory, so they would outlive it
+			for _, packed_blob := range packed_blobs {
+				if packed_blob.Layer != target {
+					continue
+				}
+				if err := internal.RemovePackedBlob(packed_blob.Layer, packed_blob.Hash); err != nil {
 					errChan <- err
 					return
 				}
@@ -92,12 +112,11 @@ func removeCmd(cmd *cobra.Command, args []string) error {
 				return
 			}
 
-			err = os.Remove(deactivated_layer)
-			if err != nil {
+			if err := os.Remove(deactivated_layer); err != nil {
 				errChan <- err
 				return
 			}
-		}(errChan, hash)
+		}(errChan, target)
 	}
 
 	go func() {
@@ -113,5 +132,17 @@ func removeCmd(cmd *cobra.Command, args []string) error {
 		slog.Info("Successfully deleted target from cache", slog.String("hashes", strings.Join(fHash, " ")))
 	}
 
+	if len(packed_blobs) == 0 {
+		return nil
+	}
+	store, err := internal.ChunkStore()
+	if err != nil {
+		return err
+	}
+	if removed, freed, err := store.Prune(); err != nil {
+		return err
+	} else if removed > 0 {
+		slog.Info("Removed unused chunks", slog.Int("chunks", removed), slog.Int64("bytes", freed))
+	}
 	return nil
 }
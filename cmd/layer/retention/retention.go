@@ -0,0 +1,83 @@
+package retention
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/output"
+	"github.com/ublue-os/bext/pkg/retention"
+)
+
+var RetentionCmd = &cobra.Command{
+	Use:   "retention LAYER [POLICY]",
+	Short: "Set which blobs of a layer bext layer clean keeps",
+	Long: `Set the retention policy bext layer clean applies to a layer instead of the global one, or print it when POLICY is not specified.
+
+Policies are rules separated by commas, rules that are not specified are taken from the global policy:
+  keep-last=N          keep the last N blobs of the layer
+  keep-newer-than=AGE  keep blobs newer than AGE (e.g. 12h, 7d, 2w)
+
+Example:
+    bext layer retention firefox keep-last=5,keep-newer-than=30d`,
+	RunE: retentionCmd,
+	Args: cobra.MaximumNArgs(2),
+}
+
+var (
+	fRemove *bool
+)
+
+func init() {
+	fRemove = RetentionCmd.Flags().BoolP("remove", "r", false, "Remove the retention policy of the layer")
+}
+
+type Result struct {
+	Layer  string `json:"layer"`
+	Policy string `json:"policy"`
+}
+
+func retentionCmd(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return internal.NewPositionalError("LAYER")
+	}
+	layer := args[0]
+
+	state, err := internal.LoadCacheState()
+	if err != nil {
+		return err
+	}
+
+	if *fRemove {
+		state.Layer(layer).Retention = ""
+		if err := state.Save(); err != nil {
+			return err
+		}
+		slog.Info("Removed retention policy", slog.String("layer", layer))
+		return nil
+	}
+
+	if len(args) < 2 {
+		layer_state, exists := state.Layers[layer]
+		if !exists || layer_state.Retention == "" {
+			return &internal.NoRetentionPolicyError{Layer: layer}
+		}
+		result := Result{Layer: layer, Policy: layer_state.Retention}
+		return output.Write(os.Stdout, internal.Config.OutputFormat, result, output.Table{
+			Header: []string{"layer", "policy"},
+			Rows:   [][]string{{result.Layer, result.Policy}},
+		})
+	}
+
+	// Checked now, so that clean never runs into a policy it can not parse
+	if _, err := retention.ParsePolicy(args[1], retention.Policy{}); err != nil {
+		return err
+	}
+	state.Layer(layer).Retention = args[1]
+	if err := state.Save(); err != nil {
+		return err
+	}
+	slog.Info("Set retention policy", slog.String("layer", layer), slog.String("policy", args[1]))
+	return nil
+}
@@ -0,0 +1,90 @@
+package source
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/output"
+)
+
+var SourceCmd = &cobra.Command{
+	Use:   "source LAYER [SOURCE]",
+	Short: "Set where bext update looks for newer blobs of a layer",
+	Long: `Set where bext update looks for newer blobs of a layer, or print it when SOURCE is not specified.
+
+Sources are one of:
+  repository:URL  directory or http(s) URL serving an index.json with the latest blob of every layer
+  oci:REFERENCE   artifact in an OCI registry, like oci:ghcr.io/ublue-os/layers:example
+  config:PATH     layer configuration that is built again with bext layer build`,
+	RunE: sourceCmd,
+	Args: cobra.MaximumNArgs(2),
+}
+
+var (
+	fRemove *bool
+)
+
+func init() {
+	fRemove = SourceCmd.Flags().BoolP("remove", "r", false, "Remove the update source of the layer")
+}
+
+type Result struct {
+	Layer    string `json:"layer"`
+	Type     string `json:"type"`
+	Location string `json:"location"`
+}
+
+func sourceCmd(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return internal.NewPositionalError("LAYER")
+	}
+	layer := args[0]
+
+	state, err := internal.LoadCacheState()
+	if err != nil {
+		return err
+	}
+
+	if *fRemove {
+		state.Layer(layer).Source = nil
+		if err := state.Save(); err != nil {
+			return err
+		}
+		slog.Info("Removed update source", slog.String("layer", layer))
+		return nil
+	}
+
+	if len(args) < 2 {
+		layer_state, exists := state.Layers[layer]
+		if !exists || layer_state.Source == nil {
+			return &internal.NoUpdateSourceError{Layer: layer}
+		}
+		result := Result{Layer: layer, Type: string(layer_state.Source.Type), Location: layer_state.Source.Location}
+		return output.Write(os.Stdout, internal.Config.OutputFormat, result, output.Table{
+			Header: []string{"layer", "type", "location"},
+			Rows:   [][]string{{result.Layer, result.Type, result.Location}},
+		})
+	}
+
+	source, err := internal.ParseUpdateSource(args[1])
+	if err != nil {
+		return err
+	}
+	// Relative paths would depend on where bext update runs from
+	if source.Type == internal.SourceConfig || (source.Type == internal.SourceRepository && !strings.Contains(source.Location, "://")) {
+		source.Location, err = filepath.Abs(source.Location)
+		if err != nil {
+			return err
+		}
+	}
+	state.Layer(layer).Source = source
+	if err := state.Save(); err != nil {
+		return err
+	}
+	slog.Info("Set update source", slog.String("layer", layer), slog.String("source", source.String()))
+	return nil
+}
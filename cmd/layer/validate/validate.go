@@ -0,0 +1,97 @@
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/jsonschema"
+	"github.com/ublue-os/bext/pkg/output"
+	"github.com/ublue-os/bext/pkg/structures"
+)
+
+var ValidateCmd = &cobra.Command{
+	Use:   "validate CONFIG...",
+	Short: "Check layer configurations for errors before building them",
+	Long: `Check layer configurations in json, yaml or toml against the configuration schema, reporting every error found with the path of the field it belongs to.
+
+With --schema, the JSON Schema of the configuration is printed instead, so editors can use it for completion and validation.`,
+	RunE: validateCmd,
+}
+
+var fSchema *bool
+
+func init() {
+	fSchema = ValidateCmd.Flags().Bool("schema", false, "Print the JSON Schema of layer configurations")
+}
+
+type Result struct {
+	Config string                  `json:"config"`
+	Valid  bool                    `json:"valid"`
+	Errors []jsonschema.FieldError `json:"errors"`
+}
+
+func validateCmd(cmd *cobra.Command, args []string) error {
+	if *fSchema {
+		schema, err := json.MarshalIndent(internal.LayerConfigurationSchema(), "", structures.INDENTATION)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(schema))
+		return nil
+	}
+	if len(args) < 1 {
+		return internal.NewPositionalError("CONFIG")
+	}
+
+	var (
+		results []Result
+		invalid []error
+	)
+	for _, config_path := range args {
+		config_path = path.Clean(config_path)
+		var field_errors []jsonschema.FieldError
+		data, err := internal.ReadLayerConfiguration(config_path)
+		var decode_error *structures.DecodeError
+		if errors.As(err, &decode_error) {
+			field_errors = []jsonschema.FieldError{{Path: "(document)", Message: decode_error.Error()}}
+		} else if err != nil {
+			return err
+		} else {
+			field_errors = internal.ValidateLayerConfiguration(data)
+		}
+		if field_errors == nil {
+			field_errors = []jsonschema.FieldError{}
+		}
+		results = append(results, Result{Config: config_path, Valid: len(field_errors) == 0, Errors: field_errors})
+		if len(field_errors) > 0 {
+			invalid = append(invalid, &internal.LayerConfigurationError{Path: config_path, Errors: field_errors})
+		}
+	}
+
+	if err := output.Write(os.Stdout, internal.Config.OutputFormat, results, resultsView(results)); err != nil {
+		return err
+	}
+	return errors.Join(invalid...)
+}
+
+func resultsView(results []Result) output.Table {
+	view := output.Table{Title: "Validate", Header: []string{"Config", "Field", "Problem"}}
+	if internal.Config.OutputFormat == output.FormatTSV {
+		view.Header = []string{"config", "path", "message"}
+	}
+
+	for _, result := range results {
+		if result.Valid && internal.Config.OutputFormat != output.FormatTSV {
+			view.Rows = append(view.Rows, []string{result.Config, "", "valid"})
+		}
+		for _, field_error := range result.Errors {
+			view.Rows = append(view.Rows, []string{result.Config, field_error.Path, field_error.Message})
+		}
+	}
+	return view
+}
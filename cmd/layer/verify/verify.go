@@ -0,0 +1,366 @@
+package verify
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/chunkstore"
+	"github.com/ublue-os/bext/pkg/filecomp"
+	"github.com/ublue-os/bext/pkg/output"
+)
+
+var VerifyCmd = &cobra.Command{
+	Use:   "verify [LAYER[@HASH]...]",
+	Short: "Check cached blobs against the digest in their filename",
+	Long: `Rehash cached blobs and compare them against the digest their filename was given when added, verifying every blob of every layer when nothing is specified. Packed blobs are reassembled from the chunk store while they are hashed.
+
+With --quarantine, corrupted blobs are moved out of the cache into a quarantine directory next to it, and layers whose current blob was corrupted are deactivated.`,
+	RunE: verifyCmd,
+}
+
+var (
+	fJobs       *int
+	fQuarantine *bool
+)
+
+func init() {
+	fJobs = VerifyCmd.Flags().IntP("jobs", "j", runtime.NumCPU(), "How many blobs are hashed at the same time")
+	fQuarantine = VerifyCmd.Flags().Bool("quarantine", false, "Move corrupted blobs out of the cache and deactivate layers whose current blob is corrupted")
+}
+
+type BlobStatus string
+
+const (
+	StatusOK        BlobStatus = "ok"
+	StatusCorrupted BlobStatus = "corrupted"
+	// The filename is not a digest, so there is nothing to compare against
+	StatusSkipped BlobStatus = "skipped"
+	StatusFailed  BlobStatus = "failed"
+)
+
+type Result struct {
+	Layer   string     `json:"layer"`
+	Hash    string     `json:"hash"`
+	Status  BlobStatus `json:"status"`
+	Current bool       `json:"current"`
+	// Packed blobs are read back through the chunk store
+	Packed bool   `json:"packed"`
+	Actual string `json:"actual,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// What was done about a corrupted blob: quarantined, deactivated
+	Actions []string `json:"actions,omitempty"`
+	path    string
+}
+
+func verifyCmd(cmd *cobra.Command, args []string) error {
+	if *fJobs < 1 {
+		return internal.NewInvalidOptionError("jobs")
+	}
+	cache_dir, err := filepath.Abs(path.Clean(internal.Config.CacheDir))
+	if err != nil {
+		return err
+	}
+
+	targets, err := collectBlobs(cache_dir, args)
+	if err != nil {
+		return err
+	}
+
+	results := hashBlobs(targets, *fJobs)
+
+	var corrupted, unreadable []string
+	for i := range results {
+		// An I/O error while reading is as much a sign of a failing disk as a wrong digest
+		if results[i].Status == StatusFailed {
+			unreadable = append(unreadable, results[i].Layer+"@"+results[i].Hash)
+			slog.Warn("Failed reading blob", slog.String("layer", results[i].Layer), slog.String("hash", results[i].Hash), slog.String("error", results[i].Error))
+			continue
+		}
+		if results[i].Status != StatusCorrupted {
+			continue
+		}
+		corrupted = append(corrupted, results[i].Layer+"@"+results[i].Hash)
+		slog.Warn("Blob is corrupted", slog.String("layer", results[i].Layer), slog.String("hash", results[i].Hash), slog.String("actual", results[i].Actual))
+		if *fQuarantine {
+			quarantine(cache_dir, &results[i])
+		}
+	}
+
+	if err := output.Write(os.Stdout, internal.Config.OutputFormat, results, resultsView(results)); err != nil {
+		return err
+	}
+
+	var verify_err error
+	if len(corrupted) > 0 {
+		verify_err = &internal.CorruptedBlobsError{Blobs: corrupted}
+	}
+	if len(unreadable) > 0 {
+		verify_err = errors.Join(verify_err, &internal.UnreadableBlobsError{Blobs: unreadable})
+	}
+	return verify_err
+}
+
+// Resolves LAYER and LAYER@HASH references to blob paths, every blob of every layer without references
+func collectBlobs(cache_dir string, refs []string) ([]Result, error) {
+	packed_blobs, err := internal.PackedBlobs()
+	if err != nil {
+		return nil, err
+	}
+	packed := map[string][]string{}
+	for _, blob := range packed_blobs {
+		packed[blob.Layer] = append(packed[blob.Layer], blob.Hash)
+	}
+
+	if len(refs) == 0 {
+		entries, err := os.ReadDir(cache_dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				refs = append(refs, entry.Name())
+			}
+		}
+		for layer := range packed {
+			if !slices.Contains(refs, layer) {
+				refs = append(refs, layer)
+			}
+		}
+		slices.Sort(refs)
+	}
+
+	var targets []Result
+	for _, ref := range refs {
+		layer, hash := internal.ParseLayerRef(ref)
+		layer_dir := path.Join(cache_dir, layer)
+
+		current_hash := ""
+		if current, err := filepath.EvalSymlinks(path.Join(layer_dir, internal.CurrentBlobName)); err == nil {
+			current_hash = path.Base(current)
+		}
+
+		var hashes []string
+		if hash != "" {
+			hashes = []string{hash}
+		} else {
+			entries, err := os.ReadDir(layer_dir)
+			if errors.Is(err, os.ErrNotExist) && len(packed[layer]) == 0 {
+				return nil, &internal.LayerNotFoundError{Layer: layer}
+			} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			for _, entry := range entries {
+				if entry.Type().IsRegular() {
+					hashes = append(hashes, entry.Name())
+				}
+			}
+		}
+
+		for _, blob_hash := range hashes {
+			blob_path := path.Join(layer_dir, blob_hash)
+			if _, err := os.Stat(blob_path); err != nil {
+				if !slices.Contains(packed[layer], blob_hash) {
+					return nil, &internal.BlobNotFoundError{Layer: layer, Hash: blob_hash}
+				}
+				targets = append(targets, Result{Layer: layer, Hash: blob_hash, Packed: true})
+				continue
+			}
+			targets = append(targets, Result{Layer: layer, Hash: blob_hash, Current: blob_hash == current_hash, path: blob_path})
+		}
+		if hash == "" {
+			for _, blob_hash := range packed[layer] {
+				targets = append(targets, Result{Layer: layer, Hash: blob_hash, Packed: true})
+			}
+		}
+	}
+	return targets, nil
+}
+
+// Blobs are hashed by a fixed number of workers, results keep the order of targets
+func hashBlobs(targets []Result, jobs int) []Result {
+	results := slices.Clone(targets)
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < min(jobs, len(results)); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				slog.Debug("Hashing blob", slog.String("layer", results[i].Layer), slog.String("hash", results[i].Hash), slog.Bool("packed", results[i].Packed))
+				verifyBlob(&results[i])
+			}
+		}()
+	}
+
+	for i := range results {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
+}
+
+func verifyBlob(result *Result) {
+	expected, err := hex.DecodeString(result.Hash)
+	if err != nil || len(expected) != md5.Size {
+		result.Status = StatusSkipped
+		return
+	}
+
+	blob, err := openBlob(result)
+	if err != nil {
+		result.Status = StatusFailed
+		result.Error = err.Error()
+		return
+	}
+	defer blob.Close()
+
+	actual, err := filecomp.StreamChecksum(blob, md5.New())
+	var chunk_err *chunkstore.CorruptedChunkError
+	if errors.As(err, &chunk_err) {
+		// A chunk that does not match its own digest corrupts every blob made from it
+		result.Status = StatusCorrupted
+		result.Error = err.Error()
+		return
+	} else if err != nil {
+		result.Status = StatusFailed
+		result.Error = err.Error()
+		return
+	}
+
+	result.Actual = hex.EncodeToString(actual)
+	if result.Actual != result.Hash {
+		result.Status = StatusCorrupted
+		return
+	}
+	result.Status = StatusOK
+}
+
+func openBlob(result *Result) (io.ReadCloser, error) {
+	if result.Packed {
+		blob, _, err := internal.OpenBlob(result.Layer, result.Hash)
+		return blob, err
+	}
+	return os.Open(result.path)
+}
+
+// Moves the blob to the quarantine directory, deactivating its layer first when it was the current blob
+func quarantine(cache_dir string, result *Result) {
+	if result.Current {
+		if err := deactivate(cache_dir, result.Layer, result.path); err != nil {
+			slog.Warn("Failed deactivating layer "+result.Layer, slog.String("error", err.Error()))
+			result.Error = err.Error()
+			return
+		}
+		result.Actions = append(result.Actions, "deactivated")
+	}
+
+	quarantine_dir, err := internal.QuarantineDir()
+	if err != nil {
+		result.Error = err.Error()
+		return
+	}
+	target := path.Join(quarantine_dir, result.Layer, result.Hash)
+	if err := os.MkdirAll(path.Dir(target), 0755); err != nil {
+		result.Error = err.Error()
+		return
+	}
+	if result.Packed {
+		if err := quarantinePacked(result, target); err != nil {
+			slog.Warn("Failed quarantining packed blob "+internal.PackedBlobName(result.Layer, result.Hash), slog.String("error", err.Error()))
+			result.Error = err.Error()
+			return
+		}
+	} else if err := os.Rename(result.path, target); err != nil {
+		slog.Warn("Failed quarantining blob "+result.path, slog.String("error", err.Error()))
+		result.Error = err.Error()
+		return
+	}
+	slog.Info("Quarantined blob", slog.String("layer", result.Layer), slog.String("hash", result.Hash), slog.String("target", target))
+	result.Actions = append(result.Actions, "quarantined")
+}
+
+// Reassembles what can still be read of a packed blob into the quarantine directory and removes it from the chunk store.
+// Its chunks are shared with other blobs, so they stay until they are pruned.
+func quarantinePacked(result *Result, target string) error {
+	blob, _, err := internal.OpenBlob(result.Layer, result.Hash)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	// A corrupted chunk stops the copy, the part before it is still worth keeping
+	if _, err := io.Copy(out, blob); err != nil {
+		slog.Debug("Packed blob was quarantined partially", slog.String("target", target), slog.String("error", err.Error()))
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return internal.RemovePackedBlob(result.Layer, result.Hash)
+}
+
+// Removes current_blob and the activation link when it leads to the blob, since both would dangle once it is quarantined
+func deactivate(cache_dir string, layer string, blob_path string) error {
+	activated_path := path.Join(internal.Config.ExtensionsDir, layer+internal.ValidSysextExtension)
+	activated_stat, activated_err := os.Stat(activated_path)
+	blob_stat, blob_err := os.Stat(blob_path)
+	if activated_err == nil && blob_err == nil && os.SameFile(activated_stat, blob_stat) {
+		if err := os.Remove(activated_path); err != nil {
+			return err
+		}
+	}
+	current_path := path.Join(cache_dir, layer, internal.CurrentBlobName)
+	if err := os.Remove(current_path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	slog.Info(fmt.Sprintf("Deactivated layer %s, refresh the system extensions for it to take effect", layer), slog.String("layer", layer))
+	return nil
+}
+
+func resultsView(results []Result) output.Table {
+	view := output.Table{Title: "Verify", Header: []string{"Layer", "Blob", "Status", "Actions"}}
+	tsv := internal.Config.OutputFormat == output.FormatTSV
+	if tsv {
+		view.Header = []string{"layer", "hash", "status", "current", "actual", "actions"}
+	}
+
+	for _, result := range results {
+		status := string(result.Status)
+		if result.Error != "" {
+			status += ": " + result.Error
+		}
+		actions := strings.Join(result.Actions, ", ")
+
+		if tsv {
+			view.Rows = append(view.Rows, []string{result.Layer, result.Hash, status, strconv.FormatBool(result.Current), result.Actual, actions})
+			continue
+		}
+		blob := result.Hash
+		if result.Current {
+			blob += " (current)"
+		} else if result.Packed {
+			blob += " (packed)"
+		}
+		view.Rows = append(view.Rows, []string{result.Layer, blob, status, actions})
+	}
+	return view
+}
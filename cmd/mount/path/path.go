@@ -24,7 +24,7 @@ var (
 )
 
 func init() {
-	fPathPath = PathCmd.Flags().StringP("path", "p", "/tmp/extensions.d/bin", "Path where all shared binaries will be mounted to")
+	fPathPath = PathCmd.Flags().StringP("path", "p", internal.DefaultPathMount, "Path where all shared binaries will be mounted to")
 }
 
 func pathCmd(cmd *cobra.Command, args []string) error {
@@ -0,0 +1,436 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"syscall"
+
+	"github.com/ublue-os/bext/pkg/chattr"
+	"github.com/ublue-os/bext/pkg/mountinfo"
+)
+
+// Every state the nix store can be in, as far as bext is concerned
+type storeState int
+
+const (
+	// /nix/store does not exist
+	stateNoStore storeState = iota
+	// /nix/store exists and has nothing in it
+	stateEmptyStore
+	// /nix/store has a host nix installation that is not preserved anywhere else
+	stateHostStore
+	// The host nix store is bind-mounted to the bindmount path
+	stateHostPreserved
+	// The parent of the host nix store (/nix) is bind-mounted to the bindmount path
+	stateParentPreserved
+	// The layered store is mounted over an empty /nix/store
+	stateLayered
+	// The layered store is mounted over /nix/store and the host store is preserved
+	stateLayeredPreserved
+	// /nix/store is an overlay of the layered store and the host store
+	stateOverlay
+)
+
+func (s storeState) String() string {
+	switch s {
+	case stateNoStore:
+		return "no-store"
+	case stateEmptyStore:
+		return "empty-store"
+	case stateHostStore:
+		return "host-store"
+	case stateHostPreserved:
+		return "host-preserved"
+	case stateParentPreserved:
+		return "parent-preserved"
+	case stateLayered:
+		return "layered"
+	case stateLayeredPreserved:
+		return "layered-preserved"
+	case stateOverlay:
+		return "overlay"
+	}
+	return "unknown"
+}
+
+// What the store should look like once every operation is applied
+type storeTarget string
+
+const (
+	targetUnmounted storeTarget = "unmounted"
+	// The layered store shadows the host store with a read-only bind mount
+	targetBind storeTarget = "bind"
+	// The host store and the layered store are merged with overlayfs
+	targetOverlay storeTarget = "overlay"
+)
+
+var validModes = []string{string(targetBind), string(targetOverlay)}
+
+var terminalStates = map[storeTarget][]storeState{
+	targetUnmounted: {stateNoStore, stateEmptyStore, stateHostStore},
+	targetBind:      {stateLayered, stateLayeredPreserved},
+	targetOverlay:   {stateOverlay},
+}
+
+// Whether the state is terminal for the target
+func (s storeState) reached(target storeTarget) bool {
+	return slices.Contains(terminalStates[target], s)
+}
+
+// A single step towards the target state, Rollback undoes Apply
+type operation struct {
+	Description string
+	Apply       func() error
+	Rollback    func() error
+}
+
+type storePaths struct {
+	Store     string
+	Layered   string
+	Bindmount string
+}
+
+// Where the host store can be found when its parent is preserved
+func (p storePaths) overlayUpper() string {
+	return path.Join(p.Bindmount, path.Base(p.Store))
+}
+
+// Has to live on the same mount as the upper directory, which is the host's /nix, so it is removed again with the overlay
+func (p storePaths) overlayWork() string {
+	return path.Join(p.Bindmount, ".bext-overlay-work")
+}
+
+// Reads the current state from mountinfo and the filesystem
+func observeState(paths storePaths) (storeState, error) {
+	if _, err := os.Stat(paths.Store); errors.Is(err, os.ErrNotExist) {
+		return stateNoStore, nil
+	} else if err != nil {
+		return stateNoStore, err
+	}
+
+	mounts, err := mountinfo.Read()
+	if err != nil {
+		return stateNoStore, err
+	}
+	_, bindmount_mounted := mountinfo.Lookup(mounts, paths.Bindmount)
+	parent_preserved := bindmount_mounted && isSameFile(paths.Bindmount, path.Dir(paths.Store))
+	host_preserved := bindmount_mounted && !parent_preserved
+
+	if store_mount, store_mounted := mountinfo.Lookup(mounts, paths.Store); store_mounted {
+		if store_mount.FSType == "overlay" && parent_preserved {
+			return stateOverlay, nil
+		}
+		if isSameFile(paths.Store, paths.Layered) {
+			if host_preserved {
+				return stateLayeredPreserved, nil
+			}
+			return stateLayered, nil
+		}
+	}
+
+	if parent_preserved {
+		return stateParentPreserved, nil
+	}
+	if host_preserved {
+		return stateHostPreserved, nil
+	}
+
+	store_contents, err := os.ReadDir(paths.Store)
+	if err != nil {
+		return stateNoStore, err
+	}
+	if len(store_contents) > 0 {
+		return stateHostStore, nil
+	}
+	return stateEmptyStore, nil
+}
+
+// Observes the store from outside of this package, returning the state name and whether layers are reachable from /nix/store
+func ObserveStore(bindmount_path string) (string, bool, error) {
+	state, err := observeState(storePaths{Store: NixStorePath, Layered: LayeredStorePath, Bindmount: bindmount_path})
+	if err != nil {
+		return "", false, err
+	}
+	return state.String(), state.reached(targetBind) || state.reached(targetOverlay), nil
+}
+
+func isSameFile(a string, b string) bool {
+	a_stat, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	b_stat, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(a_stat, b_stat)
+}
+
+type transition struct {
+	Operation func(storePaths) operation
+	Next      storeState
+}
+
+// Switching between modes goes through the unmounted states first
+var unmountTransitions = map[storeState]transition{
+	stateLayered:          {unmountLayeredOperation, stateEmptyStore},
+	stateLayeredPreserved: {unmountLayeredOperation, stateHostPreserved},
+	stateHostPreserved:    {releaseHostOperation, stateHostStore},
+	stateOverlay:          {unmountOverlayOperation, stateParentPreserved},
+	stateParentPreserved:  {releaseParentOperation, stateHostStore},
+}
+
+var transitions = map[storeTarget]map[storeState]transition{
+	targetUnmounted: unmountTransitions,
+	targetBind: {
+		stateNoStore:         {createStoreOperation, stateEmptyStore},
+		stateEmptyStore:      {mountLayeredOperation, stateLayered},
+		stateHostStore:       {preserveHostOperation, stateHostPreserved},
+		stateHostPreserved:   {mountLayeredOperation, stateLayeredPreserved},
+		stateOverlay:         unmountTransitions[stateOverlay],
+		stateParentPreserved: unmountTransitions[stateParentPreserved],
+	},
+	targetOverlay: {
+		stateNoStore:          {createStoreOperation, stateEmptyStore},
+		stateEmptyStore:       {preserveParentOperation, stateParentPreserved},
+		stateHostStore:        {preserveParentOperation, stateParentPreserved},
+		stateParentPreserved:  {mountOverlayOperation, stateOverlay},
+		stateLayered:          unmountTransitions[stateLayered],
+		stateLayeredPreserved: unmountTransitions[stateLayeredPreserved],
+		stateHostPreserved:    unmountTransitions[stateHostPreserved],
+	},
+}
+
+// Walks the state machine from the current state until the target is reached, without touching anything
+func planTransitions(current storeState, target storeTarget, paths storePaths) ([]operation, error) {
+	var plan []operation
+	for state := current; !state.reached(target); {
+		next, exists := transitions[target][state]
+		if !exists {
+			return nil, fmt.Errorf("no transition from store state %s to %s", state, target)
+		}
+		plan = append(plan, next.Operation(paths))
+		state = next.Next
+	}
+	return plan, nil
+}
+
+// Applies every operation in order, undoing the applied ones in reverse if any of them fails
+func executePlan(plan []operation) error {
+	for i, op := range plan {
+		slog.Debug(op.Description)
+		err := op.Apply()
+		if err == nil {
+			continue
+		}
+
+		slog.Warn("Failed to "+op.Description+", rolling back", slog.String("error", err.Error()))
+		for j := i - 1; j >= 0; j-- {
+			if rollback_err := plan[j].Rollback(); rollback_err != nil {
+				slog.Warn("Failed rolling back: "+plan[j].Description, slog.String("error", rollback_err.Error()))
+				err = errors.Join(err, rollback_err)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// Runs action with the immutable attribute removed from /, always restoring it afterwards if it was set
+func withMutableRoot(action func() error) (err error) {
+	root_dir, err := os.Open("/")
+	if err != nil {
+		return err
+	}
+	defer root_dir.Close()
+
+	was_immutable, err := chattr.IsAttr(root_dir, chattr.FS_IMMUTABLE_FL)
+	if errors.Is(err, syscall.ENOTTY) || errors.Is(err, syscall.EOPNOTSUPP) {
+		// Filesystems without attribute support cannot be immutable to begin with
+		was_immutable = false
+	} else if err != nil {
+		return err
+	}
+
+	if was_immutable {
+		slog.Debug("Unsetting immutable attribute", slog.String("target", "/"))
+		if err := chattr.UnsetAttr(root_dir, chattr.FS_IMMUTABLE_FL); err != nil {
+			slog.Warn("Failed unsetting immutable attribute from /", slog.String("target", "/"))
+			return err
+		}
+		defer func() {
+			slog.Debug("Restoring immutable attribute", slog.String("target", "/"))
+			if restore_err := chattr.SetAttr(root_dir, chattr.FS_IMMUTABLE_FL); restore_err != nil {
+				slog.Warn("Failed restoring immutable attribute to /", slog.String("target", "/"))
+				err = errors.Join(err, restore_err)
+			}
+		}()
+	}
+
+	return action()
+}
+
+func createStoreOperation(paths storePaths) operation {
+	store_parent := path.Dir(paths.Store)
+	var created_parent bool
+	return operation{
+		Description: "create nix store " + paths.Store,
+		Apply: func() error {
+			if _, err := os.Stat(store_parent); err == nil {
+				return os.MkdirAll(paths.Store, 0755)
+			}
+			// Creating a directory in / requires lifting its immutable attribute
+			created_parent = true
+			return withMutableRoot(func() error {
+				return os.MkdirAll(paths.Store, 0755)
+			})
+		},
+		Rollback: func() error {
+			if !created_parent {
+				return os.Remove(paths.Store)
+			}
+			return withMutableRoot(func() error {
+				return os.RemoveAll(store_parent)
+			})
+		},
+	}
+}
+
+func mountLayered(paths storePaths) error {
+	if err := syscall.Mount(paths.Layered, paths.Store, "bind", uintptr(syscall.MS_BIND), ""); err != nil {
+		return err
+	}
+	// MS_RDONLY is ignored when creating a bind mount, it has to be applied on a remount
+	if err := syscall.Mount(paths.Layered, paths.Store, "bind", uintptr(syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY), ""); err != nil {
+		_ = syscall.Unmount(paths.Store, 0)
+		return err
+	}
+	return nil
+}
+
+func mountLayeredOperation(paths storePaths) operation {
+	return operation{
+		Description: fmt.Sprintf("mount %s to %s read-only", paths.Layered, paths.Store),
+		Apply: func() error {
+			return mountLayered(paths)
+		},
+		Rollback: func() error {
+			return syscall.Unmount(paths.Store, 0)
+		},
+	}
+}
+
+func unmountLayeredOperation(paths storePaths) operation {
+	return operation{
+		Description: "unmount layered store from " + paths.Store,
+		Apply: func() error {
+			return syscall.Unmount(paths.Store, 0)
+		},
+		Rollback: func() error {
+			return mountLayered(paths)
+		},
+	}
+}
+
+func preserveHostOperation(paths storePaths) operation {
+	return operation{
+		Description: fmt.Sprintf("bind-mount host store %s to %s", paths.Store, paths.Bindmount),
+		Apply: func() error {
+			if err := os.MkdirAll(paths.Bindmount, 0755); err != nil {
+				return err
+			}
+			return syscall.Mount(paths.Store, paths.Bindmount, "bind", uintptr(syscall.MS_BIND), "")
+		},
+		Rollback: func() error {
+			return syscall.Unmount(paths.Bindmount, 0)
+		},
+	}
+}
+
+func releaseHostOperation(paths storePaths) operation {
+	return operation{
+		Description: "unmount host store bindmount " + paths.Bindmount,
+		Apply: func() error {
+			return syscall.Unmount(paths.Bindmount, 0)
+		},
+		Rollback: func() error {
+			return syscall.Mount(paths.Store, paths.Bindmount, "bind", uintptr(syscall.MS_BIND), "")
+		},
+	}
+}
+
+func preserveParentOperation(paths storePaths) operation {
+	store_parent := path.Dir(paths.Store)
+	return operation{
+		Description: fmt.Sprintf("bind-mount host store parent %s to %s", store_parent, paths.Bindmount),
+		Apply: func() error {
+			if err := os.MkdirAll(paths.Bindmount, 0755); err != nil {
+				return err
+			}
+			return syscall.Mount(store_parent, paths.Bindmount, "bind", uintptr(syscall.MS_BIND), "")
+		},
+		Rollback: func() error {
+			return syscall.Unmount(paths.Bindmount, 0)
+		},
+	}
+}
+
+func releaseParentOperation(paths storePaths) operation {
+	store_parent := path.Dir(paths.Store)
+	return operation{
+		Description: "unmount host store parent bindmount " + paths.Bindmount,
+		Apply: func() error {
+			return syscall.Unmount(paths.Bindmount, 0)
+		},
+		Rollback: func() error {
+			return syscall.Mount(store_parent, paths.Bindmount, "bind", uintptr(syscall.MS_BIND), "")
+		},
+	}
+}
+
+// The host store is the upper directory so host nix keeps writing to it, layers are read-only lower directories
+func mountOverlay(paths storePaths) error {
+	if err := os.MkdirAll(paths.overlayWork(), 0755); err != nil {
+		return err
+	}
+	options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", paths.Layered, paths.overlayUpper(), paths.overlayWork())
+	if err := syscall.Mount("overlay", paths.Store, "overlay", 0, options); err != nil {
+		return errors.Join(err, os.RemoveAll(paths.overlayWork()))
+	}
+	return nil
+}
+
+func unmountOverlay(paths storePaths) error {
+	if err := syscall.Unmount(paths.Store, 0); err != nil {
+		return err
+	}
+	return os.RemoveAll(paths.overlayWork())
+}
+
+func mountOverlayOperation(paths storePaths) operation {
+	return operation{
+		Description: fmt.Sprintf("mount overlay of %s and %s to %s", paths.Layered, paths.overlayUpper(), paths.Store),
+		Apply: func() error {
+			return mountOverlay(paths)
+		},
+		Rollback: func() error {
+			return unmountOverlay(paths)
+		},
+	}
+}
+
+func unmountOverlayOperation(paths storePaths) operation {
+	return operation{
+		Description: "unmount store overlay from " + paths.Store,
+		Apply: func() error {
+			return unmountOverlay(paths)
+		},
+		Rollback: func() error {
+			return mountOverlay(paths)
+		},
+	}
+}
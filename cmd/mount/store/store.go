@@ -6,18 +6,21 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-	"syscall"
+	"slices"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/ublue-os/bext/internal"
-	"github.com/ublue-os/bext/pkg/chattr"
 )
 
 var StoreCmd = &cobra.Command{
 	Use:   "store",
 	Short: fmt.Sprintf("Mount %s to %s safely", LayeredStorePath, NixStorePath),
-	Long:  fmt.Sprintf(`Mount %s to %s so that your layered binaries may work`, LayeredStorePath, NixStorePath),
-	RunE:  storeCmd,
+	Long: fmt.Sprintf(`Mount %s to %s so that your layered binaries may work.
+
+In bind mode the layered store is bind-mounted read-only over %s, and any host store is kept available at the bindmount path.
+In overlay mode %s becomes an overlay where the host store is the writable upper directory and the layered store is a lower directory, so host nix and layers coexist.`, LayeredStorePath, NixStorePath, NixStorePath, NixStorePath),
+	RunE: storeCmd,
 }
 
 const NixStorePath = "/nix/store"
@@ -25,10 +28,14 @@ const LayeredStorePath = "/usr/store"
 
 var (
 	fStoreBindmountPath *string
+	fDryRun             *bool
+	fMode               *string
 )
 
 func init() {
-	fStoreBindmountPath = StoreCmd.Flags().String("bindmount-path", "/tmp/nix-store-bindmount", "Path where an already existing nix store will be bind-mounted to")
+	fStoreBindmountPath = StoreCmd.Flags().String("bindmount-path", internal.DefaultStoreBindmount, "Path where an already existing nix store will be bind-mounted to")
+	fMode = StoreCmd.Flags().String("mode", string(targetBind), fmt.Sprintf("How the layered store is combined with the host store (%s)", strings.Join(validModes, "|")))
+	fDryRun = StoreCmd.Flags().Bool("dry-run", false, "Do not mount anything, just print the planned mount operations")
 }
 
 func storeCmd(cmd *cobra.Command, args []string) error {
@@ -36,90 +43,63 @@ func storeCmd(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-
+	paths := storePaths{
+		Store:     NixStorePath,
+		Layered:   LayeredStorePath,
+		Bindmount: bindmount_path,
+	}
+	target := storeTarget(*fMode)
+	if !slices.Contains(validModes, *fMode) {
+		return internal.NewInvalidOptionError(*fMode)
+	}
 	if *internal.Config.UnmountFlag {
-		slog.Debug("Unmounting store", slog.String("target", NixStorePath))
-		if err := syscall.Unmount(NixStorePath, 0); err != nil {
-			return err
-		}
-
-		slog.Debug("Unmounting bindmount", slog.String("target", bindmount_path))
-		if err := syscall.Unmount(bindmount_path, 0); err != nil {
-			return err
-		}
-		slog.Info("Successfully unmounted store and bindmount", slog.String("store_path", NixStorePath), slog.String("bindmount_path", bindmount_path))
-		return nil
+		target = targetUnmounted
 	}
 
-	if _, err := os.Stat("/nix"); err != nil {
-		root_dir, err := os.Open("/")
-		if err != nil {
-			return err
-		}
-		defer root_dir.Close()
-
-		slog.Debug("Creating nix store", slog.String("target", NixStorePath))
-		err = chattr.SetAttr(root_dir, chattr.FS_IMMUTABLE_FL)
-		if err != nil {
-			slog.Warn("Failed unsetting immutable attributes to /", slog.String("target", "/"))
-			return err
-		}
-		if err := os.MkdirAll(NixStorePath, 0755); err != nil {
-			slog.Warn("Failed creating root nix store path", slog.String("target", NixStorePath))
-			return err
-		}
-		err = chattr.UnsetAttr(root_dir, chattr.FS_IMMUTABLE_FL)
-		if err != nil {
-			slog.Warn("Failed setting immutable attributes to /", slog.String("target", "/"))
-			return err
-		}
-	} else if _, err := os.Stat(NixStorePath); err != nil {
-		if err := os.MkdirAll(NixStorePath, 0755); err != nil {
-			slog.Warn("Failed creating root nix store path", slog.String("target", NixStorePath))
-			return err
-		}
+	if _, err := os.Stat(LayeredStorePath); err != nil && target != targetUnmounted {
+		slog.Warn("No layered store could be found in " + LayeredStorePath)
+		return err
 	}
 
-	store_contents, err := os.ReadDir(NixStorePath)
+	current_state, err := observeState(paths)
 	if err != nil {
 		return err
 	}
+	slog.Debug("Observed store state", slog.String("state", current_state.String()))
 
-	if len(store_contents) > 0 {
-		_ = syscall.Unmount(NixStorePath, 0)
+	plan, err := planTransitions(current_state, target, paths)
+	if err != nil {
+		return err
 	}
 
-	if _, err := os.Stat(bindmount_path); err != nil && len(store_contents) > 0 {
-		slog.Debug("Creating bindmount", slog.String("target", bindmount_path))
-		if err := os.MkdirAll(bindmount_path, 0755); err != nil {
-			return err
-		}
-
-		_ = syscall.Unmount(NixStorePath, 0)
-		_ = syscall.Unmount(bindmount_path, 0)
-
-		slog.Debug("Mounting bindmount", slog.String("source", bindmount_path), slog.String("target", NixStorePath))
-		if err := syscall.Mount(NixStorePath, bindmount_path, "bind", uintptr(syscall.MS_BIND), ""); err != nil {
-			slog.Warn("Failed mounting root nix store to bindmount", slog.String("source", NixStorePath), slog.String("target", bindmount_path))
-			return err
-		}
+	if len(plan) == 0 {
+		slog.Info("Store is already in the desired state", slog.String("state", current_state.String()))
+		return nil
+	}
 
-		slog.Debug("Mounting store to itself", slog.String("source", NixStorePath), slog.String("target", bindmount_path))
-		if err := syscall.Mount(bindmount_path, NixStorePath, "bind", uintptr(syscall.MS_BIND), ""); err != nil {
-			slog.Warn("Failed mounting bindmount to root nix store", slog.String("source", bindmount_path), slog.String("target", NixStorePath))
-			return err
+	if *fDryRun {
+		for _, op := range plan {
+			slog.Info("Would " + op.Description)
 		}
+		return nil
 	}
 
-	if _, err := os.Stat(LayeredStorePath); err != nil {
-		slog.Warn("No layered store could be found in " + LayeredStorePath)
+	if err := executePlan(plan); err != nil {
 		return err
 	}
 
-	slog.Info(fmt.Sprintf("Mounting %s to %s", LayeredStorePath, NixStorePath), slog.String("source", LayeredStorePath), slog.String("target", NixStorePath))
-	if err := syscall.Mount(LayeredStorePath, NixStorePath, "bind", uintptr(syscall.MS_BIND|syscall.MS_RDONLY), ""); err != nil {
-		slog.Warn("Failed mounting layered nix stores to root nix store.", slog.String("source", LayeredStorePath), slog.String("target", NixStorePath))
+	final_state, err := observeState(paths)
+	if err != nil {
 		return err
 	}
+	if !final_state.reached(target) {
+		return fmt.Errorf("store ended up in unexpected state %s", final_state)
+	}
+
+	if target != targetUnmounted {
+		slog.Info(fmt.Sprintf("Mounted %s to %s", LayeredStorePath, NixStorePath), slog.String("source", LayeredStorePath), slog.String("target", NixStorePath), slog.String("mode", *fMode))
+	} else {
+		slog.Info("Successfully unmounted store and bindmount", slog.String("store_path", NixStorePath), slog.String("bindmount_path", bindmount_path))
+	}
 	return nil
 }
@@ -5,20 +5,30 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/cmd/buildCache"
+	"github.com/ublue-os/bext/cmd/cache"
+	"github.com/ublue-os/bext/cmd/config"
+	"github.com/ublue-os/bext/cmd/doctor"
+	"github.com/ublue-os/bext/cmd/env"
 	"github.com/ublue-os/bext/cmd/layer"
 	"github.com/ublue-os/bext/cmd/mount"
+	"github.com/ublue-os/bext/cmd/run"
+	"github.com/ublue-os/bext/cmd/status"
+	"github.com/ublue-os/bext/cmd/update"
 	"github.com/ublue-os/bext/internal"
 	"github.com/ublue-os/bext/pkg/logging"
 	appLogging "github.com/ublue-os/bext/pkg/logging"
+	"github.com/ublue-os/bext/pkg/output"
 )
 
 var RootCmd = &cobra.Command{
 	Use:               "bext",
 	Short:             "Manager for Systemd system extensions",
 	Long:              `Manage your systemd system extensions from your CLI, managing their cache, multiple versions, and building.`,
-	PersistentPreRunE: initLogging,
+	PersistentPreRunE: persistentPreRun,
 	SilenceUsage:      true,
 }
 
@@ -26,6 +36,9 @@ var (
 	fLogFile   string
 	fLogLevel  string
 	fNoLogging bool
+	fOutput    string
+	fWait      bool
+	fNoWait    bool
 )
 
 func Execute() {
@@ -35,8 +48,42 @@ func Execute() {
 	}
 }
 
+func persistentPreRun(cmd *cobra.Command, args []string) error {
+	resolved, err := internal.ResolveSettings(*internal.Config.UserMode)
+	if err != nil {
+		return err
+	}
+	if err := internal.ApplySettings(cmd.Name(), cmd.Flags(), resolved); err != nil {
+		return err
+	}
+	internal.Config.ResolvedSettings = resolved
+
+	format, err := output.ParseFormat(fOutput)
+	if err != nil {
+		return err
+	}
+	internal.Config.OutputFormat = format
+	// Progress bars are drawn on stdout and replace the logs, neither belongs in a document
+	if format.IsMachineReadable() {
+		*internal.Config.NoProgress = true
+	}
+
+	if err := initLogging(cmd, args); err != nil {
+		return err
+	}
+
+	if cmd.Flags().Changed("wait") && cmd.Flags().Changed("no-wait") {
+		return internal.NewInvalidOptionError("--wait", "--no-wait")
+	}
+	return internal.LockCache(internal.CommandLockMode(cmd.CommandPath(), cmd.Flags()), fWait && !fNoWait)
+}
+
 func initLogging(cmd *cobra.Command, args []string) error {
 	var logWriter *os.File = os.Stdout
+	// Keep stdout clean for whatever is parsing it
+	if internal.Config.OutputFormat.IsMachineReadable() {
+		logWriter = os.Stderr
+	}
 	if fLogFile != "-" {
 		abs, err := filepath.Abs(path.Clean(fLogFile))
 		if err != nil {
@@ -68,8 +115,21 @@ func init() {
 	RootCmd.PersistentFlags().StringVar(&fLogLevel, "log-level", "info", "Log level for user-facing logs")
 	RootCmd.PersistentFlags().BoolVar(&fNoLogging, "quiet", false, "Do not log anything to anywhere")
 	internal.Config.NoProgress = RootCmd.PersistentFlags().Bool("no-progress", false, "Do not use progress bars whenever they would be")
+	RootCmd.PersistentFlags().StringVar(&fOutput, "output", string(output.FormatTable), "Output format for commands that print results: "+strings.Join(output.ValidFormats, ", "))
+	RootCmd.PersistentFlags().BoolVar(&fWait, "wait", true, "Wait for other bext processes using the cache instead of failing")
+	RootCmd.PersistentFlags().BoolVar(&fNoWait, "no-wait", false, "Fail right away when another bext process is using the cache")
+	internal.Config.UserMode = RootCmd.PersistentFlags().Bool("user", false, "Manage layers for the current user in $XDG_CACHE_HOME and $XDG_DATA_HOME instead of system-wide")
 
 	RootCmd.AddCommand(layer.LayerCmd)
 	RootCmd.AddCommand(mount.MountCmd)
 	RootCmd.AddCommand(AddToPathCmd)
+	RootCmd.AddCommand(env.EnvCmd)
+	RootCmd.AddCommand(run.RunCmd)
+	RootCmd.AddCommand(run.NamespaceCmd)
+	RootCmd.AddCommand(status.StatusCmd)
+	RootCmd.AddCommand(doctor.DoctorCmd)
+	RootCmd.AddCommand(config.ConfigCmd)
+	RootCmd.AddCommand(buildCache.BuildCacheCmd)
+	RootCmd.AddCommand(cache.CacheCmd)
+	RootCmd.AddCommand(update.UpdateCmd)
 }
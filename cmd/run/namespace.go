@@ -0,0 +1,202 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/pkg/loopdev"
+)
+
+// Second stage of run, executed by bext itself inside of the new namespaces
+var NamespaceCmd = &cobra.Command{
+	Use:    "run-namespace",
+	Short:  "Set up layer mounts inside of a namespace and run a command",
+	Hidden: true,
+	RunE:   namespaceCmd,
+	Args:   cobra.MinimumNArgs(1),
+}
+
+var (
+	fImages     *[]string
+	fMountRoot  *string
+	fPrivileged *bool
+	fReadyFd    *int
+)
+
+const (
+	usrPath      = "/usr"
+	nixStorePath = "/nix/store"
+)
+
+func init() {
+	fImages = NamespaceCmd.Flags().StringArray("image", []string{}, "Layer image that will be mounted")
+	fMountRoot = NamespaceCmd.Flags().String("mount-root", "", "Directory where the layer images will be mounted to")
+	fPrivileged = NamespaceCmd.Flags().Bool("privileged", false, "Loop-mount images instead of using squashfuse")
+	fReadyFd = NamespaceCmd.Flags().Int("ready-fd", -1, "File descriptor closed once every layer is mounted")
+}
+
+func namespaceCmd(cmd *cobra.Command, args []string) error {
+	if *fMountRoot == "" {
+		return errors.New("a mount root is required")
+	}
+	// squashfuse keeps running in the background, it must not hold the parent waiting either
+	if *fReadyFd >= 0 {
+		syscall.CloseOnExec(*fReadyFd)
+	}
+
+	exit_code, err := mountAndRun(args)
+	if err != nil {
+		return err
+	}
+	if exit_code != 0 {
+		os.Exit(exit_code)
+	}
+	return nil
+}
+
+// Mounts every layer, runs the command and tears every mount down again
+func mountAndRun(command []string) (int, error) {
+	// Nothing done here should ever propagate back to the parent namespace
+	if err := syscall.Mount("none", "/", "", uintptr(syscall.MS_REC|syscall.MS_PRIVATE), ""); err != nil {
+		return 0, err
+	}
+
+	var mounted []string
+	defer func() {
+		for i := len(mounted) - 1; i >= 0; i-- {
+			slog.Debug("Unmounting", slog.String("target", mounted[i]))
+			if err := syscall.Unmount(mounted[i], syscall.MNT_DETACH); err != nil {
+				slog.Warn("Failed unmounting "+mounted[i], slog.String("error", err.Error()))
+			}
+		}
+	}()
+
+	var (
+		usr_lowers  []string
+		layer_paths []string
+	)
+	for i, image := range *fImages {
+		layer_mount := layerMountPath(*fMountRoot, i)
+		if err := os.MkdirAll(layer_mount, 0755); err != nil {
+			return 0, err
+		}
+
+		slog.Debug("Mounting layer image", slog.String("source", image), slog.String("target", layer_mount))
+		if err := mountImage(image, layer_mount); err != nil {
+			slog.Warn("Failed mounting layer image "+image, slog.String("source", image), slog.String("target", layer_mount))
+			return 0, err
+		}
+		mounted = append(mounted, layer_mount)
+
+		layer_usr := path.Join(layer_mount, "usr")
+		if _, err := os.Stat(layer_usr); err != nil {
+			slog.Warn("Layer image has no /usr, skipping", slog.String("image", image))
+			continue
+		}
+		usr_lowers = append(usr_lowers, layer_usr)
+
+		layer_bins, err := filepath.Glob(path.Join(layer_usr, "extensions.d", "*", "bin"))
+		if err != nil {
+			return 0, err
+		}
+		for _, layer_bin := range layer_bins {
+			layer_paths = append(layer_paths, path.Join(usrPath, strings.TrimPrefix(layer_bin, layer_usr)))
+		}
+	}
+
+	if len(usr_lowers) == 0 {
+		return 0, errors.New("none of the layers could be mounted")
+	}
+
+	// The leftmost lower directory has priority, so layers shadow the host's /usr
+	usr_options := "lowerdir=" + strings.Join(append(usr_lowers, usrPath), ":")
+	slog.Debug("Mounting overlay", slog.String("target", usrPath), slog.String("options", usr_options))
+	if err := syscall.Mount("overlay", usrPath, "overlay", uintptr(syscall.MS_RDONLY), usr_options); err != nil {
+		slog.Warn("Failed overlaying layers on " + usrPath)
+		return 0, err
+	}
+	mounted = append(mounted, usrPath)
+
+	layered_store := path.Join(usrPath, "store")
+	// Creating it here would leave it behind on the host's root, a private namespace does not isolate directories
+	if _, err := os.Stat(nixStorePath); err != nil && *fPrivileged {
+		return 0, fmt.Errorf("%s does not exist, enable mkdir-rootfs@nix.service to create it on boot", nixStorePath)
+	} else if err != nil {
+		slog.Warn(fmt.Sprintf("%s does not exist (enable mkdir-rootfs@nix.service to create it on boot), layered binaries might not work", nixStorePath))
+	} else if _, err := os.Stat(layered_store); err == nil {
+		store_options := fmt.Sprintf("lowerdir=%s:%s", layered_store, nixStorePath)
+		slog.Debug("Mounting overlay", slog.String("target", nixStorePath), slog.String("options", store_options))
+		if err := syscall.Mount("overlay", nixStorePath, "overlay", uintptr(syscall.MS_RDONLY), store_options); err != nil {
+			slog.Warn("Failed overlaying layered store on " + nixStorePath)
+			return 0, err
+		}
+		mounted = append(mounted, nixStorePath)
+	}
+
+	layer_paths = append(layer_paths, os.Getenv("PATH"))
+	if err := os.Setenv("PATH", strings.Join(layer_paths, ":")); err != nil {
+		return 0, err
+	}
+
+	// The command must not inherit it, or the parent would wait for the command to exit
+	if *fReadyFd >= 0 {
+		os.NewFile(uintptr(*fReadyFd), "ready").Close()
+	}
+
+	return runCommand(command)
+}
+
+func mountImage(image string, target string) error {
+	if *fPrivileged {
+		return loopMountImage(image, target)
+	}
+	return fuseMountImage(image, target)
+}
+
+func loopMountImage(image string, target string) error {
+	device, err := loopdev.AttachReadOnly(image)
+	if err != nil {
+		return err
+	}
+	// The device detaches itself once it is unmounted
+	defer device.Close()
+
+	slog.Debug("Attached loop device", slog.String("device", device.Path), slog.String("image", image))
+	return syscall.Mount(device.Path, target, "squashfs", uintptr(syscall.MS_RDONLY|syscall.MS_NODEV|syscall.MS_NOSUID), "")
+}
+
+// Kernel squashfs cannot be mounted in a user namespace, FUSE can
+func fuseMountImage(image string, target string) error {
+	squashfuse, err := exec.LookPath("squashfuse")
+	if err != nil {
+		return errors.New("squashfuse is required for mounting layers without root privileges")
+	}
+
+	out, err := exec.Command(squashfuse, "-o", "ro", image, target).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func runCommand(command []string) (int, error) {
+	child := exec.Command(command[0], command[1:]...)
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+
+	if err := child.Start(); err != nil {
+		return 0, err
+	}
+	defer forwardSignals(child.Process)()
+
+	return waitExitCode(child)
+}
@@ -0,0 +1,214 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/fileio"
+)
+
+var RunCmd = &cobra.Command{
+	Use:   "run [LAYER|FILE...] [-- COMMAND...]",
+	Short: "Run a command with layers available without activating them",
+	Long: `Run a command (or your shell) inside a private mount namespace where the selected layers are overlaid on /usr and /nix/store.
+
+Layers can be names of cached layers or paths to built images. Nothing is activated or mounted outside of the namespace, and every mount is torn down once the command exits, whose exit status is propagated.
+As root, images are loop-mounted. Otherwise (or with --user) an unprivileged user namespace is used, which requires squashfuse.
+/nix/store has to exist already, mkdir-rootfs@nix.service creates it on boot.
+Uses every activated layer when no layers are specified.
+
+Example:
+    bext run ./hello.sysext.raw -- hello
+    bext --user run --layers hello,rsync -- hello`,
+	RunE: runCmd,
+}
+
+var (
+	fLayers *[]string
+)
+
+func init() {
+	fLayers = RunCmd.Flags().StringSliceP("layers", "l", []string{}, "Layers that will be available to the command")
+	RunCmd.Flags().StringVar(&internal.Config.CacheDir, "cache-root", internal.DefaultCacheDir, "root directory for the layer cache")
+	RunCmd.Flags().StringVar(&internal.Config.ExtensionsDir, "extensions-root", internal.DefaultExtensionsDir, "root directory for the systemd-sysext layers")
+}
+
+func runCmd(cmd *cobra.Command, args []string) error {
+	layers := *fLayers
+	command := args
+	if dash_index := cmd.ArgsLenAtDash(); dash_index >= 0 {
+		layers = append(layers, args[:dash_index]...)
+		command = args[dash_index:]
+	} else {
+		layers = append(layers, args...)
+		command = []string{}
+	}
+
+	if len(layers) == 0 {
+		var err error
+		layers, err = internal.ActiveLayers()
+		if err != nil {
+			return err
+		}
+	}
+	if len(layers) == 0 {
+		return errors.New("no layers were specified and none are activated")
+	}
+
+	var images []string
+	for _, layer := range layers {
+		image_path, err := resolveImage(layer)
+		if err != nil {
+			return err
+		}
+		slog.Debug("Resolved layer", slog.String("layer", layer), slog.String("image", image_path))
+		images = append(images, image_path)
+	}
+
+	if len(command) == 0 {
+		user_shell := os.Getenv("SHELL")
+		if user_shell == "" {
+			user_shell = "/bin/sh"
+		}
+		command = []string{user_shell}
+	}
+
+	privileged := os.Geteuid() == 0 && !*internal.Config.UserMode
+	exit_code, err := runInNamespace(images, command, privileged)
+	if err != nil {
+		return err
+	}
+	if exit_code != 0 {
+		slog.Debug("Command exited with non-zero status", slog.Int("exitcode", exit_code))
+		os.Exit(exit_code)
+	}
+	return nil
+}
+
+// Image files are used as-is, anything else is treated as a layer name
+func resolveImage(target string) (string, error) {
+	if strings.HasSuffix(target, internal.ValidSysextExtension) || strings.ContainsRune(target, os.PathSeparator) {
+		if !fileio.FileExist(target) {
+			return "", fmt.Errorf("layer image %s does not exist", target)
+		}
+		return filepath.Abs(path.Clean(target))
+	}
+	return internal.LayerImagePath(target)
+}
+
+// Re-executes bext inside new namespaces to set up the mounts, since unsharing a multi-threaded Go process is unreliable
+func runInNamespace(images []string, command []string, privileged bool) (int, error) {
+	mount_root, err := os.MkdirTemp("", "bext-run-")
+	if err != nil {
+		return 0, err
+	}
+	defer removeMountRoot(mount_root, len(images))
+
+	self_path, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	// The child closes it once every layer is mounted, so the cache is only locked until then
+	ready_reader, ready_writer, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer ready_reader.Close()
+
+	namespace_args := []string{NamespaceCmd.Use, "--mount-root", mount_root, "--ready-fd", "3"}
+	if privileged {
+		namespace_args = append(namespace_args, "--privileged")
+	}
+	for _, image := range images {
+		namespace_args = append(namespace_args, "--image", image)
+	}
+	namespace_args = append(namespace_args, "--")
+	namespace_args = append(namespace_args, command...)
+
+	child := exec.Command(self_path, namespace_args...)
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+	child.ExtraFiles = []*os.File{ready_writer}
+	child.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNS}
+	if !privileged {
+		child.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+		// Mounting requires being root inside of the user namespace
+		child.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+		child.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	}
+
+	err = child.Start()
+	ready_writer.Close()
+	if err != nil {
+		if !privileged {
+			slog.Warn("Failed creating namespace, unprivileged user namespaces might be disabled on this system")
+		}
+		return 0, err
+	}
+	defer forwardSignals(child.Process)()
+
+	// Also returns when the child exits without mounting anything
+	io.Copy(io.Discard, ready_reader)
+	if err := internal.UnlockCache(); err != nil {
+		slog.Warn("Failed releasing the cache lock", slog.String("error", err.Error()))
+	}
+
+	return waitExitCode(child)
+}
+
+// The terminal already delivers SIGINT and SIGQUIT to the whole process group, anything else has to be passed along
+func forwardSignals(process *os.Process) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for received := range signals {
+			if received == syscall.SIGTERM || received == syscall.SIGHUP {
+				_ = process.Signal(received)
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+	}
+}
+
+// Follows the shell convention of 128+N for processes killed by signal N
+func waitExitCode(child *exec.Cmd) (int, error) {
+	err := child.Wait()
+	var exit_err *exec.ExitError
+	if !errors.As(err, &exit_err) {
+		return 0, err
+	}
+	if status, ok := exit_err.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal()), nil
+	}
+	return exit_err.ExitCode(), nil
+}
+
+// Only removes the (empty) mount points, anything else means a mount leaked out of the namespace
+func removeMountRoot(mount_root string, image_count int) {
+	for i := 0; i < image_count; i++ {
+		_ = os.Remove(layerMountPath(mount_root, i))
+	}
+	if err := os.Remove(mount_root); err != nil {
+		slog.Warn("Failed removing temporary mount root", slog.String("path", mount_root), slog.String("error", err.Error()))
+	}
+}
+
+func layerMountPath(mount_root string, index int) string {
+	return fmt.Sprintf("%s/layer-%d", mount_root, index)
+}
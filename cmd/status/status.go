@@ -0,0 +1,237 @@
+package status
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/cmd/mount/store"
+	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/mountinfo"
+	"github.com/ublue-os/bext/pkg/output"
+	"github.com/ublue-os/bext/pkg/sysext"
+)
+
+var StatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the state of every layer, the store and the PATH overlay",
+	Long: `Show layers in the cache and their current blob, which ones are activated and which ones systemd-sysext actually merged, whether the layered store is mounted on /nix/store and whether the PATH overlay is mounted.
+
+Drift, like layers activated but not merged or activation links pointing to missing blobs, makes the command exit with a non-zero status.`,
+	RunE: statusCmd,
+}
+
+var (
+	fPathPath      *string
+	fBindmountPath *string
+)
+
+func init() {
+	StatusCmd.Flags().StringVar(&internal.Config.CacheDir, "cache-root", internal.DefaultCacheDir, "root directory for the layer cache")
+	StatusCmd.Flags().StringVar(&internal.Config.ExtensionsDir, "extensions-root", internal.DefaultExtensionsDir, "root directory for the systemd-sysext layers")
+	fPathPath = StatusCmd.Flags().StringP("path", "p", internal.DefaultPathMount, "Path where the PATH overlay is mounted to")
+	fBindmountPath = StatusCmd.Flags().String("bindmount-path", internal.DefaultStoreBindmount, "Path where an already existing nix store is bind-mounted to")
+}
+
+type Layer struct {
+	Name        string `json:"name"`
+	CurrentBlob string `json:"current_blob"`
+	Activated   bool   `json:"activated"`
+	// Blob the activation link resolves to, empty when not activated or dangling
+	ActivatedBlob string `json:"activated_blob"`
+	Merged        bool   `json:"merged"`
+}
+
+type Store struct {
+	State   string `json:"state"`
+	Layered bool   `json:"layered"`
+}
+
+type PathOverlay struct {
+	Path    string `json:"path"`
+	Mounted bool   `json:"mounted"`
+}
+
+type Drift struct {
+	Layer   string `json:"layer,omitempty"`
+	Problem string `json:"problem"`
+}
+
+type Status struct {
+	Healthy     bool        `json:"healthy"`
+	Layers      []Layer     `json:"layers"`
+	Store       Store       `json:"store"`
+	PathOverlay PathOverlay `json:"path_overlay"`
+	Drift       []Drift     `json:"drift"`
+}
+
+func statusCmd(cmd *cobra.Command, args []string) error {
+	cache_dir, err := filepath.Abs(path.Clean(internal.Config.CacheDir))
+	if err != nil {
+		return err
+	}
+	path_path, err := filepath.Abs(path.Clean(*fPathPath))
+	if err != nil {
+		return err
+	}
+	bindmount_path, err := filepath.Abs(path.Clean(*fBindmountPath))
+	if err != nil {
+		return err
+	}
+
+	status := Status{Layers: []Layer{}, Drift: []Drift{}, PathOverlay: PathOverlay{Path: path_path}}
+
+	cached, err := cachedLayers(cache_dir)
+	if err != nil {
+		return err
+	}
+	activated, err := internal.ActiveLayers()
+	if err != nil {
+		return err
+	}
+	merged, err := sysext.MergedExtensions()
+	if err != nil {
+		return err
+	}
+
+	names := slices.Clone(cached)
+	for _, layer := range activated {
+		if !slices.Contains(names, layer) {
+			names = append(names, layer)
+		}
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		layer := Layer{
+			Name:      name,
+			Activated: slices.Contains(activated, name),
+			Merged:    slices.Contains(merged, name),
+		}
+
+		current_path := path.Join(cache_dir, name, internal.CurrentBlobName)
+		if current, err := filepath.EvalSymlinks(current_path); err == nil {
+			layer.CurrentBlob = path.Base(current)
+		} else if _, lstat_err := os.Lstat(current_path); lstat_err == nil {
+			status.Drift = append(status.Drift, Drift{Layer: name, Problem: "current_blob points to a missing blob"})
+		}
+
+		if layer.Activated {
+			activated_path := path.Join(internal.Config.ExtensionsDir, name+internal.ValidSysextExtension)
+			if target, err := filepath.EvalSymlinks(activated_path); err == nil {
+				layer.ActivatedBlob = path.Base(target)
+			} else {
+				status.Drift = append(status.Drift, Drift{Layer: name, Problem: "activation link points to a missing blob"})
+			}
+			// User layers are never merged by systemd-sysext, bext run mounts them for each command instead
+			if !layer.Merged && !*internal.Config.UserMode {
+				status.Drift = append(status.Drift, Drift{Layer: name, Problem: "activated but not merged"})
+			}
+		}
+		status.Layers = append(status.Layers, layer)
+	}
+
+	store_state, store_layered, err := store.ObserveStore(bindmount_path)
+	if err != nil {
+		return err
+	}
+	status.Store = Store{State: store_state, Layered: store_layered}
+	if !store_layered && slices.ContainsFunc(status.Layers, func(l Layer) bool { return l.Merged }) {
+		status.Drift = append(status.Drift, Drift{Problem: fmt.Sprintf("layers are merged but %s is not mounted on %s", store.LayeredStorePath, store.NixStorePath)})
+	}
+
+	status.PathOverlay.Mounted, err = mountinfo.IsMountPoint(path_path)
+	if err != nil {
+		return err
+	}
+
+	status.Healthy = len(status.Drift) == 0
+	for _, drift := range status.Drift {
+		slog.Debug("Found drift", slog.String("layer", drift.Layer), slog.String("problem", drift.Problem))
+	}
+
+	if err := output.Write(os.Stdout, internal.Config.OutputFormat, status, statusView(status)); err != nil {
+		return err
+	}
+	if internal.Config.OutputFormat == output.FormatTable {
+		printSummary(status)
+	}
+
+	if !status.Healthy {
+		var problems []string
+		for _, drift := range status.Drift {
+			problems = append(problems, driftString(drift))
+		}
+		return &internal.UnhealthyError{Problems: problems}
+	}
+	return nil
+}
+
+func cachedLayers(cache_dir string) ([]string, error) {
+	entries, err := os.ReadDir(cache_dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var layers []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			layers = append(layers, entry.Name())
+		}
+	}
+	return layers, nil
+}
+
+func driftString(drift Drift) string {
+	if drift.Layer == "" {
+		return drift.Problem
+	}
+	return drift.Layer + ": " + drift.Problem
+}
+
+func statusView(status Status) output.Table {
+	view := output.Table{Title: "Layers", Header: []string{"Layer", "Current blob", "Activated", "Merged"}}
+	if internal.Config.OutputFormat == output.FormatTSV {
+		view.Header = []string{"name", "current_blob", "activated", "activated_blob", "merged"}
+		for _, layer := range status.Layers {
+			view.Rows = append(view.Rows, []string{layer.Name, layer.CurrentBlob, strconv.FormatBool(layer.Activated), layer.ActivatedBlob, strconv.FormatBool(layer.Merged)})
+		}
+		return view
+	}
+
+	for _, layer := range status.Layers {
+		view.Rows = append(view.Rows, []string{layer.Name, layer.CurrentBlob, yesNo(layer.Activated), yesNo(layer.Merged)})
+	}
+	return view
+}
+
+func printSummary(status Status) {
+	fmt.Printf("Store: %s (layered: %s)\n", status.Store.State, yesNo(status.Store.Layered))
+	fmt.Printf("PATH overlay: %s (mounted: %s)\n", status.PathOverlay.Path, yesNo(status.PathOverlay.Mounted))
+	if status.Healthy {
+		fmt.Println("Healthy: yes")
+		return
+	}
+
+	var problems []string
+	for _, drift := range status.Drift {
+		problems = append(problems, "    "+driftString(drift))
+	}
+	fmt.Printf("Healthy: no\n%s\n", strings.Join(problems, "\n"))
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
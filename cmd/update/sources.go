@@ -0,0 +1,195 @@
+package update
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/fileio"
+	"github.com/ublue-os/bext/pkg/oci"
+	"github.com/ublue-os/bext/pkg/structures"
+)
+
+const repositoryIndexName = "index.json"
+
+// index.json of a repository
+type RepositoryIndex struct {
+	Layers map[string]RepositoryLayer `json:"layers"`
+}
+
+type RepositoryLayer struct {
+	// md5 of the blob, the name it is cached under
+	Hash string `json:"hash"`
+	// Relative to the repository, or an absolute URL
+	Path string `json:"path"`
+	// Deltas to this blob (see "bext layer delta"), keyed by the md5 of their base, with paths like Path
+	Deltas map[string]string `json:"deltas,omitempty"`
+}
+
+// What a source offers for a layer
+type candidate struct {
+	// Identifies the offer so the same one is not downloaded twice, empty when every check has to fetch
+	revision string
+	// md5 of the blob when the source knows it before fetching
+	hash string
+	// Writes the blob to a path
+	fetch func(dest string) error
+	// Write a delta to the blob to a path, keyed by the md5 of their base
+	deltas map[string]func(dest string) error
+}
+
+func checkSource(layer string, source *internal.UpdateSource) (*candidate, error) {
+	switch source.Type {
+	case internal.SourceRepository:
+		return checkRepository(layer, source.Location)
+	case internal.SourceOCI:
+		return checkOCI(layer, source.Location)
+	case internal.SourceConfig:
+		return checkConfig(source.Location)
+	}
+	return nil, &internal.InvalidSourceError{Source: source.String()}
+}
+
+func isURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+func checkRepository(layer string, location string) (*candidate, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if isURL(location) {
+		data, err = structures.FetchConfig(strings.TrimSuffix(location, "/") + "/" + repositoryIndexName)
+	} else {
+		data, err = os.ReadFile(filepath.Join(location, repositoryIndexName))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	index := RepositoryIndex{}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid repository index %s: %w", location, err)
+	}
+	entry, exists := index.Layers[layer]
+	if !exists || entry.Path == "" {
+		return nil, fmt.Errorf("repository %s does not provide layer %s", location, layer)
+	}
+
+	get := func(entry_path string) func(dest string) error {
+		return func(dest string) error {
+			if !isURL(location) {
+				_, err := fileio.FileCopy(filepath.Join(location, entry_path), dest)
+				return err
+			}
+			base, err := url.Parse(strings.TrimSuffix(location, "/") + "/")
+			if err != nil {
+				return err
+			}
+			target, err := base.Parse(entry_path)
+			if err != nil {
+				return err
+			}
+			return download(target.String(), dest)
+		}
+	}
+
+	offer := &candidate{revision: entry.Hash, hash: entry.Hash, fetch: get(entry.Path), deltas: map[string]func(dest string) error{}}
+	for base, delta_path := range entry.Deltas {
+		offer.deltas[base] = get(delta_path)
+	}
+	return offer, nil
+}
+
+func download(target string, dest string) error {
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, ResponseHeaderTimeout: 30 * time.Second}}
+	response, err := client.Get(target)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: %s", target, response.Status)
+	}
+	return writeBlob(response.Body, dest)
+}
+
+func writeBlob(reader io.Reader, dest string) error {
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, reader); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// The image is the layer titled LAYER.sysext.raw, or the only layer of the artifact
+func checkOCI(layer string, location string) (*candidate, error) {
+	ref, err := oci.ParseReference(location)
+	if err != nil {
+		return nil, err
+	}
+	client := oci.NewClient()
+	manifest, err := client.Manifest(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	var image *oci.Descriptor
+	for i, descriptor := range manifest.Layers {
+		if descriptor.Annotations[oci.AnnotationTitle] == layer+internal.ValidSysextExtension {
+			image = &manifest.Layers[i]
+		}
+	}
+	if image == nil && len(manifest.Layers) == 1 {
+		image = &manifest.Layers[0]
+	}
+	if image == nil {
+		return nil, fmt.Errorf("%s has no layer titled %s", ref, layer+internal.ValidSysextExtension)
+	}
+
+	return &candidate{revision: image.Digest, fetch: func(dest string) error {
+		blob, err := client.Blob(ref, *image)
+		if err != nil {
+			return err
+		}
+		defer blob.Close()
+		return writeBlob(blob, dest)
+	}}, nil
+}
+
+// Builds run as a separate bext process, so their output and progress stay out of update's results
+func checkConfig(location string) (*candidate, error) {
+	if _, err := os.Stat(location); err != nil {
+		return nil, err
+	}
+	return &candidate{fetch: func(dest string) error {
+		self_path, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		build_args := []string{"layer", "build", location, "--output-path", dest, "--no-progress"}
+		if *internal.Config.UserMode {
+			build_args = append(build_args, "--user")
+		}
+		child := exec.Command(self_path, build_args...)
+		child.Stdout = os.Stderr
+		child.Stderr = os.Stderr
+		if err := child.Run(); err != nil {
+			return fmt.Errorf("rebuilding %s: %w", location, err)
+		}
+		return nil
+	}}, nil
+}
@@ -0,0 +1,377 @@
+package update
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/bext/internal"
+	"github.com/ublue-os/bext/pkg/delta"
+	"github.com/ublue-os/bext/pkg/filecomp"
+	"github.com/ublue-os/bext/pkg/output"
+)
+
+var UpdateCmd = &cobra.Command{
+	Use:   "update [LAYER...]",
+	Short: "Update layers from their sources",
+	Long: `Check the sources of the given layers (or of every layer that has one) for newer blobs, download and verify them into the cache, make them the current blob and refresh systemd-sysext.
+
+Sources are set with "bext layer source". Held layers are skipped unless forced.
+Repositories can offer deltas from older blobs, which are used when the current blob is their base. The whole blob is downloaded when there is none or it fails to apply.
+With --download-only new blobs are staged instead, "bext update --apply-staged" makes them current, which bext-staged.service does on the next boot before systemd-sysext merges the layers.
+The blob an update replaces is kept as a rollback point that "bext layer clean" never removes, "bext update --rollback" makes it current again.`,
+	RunE: updateCmd,
+}
+
+var (
+	fDownloadOnly *bool
+	fApplyStaged  *bool
+	fRollback     *bool
+	fNoRefresh    *bool
+	fForce        *bool
+)
+
+func init() {
+	UpdateCmd.Flags().StringVar(&internal.Config.CacheDir, "cache-root", internal.DefaultCacheDir, "root directory for the layer cache")
+	UpdateCmd.Flags().StringVar(&internal.Config.ExtensionsDir, "extensions-root", internal.DefaultExtensionsDir, "root directory for the systemd-sysext layers")
+	fDownloadOnly = UpdateCmd.Flags().Bool("download-only", false, "Download new blobs and stage them for the next boot instead of making them current")
+	fApplyStaged = UpdateCmd.Flags().Bool("apply-staged", false, "Make the staged blobs current instead of checking for updates")
+	fRollback = UpdateCmd.Flags().Bool("rollback", false, "Make the blobs replaced by the last update current again instead of checking for updates")
+	fNoRefresh = UpdateCmd.Flags().Bool("no-refresh", false, "Do not refresh systemd-sysext after moving current blobs")
+	fForce = UpdateCmd.Flags().Bool("force", false, "Update held layers too")
+}
+
+type Status string
+
+const (
+	StatusUpToDate   Status = "up-to-date"
+	StatusUpdated    Status = "updated"
+	StatusStaged     Status = "staged"
+	StatusApplied    Status = "applied"
+	StatusRolledBack Status = "rolled-back"
+	StatusHeld       Status = "held"
+	StatusFailed     Status = "failed"
+)
+
+type Result struct {
+	Layer  string `json:"layer"`
+	Source string `json:"source"`
+	// Current blob before updating
+	Previous string `json:"previous"`
+	// Blob the source offered, current now unless staged
+	Hash   string `json:"hash"`
+	Status Status `json:"status"`
+	// The blob was reconstructed from a delta against the previous one
+	Delta bool   `json:"delta,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+func updateCmd(cmd *cobra.Command, args []string) error {
+	if *fDownloadOnly && *fApplyStaged {
+		return internal.NewInvalidOptionError("--download-only", "--apply-staged")
+	}
+	if *fRollback && *fDownloadOnly {
+		return internal.NewInvalidOptionError("--rollback", "--download-only")
+	}
+	if *fRollback && *fApplyStaged {
+		return internal.NewInvalidOptionError("--rollback", "--apply-staged")
+	}
+	cache_dir, err := filepath.Abs(path.Clean(internal.Config.CacheDir))
+	if err != nil {
+		return err
+	}
+	state, err := internal.LoadCacheState()
+	if err != nil {
+		return err
+	}
+
+	layers := args
+	if len(layers) == 0 {
+		for name, layer_state := range state.Layers {
+			switch {
+			case *fApplyStaged:
+				if layer_state.Staged != "" {
+					layers = append(layers, name)
+				}
+			case *fRollback:
+				if layer_state.Rollback != "" {
+					layers = append(layers, name)
+				}
+			case layer_state.Source != nil:
+				layers = append(layers, name)
+			}
+		}
+		slices.Sort(layers)
+	}
+
+	results := []Result{}
+	var failed []string
+	moved := false
+	for _, layer := range layers {
+		result := Result{Layer: layer, Previous: currentHash(cache_dir, layer)}
+		if *fApplyStaged {
+			err = applyStaged(state, &result)
+		} else if *fRollback {
+			err = rollback(state, &result)
+		} else {
+			err = update(cache_dir, state, &result)
+		}
+		if err != nil {
+			slog.Warn("Failed updating "+layer, slog.String("error", err.Error()))
+			result.Status, result.Error = StatusFailed, err.Error()
+			failed = append(failed, layer)
+		}
+		moved = moved || result.Status == StatusUpdated || result.Status == StatusApplied || result.Status == StatusRolledBack
+		results = append(results, result)
+	}
+
+	// Sources remember what they offered even when other layers failed
+	if err := state.Save(); err != nil {
+		return err
+	}
+	if moved && !*fNoRefresh {
+		slog.Info("Refreshing system extensions")
+		if err := internal.RefreshExtensions(); err != nil {
+			return err
+		}
+	}
+
+	if err := output.Write(os.Stdout, internal.Config.OutputFormat, results, resultsView(results)); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return &internal.UpdateFailedError{Layers: failed}
+	}
+	return nil
+}
+
+func currentHash(cache_dir string, layer string) string {
+	current, err := filepath.EvalSymlinks(path.Join(cache_dir, layer, internal.CurrentBlobName))
+	if err != nil {
+		return ""
+	}
+	return path.Base(current)
+}
+
+func update(cache_dir string, state *internal.CacheState, result *Result) error {
+	layer_state, exists := state.Layers[result.Layer]
+	if !exists || layer_state.Source == nil {
+		return &internal.NoUpdateSourceError{Layer: result.Layer}
+	}
+	source := layer_state.Source
+	result.Source = source.String()
+	if state.IsHeld(result.Layer) && !*fForce {
+		result.Status = StatusHeld
+		return nil
+	}
+
+	slog.Info("Checking for updates", slog.String("layer", result.Layer), slog.String("source", result.Source))
+	offer, err := checkSource(result.Layer, source)
+	if err != nil {
+		return err
+	}
+
+	hash := offer.hash
+	if hash == "" && offer.revision != "" && offer.revision == source.Revision {
+		hash = source.Hash
+	}
+	if hash == "" || !blobExists(cache_dir, result.Layer, hash) {
+		if hash, err = fetch(cache_dir, result, offer); err != nil {
+			return err
+		}
+	}
+	source.Revision, source.Hash = offer.revision, hash
+	result.Hash = hash
+
+	if hash == result.Previous {
+		layer_state.Staged = ""
+		result.Status = StatusUpToDate
+		return nil
+	}
+	if *fDownloadOnly {
+		layer_state.Staged = hash
+		result.Status = StatusStaged
+		slog.Info("Staged update", slog.String("layer", result.Layer), slog.String("hash", hash))
+		return nil
+	}
+	if err := internal.SetCurrentBlob(result.Layer, hash, *fForce); err != nil {
+		return err
+	}
+	layer_state.Staged = ""
+	layer_state.Rollback = result.Previous
+	result.Status = StatusUpdated
+	slog.Info("Updated layer", slog.String("layer", result.Layer), slog.String("hash", hash))
+	return nil
+}
+
+func blobExists(cache_dir string, layer string, hash string) bool {
+	if _, err := os.Stat(path.Join(cache_dir, layer, hash)); err == nil {
+		return true
+	}
+	return internal.IsPacked(layer, hash)
+}
+
+// Downloads into the cache root first, so a blob never shows up in its layer before it was verified
+func fetch(cache_dir string, result *Result, offer *candidate) (string, error) {
+	layer := result.Layer
+	if err := os.MkdirAll(path.Join(cache_dir, layer), 0755); err != nil {
+		return "", err
+	}
+	partial, err := os.CreateTemp(cache_dir, ".update-*")
+	if err != nil {
+		return "", err
+	}
+	partial.Close()
+	defer os.Remove(partial.Name())
+
+	if fetch_delta, exists := offer.deltas[result.Previous]; exists && result.Previous != "" {
+		slog.Info("Downloading delta", slog.String("layer", layer), slog.String("base", result.Previous))
+		if err := applyDelta(cache_dir, layer, result.Previous, fetch_delta, partial.Name()); err != nil {
+			slog.Warn("Failed updating "+layer+" from a delta, downloading the whole blob", slog.String("error", err.Error()))
+		} else {
+			result.Delta = true
+		}
+	}
+	if !result.Delta {
+		slog.Info("Downloading update", slog.String("layer", layer))
+		if err := offer.fetch(partial.Name()); err != nil {
+			return "", err
+		}
+	}
+
+	blob, err := os.Open(partial.Name())
+	if err != nil {
+		return "", err
+	}
+	sum, err := filecomp.StreamChecksum(blob, md5.New())
+	blob.Close()
+	if err != nil {
+		return "", err
+	}
+	hash := hex.EncodeToString(sum)
+	if offer.hash != "" && offer.hash != hash {
+		return "", &internal.CorruptedBlobsError{Blobs: []string{layer + "@" + offer.hash}}
+	}
+
+	if err := os.Chmod(partial.Name(), 0644); err != nil {
+		return "", err
+	}
+	return hash, os.Rename(partial.Name(), path.Join(cache_dir, layer, hash))
+}
+
+// Reconstructs a blob into dest from a delta against a base blob. Current blobs are never packed, so the base is whole.
+func applyDelta(cache_dir string, layer string, base string, fetch_delta func(dest string) error, dest string) error {
+	delta_file, err := os.CreateTemp(cache_dir, ".update-*")
+	if err != nil {
+		return err
+	}
+	delta_file.Close()
+	defer os.Remove(delta_file.Name())
+	if err := fetch_delta(delta_file.Name()); err != nil {
+		return err
+	}
+
+	delta_file, err = os.Open(delta_file.Name())
+	if err != nil {
+		return err
+	}
+	defer delta_file.Close()
+	reader := bufio.NewReader(delta_file)
+	header, err := delta.ReadHeader(reader)
+	if err != nil {
+		return err
+	}
+	if header.Layer != layer || header.Base != base {
+		return &delta.InvalidDeltaError{Reason: "made for " + header.Layer + "@" + header.Base}
+	}
+
+	base_file, err := os.Open(path.Join(cache_dir, layer, base))
+	if err != nil {
+		return err
+	}
+	defer base_file.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	writer := bufio.NewWriter(out)
+	if err := delta.Apply(reader, header, base_file, writer); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func applyStaged(state *internal.CacheState, result *Result) error {
+	layer_state, exists := state.Layers[result.Layer]
+	if !exists || layer_state.Staged == "" {
+		return errors.New("no staged update for layer " + result.Layer)
+	}
+	if layer_state.Source != nil {
+		result.Source = layer_state.Source.String()
+	}
+	result.Hash = layer_state.Staged
+	if err := internal.SetCurrentBlob(result.Layer, layer_state.Staged, *fForce); err != nil {
+		return err
+	}
+	layer_state.Staged = ""
+	layer_state.Rollback = result.Previous
+	result.Status = StatusApplied
+	return nil
+}
+
+// Swaps the current blob with the rollback point, so rolling back again undoes it
+func rollback(state *internal.CacheState, result *Result) error {
+	layer_state, exists := state.Layers[result.Layer]
+	if !exists || layer_state.Rollback == "" {
+		return errors.New("no rollback point for layer " + result.Layer)
+	}
+	if layer_state.Source != nil {
+		result.Source = layer_state.Source.String()
+	}
+	result.Hash = layer_state.Rollback
+	if err := internal.SetCurrentBlob(result.Layer, layer_state.Rollback, *fForce); err != nil {
+		return err
+	}
+	layer_state.Rollback = result.Previous
+	result.Status = StatusRolledBack
+	slog.Info("Rolled back layer", slog.String("layer", result.Layer), slog.String("hash", result.Hash))
+	return nil
+}
+
+func resultsView(results []Result) output.Table {
+	if internal.Config.OutputFormat == output.FormatTSV {
+		view := output.Table{Header: []string{"layer", "source", "previous", "hash", "status", "delta", "error"}}
+		for _, result := range results {
+			view.Rows = append(view.Rows, []string{result.Layer, result.Source, result.Previous, result.Hash, string(result.Status), strconv.FormatBool(result.Delta), result.Error})
+		}
+		return view
+	}
+
+	view := output.Table{Title: "Update", Header: []string{"Layer", "Source", "Blob", "Status"}}
+	for _, result := range results {
+		blob := result.Hash
+		if result.Previous != "" && result.Hash != "" && result.Previous != result.Hash {
+			blob = result.Previous + " -> " + result.Hash
+		}
+		status := string(result.Status)
+		if result.Error != "" {
+			status += ": " + result.Error
+		} else if result.Delta {
+			status += " (delta)"
+		}
+		view.Rows = append(view.Rows, []string{result.Layer, result.Source, blob, status})
+	}
+	return view
+}
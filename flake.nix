@@ -71,6 +71,19 @@
               all_deps =
                 builtins.map (package: pkgs.${package}) config.packages;
 
+              # Locked inputs of this flake, recorded so the layer can be rebuilt from the same nixpkgs
+              flake-metadata-envvar = "BEXT_FLAKE_METADATA_FILE";
+              flake-metadata-file = builtins.getEnv flake-metadata-envvar;
+              metadata =
+                config
+                // lib.optionalAttrs (flake-metadata-file != "") {
+                  flake = let
+                    flake-metadata = pkgs.lib.trivial.importJSON (/. + flake-metadata-file);
+                  in {
+                    inherit (flake-metadata) url locks;
+                  };
+                };
+
               generate-recipe-derivation = pkgs.symlinkJoin {
                 name = "derivation-from-recipe";
                 paths = all_deps;
@@ -101,14 +114,15 @@
                     echo "ID=${config.os}"
                     echo "EXTENSION_RELOAD_MANAGER=1"
                     if [ "${config.os}" != "_any" ]; then
-                      echo "SYSEXT_LEVEL=1.0"
+                      echo "SYSEXT_LEVEL=${config.sysext_level or "1.0"}"
                     fi
+                    ${lib.optionalString (config ? version_id) ''echo "VERSION_ID=${config.version_id}"''}
                     if [ "${config.arch}" != "" ]; then
                       echo "ARCHITECTURE=${config.arch}"
                     fi
                   } > "usr/lib/extension-release.d/extension-release.${config.sysext-name}.sysext" &
 
-                  echo '${builtins.toJSON config}' > usr/extensions.d/${config.sysext-name}/metadata.json &
+                  cp ${pkgs.writeText "metadata.json" (builtins.toJSON metadata)} usr/extensions.d/${config.sysext-name}/metadata.json &
 
                   # Upstream Issue: https://github.com/NixOS/nixpkgs/issues/252620
                   #{
@@ -131,6 +145,16 @@
                     rm -r usr/bin
                   }
 
+                  ${lib.concatMapStrings (file: ''
+                    mkdir -p "$(dirname ${lib.escapeShellArg ".${file.path}"})"
+                    cp ${pkgs.writeText "bext-extra-file" file.content} ${lib.escapeShellArg ".${file.path}"}
+                  '') (config.files or [])}
+
+                  ${lib.optionalString ((config.environment or {}) != {}) ''
+                    mkdir -p usr/lib/environment.d
+                    cp ${pkgs.writeText "bext-environment" (lib.concatStrings (lib.mapAttrsToList (name: value: "${name}=${value}\n") config.environment))} usr/lib/environment.d/60-bext-${config.sysext-name}.conf
+                  ''}
+
                   shopt -s extglob
                   rm -- !(usr)
                   ${pkgs.squashfsTools}/bin/mksquashfs \
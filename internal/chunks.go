@@ -0,0 +1,208 @@
+package internal
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ublue-os/bext/pkg/chunkstore"
+)
+
+// Packed blobs live next to the cache, like quarantined ones, so they are never mistaken for layers
+func ChunkStoreDir() (string, error) {
+	cache_dir, err := filepath.Abs(filepath.Clean(Config.CacheDir))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(cache_dir), "chunks"), nil
+}
+
+func ChunkStore() (*chunkstore.Store, error) {
+	store_dir, err := ChunkStoreDir()
+	if err != nil {
+		return nil, err
+	}
+	return chunkstore.New(store_dir), nil
+}
+
+func PackedBlobName(layer string, hash string) string {
+	return layer + "/" + hash
+}
+
+func IsPacked(layer string, hash string) bool {
+	store, err := ChunkStore()
+	if err != nil {
+		return false
+	}
+	return store.Has(PackedBlobName(layer, hash))
+}
+
+// Moves a blob into the chunk store, checking it against its digest first so corruption is never packed.
+// The current blob is what gets activated, so it always stays whole.
+func PackBlob(layer string, hash string) (chunkstore.Index, error) {
+	blob_path, err := filepath.Abs(filepath.Join(Config.CacheDir, layer, hash))
+	if err != nil {
+		return chunkstore.Index{}, err
+	}
+	if current, err := filepath.EvalSymlinks(filepath.Join(filepath.Dir(blob_path), CurrentBlobName)); err == nil && filepath.Base(current) == hash {
+		return chunkstore.Index{}, &BlobInUseError{Layer: layer, Hash: hash}
+	}
+	store, err := ChunkStore()
+	if err != nil {
+		return chunkstore.Index{}, err
+	}
+
+	blob, err := os.Open(blob_path)
+	if errors.Is(err, os.ErrNotExist) {
+		return chunkstore.Index{}, &BlobNotFoundError{Layer: layer, Hash: hash}
+	} else if err != nil {
+		return chunkstore.Index{}, err
+	}
+	defer blob.Close()
+	info, err := blob.Stat()
+	if err != nil {
+		return chunkstore.Index{}, err
+	}
+
+	name := PackedBlobName(layer, hash)
+	digest := md5.New()
+	index, err := store.Put(name, io.TeeReader(blob, digest))
+	if err != nil {
+		return chunkstore.Index{}, err
+	}
+	if actual := hex.EncodeToString(digest.Sum(nil)); isDigest(hash) && actual != hash {
+		store.Remove(name)
+		return chunkstore.Index{}, &CorruptedBlobsError{Blobs: []string{layer + "@" + hash}}
+	}
+	// Retention goes by when a blob was added, not when it was packed
+	if err := store.Chtimes(name, info.ModTime()); err != nil {
+		return chunkstore.Index{}, err
+	}
+	return index, os.Remove(blob_path)
+}
+
+// Reassembles a packed blob into the cache and removes it from the chunk store, its chunks stay until they are pruned
+func UnpackBlob(layer string, hash string) error {
+	store, err := ChunkStore()
+	if err != nil {
+		return err
+	}
+	name := PackedBlobName(layer, hash)
+	if !store.Has(name) {
+		return &BlobNotFoundError{Layer: layer, Hash: hash}
+	}
+
+	layer_dir := filepath.Join(Config.CacheDir, layer)
+	if err := os.MkdirAll(layer_dir, 0755); err != nil {
+		return err
+	}
+	partial, err := os.CreateTemp(Config.CacheDir, ".unpack-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(partial.Name())
+
+	digest := md5.New()
+	if err := store.Get(name, io.MultiWriter(partial, digest)); err != nil {
+		partial.Close()
+		return err
+	}
+	if err := partial.Close(); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(digest.Sum(nil)); isDigest(hash) && actual != hash {
+		return &CorruptedBlobsError{Blobs: []string{layer + "@" + hash}}
+	}
+	if err := os.Chmod(partial.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(partial.Name(), filepath.Join(layer_dir, hash)); err != nil {
+		return err
+	}
+	return store.Remove(name)
+}
+
+type PackedBlob struct {
+	Layer   string
+	Hash    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Every packed blob, its modification time is the one of the blob before it was packed
+func PackedBlobs() ([]PackedBlob, error) {
+	store, err := ChunkStore()
+	if err != nil {
+		return nil, err
+	}
+	names, err := store.Names()
+	if err != nil {
+		return nil, err
+	}
+	var blobs []PackedBlob
+	for _, name := range names {
+		layer, hash, found := strings.Cut(name, "/")
+		if !found {
+			continue
+		}
+		index, err := store.Index(name)
+		if err != nil {
+			return nil, err
+		}
+		info, err := store.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, PackedBlob{Layer: layer, Hash: hash, Size: index.Size, ModTime: info.ModTime()})
+	}
+	return blobs, nil
+}
+
+// Removes a packed blob, its chunks stay until they are pruned
+func RemovePackedBlob(layer string, hash string) error {
+	store, err := ChunkStore()
+	if err != nil {
+		return err
+	}
+	return store.Remove(PackedBlobName(layer, hash))
+}
+
+// Blobs added by bext are named after their md5, anything else can not be checked
+func isDigest(hash string) bool {
+	decoded, err := hex.DecodeString(hash)
+	return err == nil && len(decoded) == md5.Size
+}
+
+// Opens a blob whether it is whole or packed, packed ones are reassembled while they are read
+func OpenBlob(layer string, hash string) (io.ReadCloser, int64, error) {
+	if blob, err := os.Open(filepath.Join(Config.CacheDir, layer, hash)); err == nil {
+		info, err := blob.Stat()
+		if err != nil {
+			blob.Close()
+			return nil, 0, err
+		}
+		return blob, info.Size(), nil
+	}
+
+	store, err := ChunkStore()
+	if err != nil {
+		return nil, 0, err
+	}
+	name := PackedBlobName(layer, hash)
+	index, err := store.Index(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, &BlobNotFoundError{Layer: layer, Hash: hash}
+	} else if err != nil {
+		return nil, 0, err
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(store.Get(name, writer))
+	}()
+	return reader, index.Size, nil
+}
@@ -1,8 +1,11 @@
 package internal
 
 import (
+	"encoding/json"
 	"os"
 	"reflect"
+
+	"github.com/ublue-os/bext/pkg/output"
 )
 
 type TargetLayerInfo struct {
@@ -13,11 +16,40 @@ type TargetLayerInfo struct {
 	UUID      []byte
 }
 
+// Configurations without a version are treated as version 1
+const LayerConfigurationVersion = 1
+
 type LayerConfiguration struct {
-	Name     string   `json:"sysext-name"`
-	Packages []string `json:"packages"`
-	Arch     string   `json:"arch"`
-	Os       string   `json:"os"`
+	Schema      string            `json:"$schema,omitempty" description:"JSON Schema of the configuration, only used by editors"`
+	Version     int               `json:"version,omitempty" minimum:"1" maximum:"1" description:"Version of the configuration format"`
+	Name        string            `json:"sysext-name" required:"true" pattern:"^[A-Za-z0-9_-]+$" description:"Name of the layer, also used for the image file"`
+	Description string            `json:"description,omitempty" description:"What the layer is for"`
+	Packages    []string          `json:"packages" required:"true" minItems:"1" pattern:"^[A-Za-z0-9_+-]+$" description:"Top-level attributes of nixpkgs that are installed in the layer"`
+	Arch        string            `json:"arch" required:"true" enum:"|_any|x86|x86-64|arm|arm64|riscv32|riscv64|ppc64|ppc64-le|s390x|loongarch64" description:"ARCHITECTURE of the extension-release file, empty for any architecture"`
+	Os          string            `json:"os" required:"true" pattern:"^(_any|[a-z0-9._-]+)$" description:"ID of the os-release file the layer is built for, _any for every system"`
+	SysextLevel string            `json:"sysext_level,omitempty" pattern:"^[A-Za-z0-9._-]+$" description:"SYSEXT_LEVEL of the extension-release file, 1.0 when not set"`
+	VersionID   string            `json:"version_id,omitempty" pattern:"^[A-Za-z0-9._-]+$" description:"VERSION_ID of the extension-release file, only checked when set"`
+	Nixpkgs     string            `json:"nixpkgs,omitempty" pattern:"^[A-Za-z0-9._/:?=&+-]+$" description:"Revision or branch of nixpkgs the packages come from, or a flake reference used in its place"`
+	Files       []LayerFile       `json:"files,omitempty" description:"Extra files written into the layer"`
+	Environment map[string]string `json:"environment,omitempty" keyPattern:"^[A-Za-z_][A-Za-z0-9_]*$" pattern:"^[^\\n\\r]*$" description:"Variables written to an environment.d file of the layer, values can not span lines"`
+	Depends     []string          `json:"depends,omitempty" pattern:"^[A-Za-z0-9_-]+$" description:"Layers activated together with this one, they have to be in the cache"`
+}
+
+// metadata.json of built layers, the configuration and the flake inputs it was built with
+type LayerMetadata struct {
+	LayerConfiguration
+	Flake *FlakeLock `json:"flake,omitempty"`
+}
+
+type FlakeLock struct {
+	// Locked reference of the recipe flake
+	URL   string          `json:"url"`
+	Locks json.RawMessage `json:"locks"`
+}
+
+type LayerFile struct {
+	Path    string `json:"path" required:"true" pattern:"^/usr(/([.]?[A-Za-z0-9_+@,=:-][A-Za-z0-9._+@,=:-]*|[.][.][A-Za-z0-9._+@,=:-]+))+$" description:"Absolute path of the file, has to be under /usr. Letters, digits and ._+@,=:- only, without . or .. components"`
+	Content string `json:"content" required:"true" description:"Content of the file"`
 }
 
 func GetFieldFromStruct(structure interface{}, field string) reflect.Value {
@@ -31,12 +63,18 @@ type config struct {
 	StoreDir        string
 	UnmountFlag     *bool
 	NoProgress      *bool
+	UserMode        *bool
+	OutputFormat    output.Format
+	// Every setting and where its value came from, filled before any command runs
+	ResolvedSettings []ResolvedSetting
 }
 
 const (
 	CurrentBlobName      = "current_blob"
 	ValidSysextExtension = ".sysext.raw"
 	MetadataFileName     = "metadata.json"
+	StateFileName        = "state.json"
+	LockFileName         = ".lock"
 )
 
 var Config = &config{}
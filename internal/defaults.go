@@ -0,0 +1,12 @@
+package internal
+
+// Built-in defaults, settings files and BEXT_* variables can override them
+const (
+	DefaultCacheDir        = "/var/cache/extensions/blobs"
+	DefaultExtensionsDir   = "/var/lib/extensions"
+	DefaultExtensionsMount = "/usr/extensions.d"
+	DefaultPathMount       = "/tmp/extensions.d/bin"
+	DefaultStoreBindmount  = "/tmp/nix-store-bindmount"
+	DefaultBuildImage      = "docker.io/nixos/nix"
+	DefaultRecipeFlake     = "github:ublue-os/bext"
+)
@@ -3,6 +3,8 @@ package internal
 import (
 	"fmt"
 	"strings"
+
+	"github.com/ublue-os/bext/pkg/jsonschema"
 )
 
 // Mostly just to warn the user that a positional argument is missing
@@ -45,3 +47,240 @@ func (e *InvalidOptionError) Error() string {
 func NewInvalidOptionError(message ...string) error {
 	return &InvalidOptionError{Message: message}
 }
+
+type LayerNotFoundError struct {
+	Layer string
+}
+
+func (e *LayerNotFoundError) Error() string {
+	return fmt.Sprintf("Layer not found: %s", e.Layer)
+}
+
+type InvalidLayerNameError struct {
+	Layer string
+}
+
+func (e *InvalidLayerNameError) Error() string {
+	return fmt.Sprintf("Invalid layer name: %s", e.Layer)
+}
+
+type BlobNotFoundError struct {
+	Layer string
+	Hash  string
+}
+
+func (e *BlobNotFoundError) Error() string {
+	return fmt.Sprintf("Blob not found: %s@%s", e.Layer, e.Hash)
+}
+
+// The system works, but not as bext left it
+type UnhealthyError struct {
+	Problems []string
+}
+
+func (e *UnhealthyError) Error() string {
+	if len(e.Problems) == 1 {
+		return fmt.Sprintf("System is unhealthy: %s", e.Problems[0])
+	}
+	return fmt.Sprintf("System is unhealthy, %d problems found", len(e.Problems))
+}
+
+type CorruptedBlobsError struct {
+	Blobs []string
+}
+
+func (e *CorruptedBlobsError) Error() string {
+	return fmt.Sprintf("Corrupted blobs: %s", strings.Join(e.Blobs, ", "))
+}
+
+type UnreadableBlobsError struct {
+	Blobs []string
+}
+
+func (e *UnreadableBlobsError) Error() string {
+	return fmt.Sprintf("Blobs that could not be read: %s", strings.Join(e.Blobs, ", "))
+}
+
+type UnknownSettingError struct {
+	Key string
+}
+
+func (e *UnknownSettingError) Error() string {
+	return fmt.Sprintf("Unknown setting: %s", e.Key)
+}
+
+type SettingsFileError struct {
+	Path string
+	Err  error
+}
+
+func (e *SettingsFileError) Error() string {
+	return fmt.Sprintf("Invalid settings in %s: %s", e.Path, e.Err)
+}
+
+func (e *SettingsFileError) Unwrap() error {
+	return e.Err
+}
+
+type LayerConfigurationError struct {
+	Path   string
+	Errors []jsonschema.FieldError
+}
+
+func (e *LayerConfigurationError) Error() string {
+	if len(e.Errors) == 1 {
+		return fmt.Sprintf("Invalid layer configuration %s: %s", e.Path, e.Errors[0])
+	}
+	return fmt.Sprintf("Invalid layer configuration %s, %d problems found", e.Path, len(e.Errors))
+}
+
+type BuildContainerError struct {
+	ContainerID string
+	ExitCode    int
+}
+
+func (e *BuildContainerError) Error() string {
+	return fmt.Sprintf("Build container %.12s exited with status %d", e.ContainerID, e.ExitCode)
+}
+
+type BuildFailedError struct {
+	Layers []string
+}
+
+func (e *BuildFailedError) Error() string {
+	return fmt.Sprintf("Failed building: %s", strings.Join(e.Layers, ", "))
+}
+
+type NotReproducibleError struct {
+	Layers []string
+}
+
+func (e *NotReproducibleError) Error() string {
+	return fmt.Sprintf("Layers are not reproducible: %s", strings.Join(e.Layers, ", "))
+}
+
+type DuplicateOutputError struct {
+	Path string
+}
+
+func (e *DuplicateOutputError) Error() string {
+	return fmt.Sprintf("More than one layer would be written to %s", e.Path)
+}
+
+type BuildCacheNotFoundError struct {
+	Name string
+}
+
+func (e *BuildCacheNotFoundError) Error() string {
+	return fmt.Sprintf("Build cache not found: %s", e.Name)
+}
+
+type BuildCachePruneError struct {
+	Caches []string
+}
+
+func (e *BuildCachePruneError) Error() string {
+	return fmt.Sprintf("Failed pruning build caches: %s", strings.Join(e.Caches, ", "))
+}
+
+type RecipeNotFoundError struct {
+	Path string
+}
+
+func (e *RecipeNotFoundError) Error() string {
+	return fmt.Sprintf("No flake.nix found in recipe flake %s", e.Path)
+}
+
+type LayerHeldError struct {
+	Layer string
+}
+
+func (e *LayerHeldError) Error() string {
+	return fmt.Sprintf("Layer %s is held, its current blob was not moved (use --force)", e.Layer)
+}
+
+type DeltaFailedError struct {
+	Deltas []string
+}
+
+func (e *DeltaFailedError) Error() string {
+	return fmt.Sprintf("Failed applying deltas: %s", strings.Join(e.Deltas, ", "))
+}
+
+type BlobInUseError struct {
+	Layer string
+	Hash  string
+}
+
+func (e *BlobInUseError) Error() string {
+	return fmt.Sprintf("Blob %s@%s is the current blob of its layer", e.Layer, e.Hash)
+}
+
+type PackFailedError struct {
+	Blobs []string
+}
+
+func (e *PackFailedError) Error() string {
+	return fmt.Sprintf("Failed packing blobs: %s", strings.Join(e.Blobs, ", "))
+}
+
+type InvalidSourceError struct {
+	Source string
+}
+
+func (e *InvalidSourceError) Error() string {
+	return fmt.Sprintf("Invalid update source %q, expected one of %s followed by a colon and its location", e.Source, strings.Join(ValidSourceTypes, ", "))
+}
+
+type NoUpdateSourceError struct {
+	Layer string
+}
+
+func (e *NoUpdateSourceError) Error() string {
+	return fmt.Sprintf("Layer %s has no update source (see bext layer source)", e.Layer)
+}
+
+type NoRetentionPolicyError struct {
+	Layer string
+}
+
+func (e *NoRetentionPolicyError) Error() string {
+	return fmt.Sprintf("Layer %s has no retention policy (see bext layer retention)", e.Layer)
+}
+
+type UpdateFailedError struct {
+	Layers []string
+}
+
+func (e *UpdateFailedError) Error() string {
+	return fmt.Sprintf("Failed updating: %s", strings.Join(e.Layers, ", "))
+}
+
+type PendingFailedError struct {
+	Layers []string
+}
+
+func (e *PendingFailedError) Error() string {
+	return fmt.Sprintf("Failed applying pending changes: %s", strings.Join(e.Layers, ", "))
+}
+
+type CacheLockedError struct {
+	Path string
+	PID  int
+}
+
+func (e *CacheLockedError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("Cache is locked by another process (%s), retry without --no-wait to wait for it", e.Path)
+	}
+	return fmt.Sprintf("Cache is locked by process %d (%s), retry without --no-wait to wait for it", e.PID, e.Path)
+}
+
+type MissingDependencyError struct {
+	Layer      string
+	Dependency string
+}
+
+func (e *MissingDependencyError) Error() string {
+	return fmt.Sprintf("Layer %s depends on %s, which is not in the cache", e.Layer, e.Dependency)
+}
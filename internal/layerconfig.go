@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/ublue-os/bext/pkg/jsonschema"
+	"github.com/ublue-os/bext/pkg/structures"
+)
+
+const LayerConfigurationSchemaID = "https://github.com/ublue-os/bext/raw/main/docs/layer-config.schema.json"
+
+// Paths the image already uses for its own files
+var reservedLayerPaths = []string{"/usr/store", "/usr/extensions.d", "/usr/lib/extension-release.d", "/usr/bin"}
+
+func LayerConfigurationSchema() *jsonschema.Schema {
+	return jsonschema.Generate(LayerConfiguration{}, LayerConfigurationSchemaID, "bext layer configuration")
+}
+
+// Checks a configuration against the schema and for problems the schema can not express, returning every one found
+func ValidateLayerConfiguration(data []byte) []jsonschema.FieldError {
+	var document any
+	if err := json.Unmarshal(data, &document); err != nil {
+		return []jsonschema.FieldError{{Path: "(document)", Message: err.Error()}}
+	}
+	errors := LayerConfigurationSchema().Validate(document)
+	if len(errors) > 0 {
+		return errors
+	}
+
+	configuration := &LayerConfiguration{}
+	if err := json.Unmarshal(data, configuration); err != nil {
+		return []jsonschema.FieldError{{Path: "(document)", Message: err.Error()}}
+	}
+
+	var seen_paths []string
+	for i, file := range configuration.Files {
+		field_path := fmt.Sprintf("files[%d].path", i)
+		clean_path := path.Clean(file.Path)
+		switch {
+		case clean_path != file.Path:
+			errors = append(errors, jsonschema.FieldError{Path: field_path, Message: fmt.Sprintf("must be a clean path, like %q", clean_path)})
+		case reservedLayerPath(clean_path) != "":
+			errors = append(errors, jsonschema.FieldError{Path: field_path, Message: fmt.Sprintf("must not be under %s, bext writes there", reservedLayerPath(clean_path))})
+		case slices.Contains(seen_paths, clean_path):
+			errors = append(errors, jsonschema.FieldError{Path: field_path, Message: fmt.Sprintf("%s is already written by another file", clean_path)})
+		}
+		seen_paths = append(seen_paths, clean_path)
+	}
+
+	for i, dependency := range configuration.Depends {
+		field_path := fmt.Sprintf("depends[%d]", i)
+		if dependency == configuration.Name {
+			errors = append(errors, jsonschema.FieldError{Path: field_path, Message: "a layer can not depend on itself"})
+		} else if slices.Contains(configuration.Depends[:i], dependency) {
+			errors = append(errors, jsonschema.FieldError{Path: field_path, Message: fmt.Sprintf("%s is listed more than once", dependency)})
+		}
+	}
+
+	return errors
+}
+
+func reservedLayerPath(file_path string) string {
+	for _, reserved := range reservedLayerPaths {
+		if file_path == reserved || strings.HasPrefix(file_path, reserved+"/") {
+			return reserved
+		}
+	}
+	return ""
+}
+
+// Reads a configuration in any format as json, without validating it
+func ReadLayerConfiguration(config_path string) ([]byte, error) {
+	data, err := os.ReadFile(config_path)
+	if err != nil {
+		return nil, err
+	}
+	return structures.ToJson(data, structures.DetectFormat(config_path, data))
+}
+
+// Reads and validates a configuration, a LayerConfigurationError lists every problem found
+func LoadLayerConfiguration(config_path string) (*LayerConfiguration, error) {
+	data, err := ReadLayerConfiguration(config_path)
+	if err != nil {
+		return nil, err
+	}
+	if errors := ValidateLayerConfiguration(data); len(errors) > 0 {
+		return nil, &LayerConfigurationError{Path: config_path, Errors: errors}
+	}
+
+	configuration := &LayerConfiguration{}
+	if err := json.Unmarshal(data, configuration); err != nil {
+		return nil, err
+	}
+	if configuration.Version == 0 {
+		configuration.Version = LayerConfigurationVersion
+	}
+	return configuration, nil
+}
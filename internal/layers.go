@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/ublue-os/bext/pkg/squashfs"
+)
+
+// Lists every layer that has been activated (symlinked) in the extensions directory
+func ActiveLayers() ([]string, error) {
+	extensions_dir, err := filepath.Abs(filepath.Clean(Config.ExtensionsDir))
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(extensions_dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	var layers []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ValidSysextExtension) {
+			continue
+		}
+		layers = append(layers, strings.TrimSuffix(entry.Name(), ValidSysextExtension))
+	}
+	slices.Sort(layers)
+
+	return layers, nil
+}
+
+// Resolves a layer name to its image, preferring the activated one over the cache's current blob
+func LayerImagePath(layer string) (string, error) {
+	candidates := []string{
+		filepath.Join(Config.ExtensionsDir, layer+ValidSysextExtension),
+		filepath.Join(Config.CacheDir, layer, CurrentBlobName),
+	}
+
+	for _, candidate := range candidates {
+		image_path, err := filepath.EvalSymlinks(candidate)
+		if err == nil {
+			return filepath.Abs(image_path)
+		}
+	}
+	return "", &LayerNotFoundError{Layer: layer}
+}
+
+// Layer names end up in paths, so they must not leave the cache
+func ValidLayerName(name string) bool {
+	return name != "" && name != "." && name != ".." && filepath.Base(name) == name
+}
+
+// Splits LAYER@HASH references, hash is empty when not specified
+func ParseLayerRef(ref string) (layer string, hash string) {
+	layer, hash, _ = strings.Cut(ref, "@")
+	return layer, hash
+}
+
+// Corrupted blobs are moved next to the cache instead of inside of it, so they are never mistaken for layers
+func QuarantineDir() (string, error) {
+	cache_dir, err := filepath.Abs(filepath.Clean(Config.CacheDir))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(cache_dir), "quarantine"), nil
+}
+
+// Points current_blob of a layer to one of its blobs, unpacking it when needed. A held layer keeps its current blob unless forced.
+func SetCurrentBlob(layer string, hash string, force bool) error {
+	state, err := LoadCacheState()
+	if err != nil {
+		return err
+	}
+	blob_path, err := filepath.Abs(filepath.Join(Config.CacheDir, layer, hash))
+	if err != nil {
+		return err
+	}
+	current_path := filepath.Join(filepath.Dir(blob_path), CurrentBlobName)
+	_, current_err := os.Lstat(current_path)
+	if current_err != nil && !errors.Is(current_err, os.ErrNotExist) {
+		return current_err
+	}
+	if current_err == nil && state.IsHeld(layer) && !force {
+		return &LayerHeldError{Layer: layer}
+	}
+
+	if _, err := os.Stat(blob_path); err != nil {
+		if !IsPacked(layer, hash) {
+			return &BlobNotFoundError{Layer: layer, Hash: hash}
+		}
+		if err := UnpackBlob(layer, hash); err != nil {
+			return err
+		}
+	}
+
+	if current_err == nil {
+		if err := os.Remove(current_path); err != nil {
+			return err
+		}
+	}
+	return os.Symlink(blob_path, current_path)
+}
+
+// Links the current blob of a layer into the extensions directory, replacing an existing link
+func ActivateLayer(layer string) error {
+	current_path, err := filepath.Abs(filepath.Join(Config.CacheDir, layer, CurrentBlobName))
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(current_path); err != nil {
+		return &LayerNotFoundError{Layer: layer}
+	}
+	if err := os.MkdirAll(Config.ExtensionsDir, 0755); err != nil {
+		return err
+	}
+	link_path := filepath.Join(Config.ExtensionsDir, layer+ValidSysextExtension)
+	if err := os.Remove(link_path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.Symlink(current_path, link_path)
+}
+
+// Removes the link of a layer from the extensions directory, layers that are not activated are left as they are
+func DeactivateLayer(layer string) error {
+	err := os.Remove(filepath.Join(Config.ExtensionsDir, layer+ValidSysextExtension))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Makes systemd-sysext pick up layers whose current blob moved
+func RefreshExtensions() error {
+	out, err := exec.Command("systemd-sysext", "refresh").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemd-sysext refresh: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Images built by bext have a single metadata.json, whatever their layer is called. Nil when the image has none.
+func ImageMetadata(image fs.FS) (*LayerMetadata, error) {
+	metadata_paths, err := fs.Glob(image, path.Join(strings.TrimPrefix(DefaultExtensionsMount, "/"), "*", MetadataFileName))
+	if err != nil || len(metadata_paths) == 0 {
+		return nil, err
+	}
+	data, err := fs.ReadFile(image, metadata_paths[0])
+	if err != nil {
+		return nil, err
+	}
+	metadata := &LayerMetadata{}
+	if err := json.Unmarshal(data, metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// Layers the current blob of a layer declares in depends, none for layers without a current blob
+func LayerDependencies(layer string) ([]string, error) {
+	current_path := filepath.Join(Config.CacheDir, layer, CurrentBlobName)
+	if _, err := os.Stat(current_path); err != nil {
+		return nil, nil
+	}
+	image, err := squashfs.Open(current_path)
+	if err != nil {
+		return nil, fmt.Errorf("reading metadata of %s: %w", layer, err)
+	}
+	defer image.Close()
+
+	metadata, err := ImageMetadata(image)
+	if err != nil || metadata == nil {
+		return nil, err
+	}
+	return metadata.Depends, nil
+}
@@ -0,0 +1,187 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/sys/unix"
+)
+
+type LockMode int
+
+const (
+	LockNone LockMode = iota
+	// Commands that only read the cache, any number of them run at once
+	LockShared
+	// Commands that change the cache, current_blob symlinks or activations
+	LockExclusive
+)
+
+type CommandLock struct {
+	Mode LockMode
+	// Flag that makes a read-only command change things, like doctor --fix
+	ExclusiveFlag string
+}
+
+// Locks taken by commands, by command path. Commands that do not use the cache are not listed.
+var CommandLocks = map[string]CommandLock{
+	"bext cache pack":          {Mode: LockExclusive},
+	"bext cache stats":         {Mode: LockShared},
+	"bext cache unpack":        {Mode: LockExclusive},
+	"bext doctor":              {Mode: LockShared, ExclusiveFlag: "fix"},
+	"bext layer activate":      {Mode: LockExclusive},
+	"bext layer add":           {Mode: LockExclusive},
+	"bext layer apply-delta":   {Mode: LockExclusive},
+	"bext layer apply-pending": {Mode: LockExclusive},
+	"bext layer clean":         {Mode: LockExclusive},
+	"bext layer deactivate":    {Mode: LockExclusive},
+	"bext layer delta":         {Mode: LockShared},
+	"bext layer diff":          {Mode: LockShared},
+	"bext layer get-property":  {Mode: LockShared},
+	"bext layer hold":          {Mode: LockExclusive},
+	"bext layer list":          {Mode: LockShared},
+	"bext layer pin":           {Mode: LockExclusive},
+	"bext layer remove":        {Mode: LockExclusive},
+	"bext layer retention":     {Mode: LockExclusive},
+	"bext layer source":        {Mode: LockExclusive},
+	"bext layer verify":        {Mode: LockShared, ExclusiveFlag: "quarantine"},
+	"bext run":                 {Mode: LockShared},
+	"bext status":              {Mode: LockShared},
+	"bext update":              {Mode: LockExclusive},
+}
+
+func CommandLockMode(command_path string, flags *pflag.FlagSet) LockMode {
+	lock, exists := CommandLocks[command_path]
+	if !exists {
+		return LockNone
+	}
+	if lock.ExclusiveFlag != "" {
+		if enabled, err := flags.GetBool(lock.ExclusiveFlag); err == nil && enabled {
+			return LockExclusive
+		}
+	}
+	return lock.Mode
+}
+
+// Kept open until bext exits, closing it would release the lock
+var heldLock *os.File
+
+func lockFilePath() (string, error) {
+	return filepath.Abs(filepath.Join(Config.CacheDir, LockFileName))
+}
+
+// Takes the advisory lock on the cache root, waiting for other bext processes when wait is set
+func LockCache(mode LockMode, wait bool) error {
+	if mode == LockNone {
+		return nil
+	}
+	lock_path, err := lockFilePath()
+	if err != nil {
+		return err
+	}
+
+	if mode == LockExclusive {
+		if err := os.MkdirAll(filepath.Dir(lock_path), 0755); err != nil {
+			return err
+		}
+	}
+	lock_file, err := os.OpenFile(lock_path, os.O_RDWR|os.O_CREATE, 0644)
+	if mode == LockShared {
+		if errors.Is(err, os.ErrPermission) {
+			lock_file, err = os.Open(lock_path)
+		}
+		// Nothing to read without a cache, or nobody that could write to it
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+			slog.Debug("Not locking the cache", slog.String("path", lock_path), slog.String("error", err.Error()))
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	how := unix.LOCK_SH
+	if mode == LockExclusive {
+		how = unix.LOCK_EX
+	}
+	if err := unix.Flock(int(lock_file.Fd()), how|unix.LOCK_NB); errors.Is(err, unix.EWOULDBLOCK) {
+		pid := lockHolder(lock_file)
+		if !wait {
+			lock_file.Close()
+			return &CacheLockedError{Path: lock_path, PID: pid}
+		}
+		slog.Info("Waiting for another bext process to release the cache", slog.Int("pid", pid))
+		if err := unix.Flock(int(lock_file.Fd()), how); err != nil {
+			lock_file.Close()
+			return err
+		}
+	} else if err != nil {
+		lock_file.Close()
+		return err
+	}
+
+	// Only the holder writes, so waiting processes can still tell who it was when /proc/locks can not
+	if mode == LockExclusive {
+		if err := lock_file.Truncate(0); err == nil {
+			lock_file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+		}
+	}
+	heldLock = lock_file
+	return nil
+}
+
+// Releases the lock before bext exits, like run does once its layers are mounted
+func UnlockCache() error {
+	if heldLock == nil {
+		return nil
+	}
+	err := heldLock.Close()
+	heldLock = nil
+	return err
+}
+
+// Finds the process holding a lock on the file in /proc/locks, falling back to the PID written by the last exclusive holder
+func lockHolder(lock_file *os.File) int {
+	if info, err := lock_file.Stat(); err == nil {
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			id := fmt.Sprintf("%02x:%02x:%d", unix.Major(stat.Dev), unix.Minor(stat.Dev), stat.Ino)
+			if pid := procLocksHolder(id); pid > 0 {
+				return pid
+			}
+		}
+	}
+
+	data := make([]byte, 32)
+	n, _ := lock_file.ReadAt(data, 0)
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data[:n])))
+	return pid
+}
+
+func procLocksHolder(id string) int {
+	locks, err := os.Open("/proc/locks")
+	if err != nil {
+		return 0
+	}
+	defer locks.Close()
+
+	scanner := bufio.NewScanner(locks)
+	for scanner.Scan() {
+		// 1: FLOCK  ADVISORY  WRITE 1234 fd:01:5678 0 EOF, waiters are listed with "->"
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || fields[1] != "FLOCK" || fields[5] != id {
+			continue
+		}
+		if pid, err := strconv.Atoi(fields[4]); err == nil && pid != os.Getpid() {
+			return pid
+		}
+	}
+	return 0
+}
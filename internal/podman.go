@@ -0,0 +1,29 @@
+package internal
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+// Socket podman is expected to listen on, the user's one whenever XDG_RUNTIME_DIR is set
+func PodmanSocketPath() string {
+	sock_dir := os.Getenv("XDG_RUNTIME_DIR")
+	if sock_dir == "" {
+		sock_dir = "/var/run"
+	}
+	return path.Join(sock_dir, "podman", "podman.sock")
+}
+
+// Adds a tag to image references without one, tagged ones like registry:5000/nixos/nix:2.18 or pinned to a digest are kept as they are
+func ImageReference(image string, tag string) string {
+	if HasImageTag(image) {
+		return image
+	}
+	return image + ":" + tag
+}
+
+func HasImageTag(image string) bool {
+	name := image[strings.LastIndex(image, "/")+1:]
+	return strings.ContainsAny(name, ":@")
+}
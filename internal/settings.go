@@ -0,0 +1,264 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/ublue-os/bext/pkg/logging"
+	"github.com/ublue-os/bext/pkg/output"
+	"github.com/ublue-os/bext/pkg/retention"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	SystemSettingsPath = "/etc/bext/config.yaml"
+	SettingsEnvPrefix  = "BEXT_"
+)
+
+// A setting provides the default value of a flag, Commands restricts it to some commands when the flag name is ambiguous
+type Setting struct {
+	Key         string
+	Flag        string
+	Commands    []string
+	Default     string
+	Description string
+	// Rejects values that would make every command fail, nil accepts anything
+	Validate func(string) error
+}
+
+var Settings = []Setting{
+	{Key: "cache-root", Flag: "cache-root", Default: DefaultCacheDir, Description: "root directory for the layer cache"},
+	{Key: "extensions-root", Flag: "extensions-root", Default: DefaultExtensionsDir, Description: "root directory for the systemd-sysext layers"},
+	{Key: "extensions-mount", Flag: "extensions-mount", Default: DefaultExtensionsMount, Description: "directory where systemd-sysext layers will be mounted to"},
+	{Key: "path", Flag: "path", Default: DefaultPathMount, Description: "path where all shared binaries are mounted to"},
+	{Key: "bindmount-path", Flag: "bindmount-path", Default: DefaultStoreBindmount, Description: "path where an already existing nix store is bind-mounted to"},
+	{Key: "build-image", Flag: "image", Commands: []string{"build", "prune"}, Default: DefaultBuildImage, Description: "image used for building layers and collecting garbage in build caches"},
+	{Key: "build-cache-volume", Flag: "cache-volume", Commands: []string{"build"}, Description: "podman volume or host directory kept as /nix between builds, none when empty"},
+	{Key: "recipe-flake", Flag: "recipe-flake", Commands: []string{"build"}, Default: DefaultRecipeFlake, Description: "nix flake used as base for building layers"},
+	{Key: "chunk-store", Flag: "chunk-store", Commands: []string{"add"}, Default: "false", Description: "pack the previous current blob of a layer into the chunk store when adding a new one", Validate: func(value string) error {
+		_, err := strconv.ParseBool(value)
+		return err
+	}},
+	{Key: "keep-last", Flag: "keep-last", Commands: []string{"clean"}, Default: "0", Description: "blobs layer clean keeps for each layer", Validate: func(value string) error {
+		_, err := retention.ParseKeepLast(value)
+		return err
+	}},
+	{Key: "keep-newer-than", Flag: "keep-newer-than", Commands: []string{"clean"}, Description: "age below which layer clean keeps blobs, none when empty", Validate: func(value string) error {
+		if value == "" {
+			return nil
+		}
+		_, err := retention.ParseAge(value)
+		return err
+	}},
+	{Key: "max-cache-size", Flag: "max-cache-size", Commands: []string{"clean"}, Description: "size layer clean shrinks the cache to, unlimited when empty", Validate: func(value string) error {
+		if value == "" {
+			return nil
+		}
+		_, err := retention.ParseSize(value)
+		return err
+	}},
+	{Key: "wait", Flag: "wait", Default: "true", Description: "wait for other bext processes using the cache instead of failing", Validate: func(value string) error {
+		_, err := strconv.ParseBool(value)
+		return err
+	}},
+	{Key: "log-level", Flag: "log-level", Default: "info", Description: "log level for user-facing logs", Validate: func(value string) error {
+		_, err := logging.StrToLogLevel(value)
+		return err
+	}},
+	{Key: "output", Flag: "output", Default: string(output.FormatTable), Description: "output format for commands that print results", Validate: func(value string) error {
+		_, err := output.ParseFormat(value)
+		return err
+	}},
+}
+
+type SettingSource string
+
+const (
+	SourceDefault SettingSource = "default"
+	SourceSystem  SettingSource = "system"
+	SourceUser    SettingSource = "user"
+	SourceEnv     SettingSource = "env"
+	SourceFlag    SettingSource = "flag"
+)
+
+type ResolvedSetting struct {
+	Setting
+	Value  string
+	Source SettingSource
+	// File or variable the value came from
+	Origin string
+}
+
+func LookupSetting(key string) (Setting, error) {
+	index := slices.IndexFunc(Settings, func(s Setting) bool { return s.Key == key })
+	if index < 0 {
+		return Setting{}, &UnknownSettingError{Key: key}
+	}
+	return Settings[index], nil
+}
+
+// BEXT_ followed by the key in upper snake case, like BEXT_CACHE_ROOT
+func SettingEnvName(key string) string {
+	return SettingsEnvPrefix + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
+// $XDG_CONFIG_HOME/bext/config.yaml
+func UserSettingsPath() (string, error) {
+	config_dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(config_dir, "bext", "config.yaml"), nil
+}
+
+// Settings files are flat YAML maps, a missing file has no settings
+func LoadSettingsFile(settings_path string) (map[string]string, error) {
+	data, err := os.ReadFile(settings_path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, &SettingsFileError{Path: settings_path, Err: err}
+	}
+	for key := range values {
+		if _, err := LookupSetting(key); err != nil {
+			return nil, &SettingsFileError{Path: settings_path, Err: err}
+		}
+	}
+	return values, nil
+}
+
+// Precedence, lowest first: built-in defaults (per-user ones in user mode), the system file, the user file and BEXT_* variables.
+// The system file is skipped in user mode since its paths are meant for the system-wide instance.
+func ResolveSettings(user_mode bool) ([]ResolvedSetting, error) {
+	type layer struct {
+		source SettingSource
+		origin string
+		values map[string]string
+	}
+	var layers []layer
+
+	if !user_mode {
+		system_values, err := LoadSettingsFile(SystemSettingsPath)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, layer{source: SourceSystem, origin: SystemSettingsPath, values: system_values})
+	}
+
+	user_path, err := UserSettingsPath()
+	if err == nil {
+		user_values, err := LoadSettingsFile(user_path)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, layer{source: SourceUser, origin: user_path, values: user_values})
+	}
+
+	defaults := map[string]string{}
+	if user_mode {
+		defaults, err = userModeDefaults()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resolved := make([]ResolvedSetting, 0, len(Settings))
+	for _, setting := range Settings {
+		current := ResolvedSetting{Setting: setting, Value: setting.Default, Source: SourceDefault}
+		if user_default, exists := defaults[setting.Key]; exists {
+			current.Value = user_default
+		}
+		for _, settings_layer := range layers {
+			if value, exists := settings_layer.values[setting.Key]; exists {
+				current.Value, current.Source, current.Origin = value, settings_layer.source, settings_layer.origin
+			}
+		}
+		if value, exists := os.LookupEnv(SettingEnvName(setting.Key)); exists {
+			current.Value, current.Source, current.Origin = value, SourceEnv, SettingEnvName(setting.Key)
+		}
+		resolved = append(resolved, current)
+	}
+	return resolved, nil
+}
+
+// Sets every flag the command has for a setting, unless it was explicitly passed, which always wins
+func ApplySettings(command string, flags *pflag.FlagSet, resolved []ResolvedSetting) error {
+	for i, setting := range resolved {
+		if len(setting.Commands) > 0 && !slices.Contains(setting.Commands, command) {
+			continue
+		}
+		flag := flags.Lookup(setting.Flag)
+		if flag == nil {
+			continue
+		}
+		if flag.Changed {
+			resolved[i].Value, resolved[i].Source, resolved[i].Origin = flag.Value.String(), SourceFlag, "--"+setting.Flag
+			continue
+		}
+		if err := flag.Value.Set(setting.Value); err != nil {
+			return &SettingsFileError{Path: setting.Origin, Err: err}
+		}
+	}
+	return nil
+}
+
+// Updates a single key in a settings file, keeping comments and every other key as they are
+func WriteSetting(settings_path string, key string, value string) error {
+	setting, err := LookupSetting(key)
+	if err != nil {
+		return err
+	}
+	if setting.Validate != nil {
+		if err := setting.Validate(value); err != nil {
+			return err
+		}
+	}
+
+	document := &yaml.Node{}
+	data, err := os.ReadFile(settings_path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := yaml.Unmarshal(data, document); err != nil {
+		return &SettingsFileError{Path: settings_path, Err: err}
+	}
+	if len(document.Content) == 0 {
+		document = &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	mapping := document.Content[0]
+	if mapping.Kind != yaml.MappingNode {
+		return &SettingsFileError{Path: settings_path, Err: errors.New("settings must be a map")}
+	}
+
+	updated := false
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1].SetString(value)
+			updated = true
+		}
+	}
+	if !updated {
+		value_node := &yaml.Node{}
+		value_node.SetString(value)
+		mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value_node)
+	}
+
+	out, err := yaml.Marshal(document)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(settings_path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(settings_path, out, 0644)
+}
@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Intent recorded by the user about a layer, which cannot be derived from the cache itself
+type LayerState struct {
+	// Held layers never get their current blob moved without being forced
+	Held bool `json:"held,omitempty"`
+	// Pinned blobs are never cleaned
+	Pinned []string `json:"pinned,omitempty"`
+	// Where bext update looks for newer blobs
+	Source *UpdateSource `json:"source,omitempty"`
+	// Blob downloaded by bext update --download-only, made current on the next boot
+	Staged string `json:"staged,omitempty"`
+	// Current blob before bext update last moved it, never cleaned so the update can be rolled back
+	Rollback string `json:"rollback,omitempty"`
+	// Activation or deactivation applied on the next boot
+	Pending PendingChange `json:"pending,omitempty"`
+	// Rules bext layer clean applies to the layer instead of the global ones, like keep-last=5,keep-newer-than=30d
+	Retention string `json:"retention,omitempty"`
+}
+
+type PendingChange string
+
+const (
+	PendingActivate   PendingChange = "activate"
+	PendingDeactivate PendingChange = "deactivate"
+)
+
+type UpdateSourceType string
+
+const (
+	// Directory or URL serving an index.json of the latest blob of every layer
+	SourceRepository UpdateSourceType = "repository"
+	// Artifact in an OCI registry with the image as one of its layers
+	SourceOCI UpdateSourceType = "oci"
+	// Layer configuration built again with bext layer build
+	SourceConfig UpdateSourceType = "config"
+)
+
+var ValidSourceTypes = []string{string(SourceRepository), string(SourceOCI), string(SourceConfig)}
+
+type UpdateSource struct {
+	Type     UpdateSourceType `json:"type"`
+	Location string           `json:"location"`
+	// What the source last offered (like the digest of an OCI blob) and the blob it was cached as, so it is not downloaded again
+	Revision string `json:"revision,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+func (s *UpdateSource) String() string {
+	return string(s.Type) + ":" + s.Location
+}
+
+// Parses TYPE:LOCATION, like oci:ghcr.io/ublue-os/layers:example
+func ParseUpdateSource(source string) (*UpdateSource, error) {
+	source_type, location, found := strings.Cut(source, ":")
+	if !found || location == "" || !slices.Contains(ValidSourceTypes, source_type) {
+		return nil, &InvalidSourceError{Source: source}
+	}
+	return &UpdateSource{Type: UpdateSourceType(source_type), Location: location}, nil
+}
+
+type CacheState struct {
+	Layers map[string]*LayerState `json:"layers"`
+}
+
+func cacheStatePath() (string, error) {
+	return filepath.Abs(filepath.Join(Config.CacheDir, StateFileName))
+}
+
+func LoadCacheState() (*CacheState, error) {
+	state := &CacheState{Layers: map[string]*LayerState{}}
+
+	state_path, err := cacheStatePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(state_path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Layers == nil {
+		state.Layers = map[string]*LayerState{}
+	}
+	return state, nil
+}
+
+// Writes the state to a temporary file first so it never ends up half-written
+func (s *CacheState) Save() error {
+	state_path, err := cacheStatePath()
+	if err != nil {
+		return err
+	}
+	for name, layer := range s.Layers {
+		if !layer.Held && len(layer.Pinned) == 0 && layer.Source == nil && layer.Staged == "" && layer.Rollback == "" && layer.Pending == "" && layer.Retention == "" {
+			delete(s.Layers, name)
+		}
+	}
+
+	data, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(state_path), 0755); err != nil {
+		return err
+	}
+
+	tmp_path := state_path + ".tmp"
+	if err := os.WriteFile(tmp_path, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp_path, state_path)
+}
+
+// Returns the state of a layer, creating it when it does not exist yet
+func (s *CacheState) Layer(name string) *LayerState {
+	if _, exists := s.Layers[name]; !exists {
+		s.Layers[name] = &LayerState{}
+	}
+	return s.Layers[name]
+}
+
+func (s *CacheState) IsHeld(layer string) bool {
+	state, exists := s.Layers[layer]
+	return exists && state.Held
+}
+
+func (s *CacheState) IsPinned(layer string, hash string) bool {
+	state, exists := s.Layers[layer]
+	return exists && slices.Contains(state.Pinned, hash)
+}
+
+func (s *CacheState) IsStaged(layer string, hash string) bool {
+	state, exists := s.Layers[layer]
+	return exists && state.Staged == hash
+}
+
+func (s *CacheState) IsRollback(layer string, hash string) bool {
+	state, exists := s.Layers[layer]
+	return exists && state.Rollback == hash
+}
+
+func (s *CacheState) PendingChange(layer string) PendingChange {
+	state, exists := s.Layers[layer]
+	if !exists {
+		return ""
+	}
+	return state.Pending
+}
@@ -0,0 +1,35 @@
+package internal
+
+import (
+	"os"
+	"path"
+)
+
+// Equivalent to os.UserCacheDir for $XDG_DATA_HOME
+func UserDataDir() (string, error) {
+	if data_home := os.Getenv("XDG_DATA_HOME"); data_home != "" {
+		return data_home, nil
+	}
+	user_home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(user_home, ".local", "share"), nil
+}
+
+// Defaults replacing the root-owned ones in user mode, keyed by setting
+func userModeDefaults() (map[string]string, error) {
+	user_cache, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	user_data, err := UserDataDir()
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"cache-root":      path.Join(user_cache, "bext", "blobs"),
+		"extensions-root": path.Join(user_data, "bext", "extensions"),
+	}, nil
+}
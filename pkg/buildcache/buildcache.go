@@ -0,0 +1,125 @@
+package buildcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containers/podman/v4/pkg/bindings/containers"
+	"github.com/containers/podman/v4/pkg/bindings/volumes"
+	"github.com/containers/podman/v4/pkg/domain/entities"
+	"github.com/containers/podman/v4/pkg/specgen"
+)
+
+const (
+	// Where the volume is mounted in build containers, the whole of it so nix itself and its database are kept too
+	Destination = "/nix"
+	Label       = "io.github.ublue-os.bext.build-cache"
+	// Volumes backing host directories are named after a hash of the directory
+	hostVolumePrefix = "bext-nix-"
+)
+
+// Names containing a slash are host directories, backed by a volume bound to them
+func IsHostDirectory(name string) bool {
+	return strings.Contains(name, "/")
+}
+
+// Name of the podman volume for a cache name, which is either a volume name or a host directory
+func VolumeName(name string) (string, error) {
+	if !IsHostDirectory(name) {
+		return name, nil
+	}
+	host_dir, err := filepath.Abs(filepath.Clean(name))
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(host_dir))
+	return hostVolumePrefix + hex.EncodeToString(digest[:])[:12], nil
+}
+
+// Creates the volume of a cache unless it exists, returning whether it was created.
+// Podman copies the image's /nix into empty volumes the first time they are mounted, so new caches start out usable.
+func Ensure(conn context.Context, name string) (string, bool, error) {
+	volume, err := VolumeName(name)
+	if err != nil {
+		return "", false, err
+	}
+	exists, err := volumes.Exists(conn, volume, nil)
+	if err != nil {
+		return "", false, err
+	}
+	if exists {
+		return volume, false, nil
+	}
+
+	create_options := entities.VolumeCreateOptions{Name: volume, Labels: map[string]string{Label: "true"}}
+	if IsHostDirectory(name) {
+		host_dir, err := filepath.Abs(filepath.Clean(name))
+		if err != nil {
+			return "", false, err
+		}
+		if err := os.MkdirAll(host_dir, 0755); err != nil {
+			return "", false, err
+		}
+		create_options.Labels[Label] = host_dir
+		create_options.Options = map[string]string{"type": "none", "o": "bind", "device": host_dir}
+	}
+	if _, err := volumes.Create(conn, create_options, nil); err != nil {
+		return "", false, err
+	}
+	return volume, true, nil
+}
+
+// Mount for build containers, shared labeling since several builds can use the same cache at once
+func Mount(volume string) *specgen.NamedVolume {
+	return &specgen.NamedVolume{Name: volume, Dest: Destination, Options: []string{"rw", "z"}}
+}
+
+// Every volume bext created for caching
+func List(conn context.Context) ([]*entities.VolumeListReport, error) {
+	return volumes.List(conn, new(volumes.ListOptions).WithFilters(map[string][]string{"label": {Label}}))
+}
+
+// Runs a container with only the cache mounted and waits for it, returning its exit code
+func Run(conn context.Context, image string, volume string, command []string) (int32, error) {
+	spec := specgen.NewSpecGenerator(image, false)
+	spec.Volumes = append(spec.Volumes, Mount(volume))
+	spec.Command = command
+
+	response, err := containers.CreateWithSpec(conn, spec, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer containers.Remove(conn, response.ID, nil)
+	if err := containers.Start(conn, response.ID, nil); err != nil {
+		return 0, err
+	}
+	return containers.Wait(conn, response.ID, nil)
+}
+
+// Bytes used by a volume, only works when its mountpoint is readable from here
+func Size(conn context.Context, volume string) (int64, error) {
+	inspect, err := volumes.Inspect(conn, volume, nil)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	err = filepath.WalkDir(inspect.Mountpoint, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,113 @@
+// Splits streams into content-defined chunks, so that inserting or removing bytes only changes the chunks around them
+package chunker
+
+import (
+	"errors"
+	"io"
+)
+
+const (
+	DefaultMinSize = 16 * 1024
+	DefaultAvgSize = 64 * 1024
+	DefaultMaxSize = 256 * 1024
+)
+
+// Gear hash values for every byte. Chunk boundaries depend on them, so changing the seed breaks every stored chunk and delta.
+var gear = func() [256]uint64 {
+	var table [256]uint64
+	// splitmix64
+	state := uint64(0x62657874)
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		value := state
+		value = (value ^ (value >> 30)) * 0xbf58476d1ce4e5b9
+		value = (value ^ (value >> 27)) * 0x94d049bb133111eb
+		table[i] = value ^ (value >> 31)
+	}
+	return table
+}()
+
+type Chunk struct {
+	// Position of the chunk in the stream
+	Offset int64
+	// Only valid until the next call to Next
+	Data []byte
+}
+
+type Chunker struct {
+	reader   io.Reader
+	buffer   []byte
+	start    int
+	end      int
+	offset   int64
+	eof      bool
+	min_size int
+	max_size int
+	mask     uint64
+}
+
+func New(reader io.Reader) *Chunker {
+	return NewWithSizes(reader, DefaultMinSize, DefaultAvgSize, DefaultMaxSize)
+}
+
+// The average size is rounded down to a power of two
+func NewWithSizes(reader io.Reader, min_size int, avg_size int, max_size int) *Chunker {
+	bits := 0
+	for 1<<(bits+1) <= avg_size {
+		bits++
+	}
+	return &Chunker{
+		reader:   reader,
+		buffer:   make([]byte, 2*max_size),
+		min_size: min_size,
+		max_size: max_size,
+		// The top bits of the gear hash depend on the last 64 bytes, the bottom ones only on the last few
+		mask: ^uint64(0) << (64 - bits),
+	}
+}
+
+// Returns io.EOF once the whole stream has been chunked
+func (c *Chunker) Next() (Chunk, error) {
+	if c.end-c.start < c.max_size && !c.eof {
+		if err := c.fill(); err != nil {
+			return Chunk{}, err
+		}
+	}
+	if c.start == c.end {
+		return Chunk{}, io.EOF
+	}
+
+	size := c.boundary(c.buffer[c.start:c.end])
+	chunk := Chunk{Offset: c.offset, Data: c.buffer[c.start : c.start+size]}
+	c.start += size
+	c.offset += int64(size)
+	return chunk, nil
+}
+
+func (c *Chunker) fill() error {
+	copy(c.buffer, c.buffer[c.start:c.end])
+	c.end -= c.start
+	c.start = 0
+	read, err := io.ReadFull(c.reader, c.buffer[c.end:])
+	c.end += read
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		c.eof = true
+		return nil
+	}
+	return err
+}
+
+func (c *Chunker) boundary(data []byte) int {
+	if len(data) <= c.min_size {
+		return len(data)
+	}
+	limit := min(len(data), c.max_size)
+	var hash uint64
+	for i := 0; i < limit; i++ {
+		hash = (hash << 1) + gear[data[i]]
+		if i >= c.min_size && hash&c.mask == 0 {
+			return i + 1
+		}
+	}
+	return limit
+}
@@ -0,0 +1,364 @@
+// Stores files as content-defined chunks named after their sha256, so chunks shared by several files are only stored once.
+// Every file has an index listing its chunks, under a name made of slash-separated parts.
+package chunkstore
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ublue-os/bext/pkg/chunker"
+)
+
+const (
+	chunksDir   = "chunks"
+	indexesDir  = "indexes"
+	indexMagic  = "BEXTIDX1"
+	sumSize     = sha256.Size
+	maxChunks   = 1 << 28
+	entrySize   = sumSize + 4
+	partialName = ".partial-*"
+)
+
+type ChunkRef struct {
+	Sum  [sumSize]byte
+	Size uint32
+}
+
+type Index struct {
+	Size   int64
+	Chunks []ChunkRef
+}
+
+type Store struct {
+	root string
+}
+
+func New(root string) *Store {
+	return &Store{root: root}
+}
+
+func (s *Store) chunkPath(sum [sumSize]byte) string {
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(s.root, chunksDir, name[:2], name)
+}
+
+func (s *Store) indexPath(name string) (string, error) {
+	if name == "" || !fs.ValidPath(name) || strings.HasPrefix(baseName(name), ".") {
+		return "", &InvalidNameError{Name: name}
+	}
+	return filepath.Join(s.root, indexesDir, filepath.FromSlash(name)), nil
+}
+
+// Last part of a name, which is also its file name
+func baseName(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+// Splits the reader into chunks, writing the ones that are not stored yet, and records them under name
+func (s *Store) Put(name string, reader io.Reader) (Index, error) {
+	index_path, err := s.indexPath(name)
+	if err != nil {
+		return Index{}, err
+	}
+
+	index := Index{}
+	chunks := chunker.New(reader)
+	for {
+		chunk, err := chunks.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return Index{}, err
+		}
+		ref := ChunkRef{Sum: sha256.Sum256(chunk.Data), Size: uint32(len(chunk.Data))}
+		if err := s.writeChunk(ref.Sum, chunk.Data); err != nil {
+			return Index{}, err
+		}
+		index.Chunks = append(index.Chunks, ref)
+		index.Size += int64(ref.Size)
+	}
+
+	var encoded bytes.Buffer
+	encoded.WriteString(indexMagic)
+	binary.Write(&encoded, binary.LittleEndian, uint64(index.Size))
+	binary.Write(&encoded, binary.LittleEndian, uint32(len(index.Chunks)))
+	for _, ref := range index.Chunks {
+		encoded.Write(ref.Sum[:])
+		binary.Write(&encoded, binary.LittleEndian, ref.Size)
+	}
+	return index, writeFile(index_path, encoded.Bytes())
+}
+
+func (s *Store) writeChunk(sum [sumSize]byte, data []byte) error {
+	chunk_path := s.chunkPath(sum)
+	if _, err := os.Stat(chunk_path); err == nil {
+		return nil
+	}
+	return writeFile(chunk_path, data)
+}
+
+// Files are renamed into place once complete, so a store never has half-written chunks or indexes
+func writeFile(file_path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file_path), 0755); err != nil {
+		return err
+	}
+	partial, err := os.CreateTemp(filepath.Dir(file_path), partialName)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(partial.Name())
+	if _, err := partial.Write(data); err != nil {
+		partial.Close()
+		return err
+	}
+	if err := partial.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(partial.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(partial.Name(), file_path)
+}
+
+func (s *Store) Has(name string) bool {
+	index_path, err := s.indexPath(name)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(index_path)
+	return err == nil
+}
+
+func (s *Store) Index(name string) (Index, error) {
+	index_path, err := s.indexPath(name)
+	if err != nil {
+		return Index{}, err
+	}
+	file, err := os.Open(index_path)
+	if err != nil {
+		return Index{}, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+
+	header := make([]byte, len(indexMagic))
+	if _, err := io.ReadFull(reader, header); err != nil || string(header) != indexMagic {
+		return Index{}, &InvalidIndexError{Name: name, Reason: "bad magic"}
+	}
+	var size uint64
+	var count uint32
+	if err := binary.Read(reader, binary.LittleEndian, &size); err != nil {
+		return Index{}, &InvalidIndexError{Name: name, Reason: "truncated"}
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &count); err != nil || count > maxChunks {
+		return Index{}, &InvalidIndexError{Name: name, Reason: "bad chunk count"}
+	}
+
+	index := Index{Size: int64(size), Chunks: make([]ChunkRef, count)}
+	entry := make([]byte, entrySize)
+	var total uint64
+	for i := range index.Chunks {
+		if _, err := io.ReadFull(reader, entry); err != nil {
+			return Index{}, &InvalidIndexError{Name: name, Reason: "truncated"}
+		}
+		copy(index.Chunks[i].Sum[:], entry)
+		index.Chunks[i].Size = binary.LittleEndian.Uint32(entry[sumSize:])
+		total += uint64(index.Chunks[i].Size)
+	}
+	if total != size {
+		return Index{}, &InvalidIndexError{Name: name, Reason: "chunk sizes do not add up"}
+	}
+	return index, nil
+}
+
+// Information about the index of a file, its modification time is the one of the file unless changed with Chtimes
+func (s *Store) Stat(name string) (fs.FileInfo, error) {
+	index_path, err := s.indexPath(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(index_path)
+}
+
+func (s *Store) Chtimes(name string, mtime time.Time) error {
+	index_path, err := s.indexPath(name)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(index_path, mtime, mtime)
+}
+
+// Writes the file stored under name, checking every chunk against its sum
+func (s *Store) Get(name string, out io.Writer) error {
+	index, err := s.Index(name)
+	if err != nil {
+		return err
+	}
+	for _, ref := range index.Chunks {
+		data, err := os.ReadFile(s.chunkPath(ref.Sum))
+		if err != nil || uint32(len(data)) != ref.Size || sha256.Sum256(data) != ref.Sum {
+			return &CorruptedChunkError{Sum: hex.EncodeToString(ref.Sum[:])}
+		}
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Removes the index of a file, its chunks stay until Prune
+func (s *Store) Remove(name string) error {
+	index_path, err := s.indexPath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(index_path); err != nil {
+		return err
+	}
+	// Empty parents are left behind otherwise, the indexes directory itself is kept
+	for dir := filepath.Dir(index_path); dir != filepath.Join(s.root, indexesDir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// Names of every stored file
+func (s *Store) Names() ([]string, error) {
+	indexes_dir := filepath.Join(s.root, indexesDir)
+	var names []string
+	err := filepath.WalkDir(indexes_dir, func(file_path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && file_path == indexes_dir {
+			return filepath.SkipAll
+		} else if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+		name, err := filepath.Rel(indexes_dir, file_path)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(name))
+		return nil
+	})
+	return names, err
+}
+
+// Size and references of every stored chunk, chunks no index references have 0 references
+type ChunkUsage struct {
+	Size       int64
+	References int
+}
+
+func (s *Store) Usage() (map[[sumSize]byte]*ChunkUsage, error) {
+	usage := map[[sumSize]byte]*ChunkUsage{}
+	chunks_dir := filepath.Join(s.root, chunksDir)
+	err := filepath.WalkDir(chunks_dir, func(file_path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && file_path == chunks_dir {
+			return filepath.SkipAll
+		} else if err != nil {
+			return err
+		}
+		sum, ok := parseSum(entry.Name())
+		if !ok || !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		usage[sum] = &ChunkUsage{Size: info.Size()}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := s.Names()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		index, err := s.Index(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range index.Chunks {
+			if chunk, exists := usage[ref.Sum]; exists {
+				chunk.References++
+			}
+		}
+	}
+	return usage, nil
+}
+
+// Removes chunks that no index references anymore
+func (s *Store) Prune() (removed int, freed int64, err error) {
+	usage, err := s.Usage()
+	if err != nil {
+		return 0, 0, err
+	}
+	for sum, chunk := range usage {
+		if chunk.References > 0 {
+			continue
+		}
+		if err := os.Remove(s.chunkPath(sum)); err != nil {
+			return removed, freed, err
+		}
+		// Fails while other chunks share the directory
+		os.Remove(filepath.Dir(s.chunkPath(sum)))
+		removed++
+		freed += chunk.Size
+	}
+	return removed, freed, nil
+}
+
+// What Prune would remove once the given files are removed, without changing anything
+func (s *Store) Prunable(removing []string) (removed int, freed int64, err error) {
+	usage, err := s.Usage()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, name := range removing {
+		index, err := s.Index(name)
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, ref := range index.Chunks {
+			if chunk, exists := usage[ref.Sum]; exists {
+				chunk.References--
+			}
+		}
+	}
+	for _, chunk := range usage {
+		if chunk.References > 0 {
+			continue
+		}
+		removed++
+		freed += chunk.Size
+	}
+	return removed, freed, nil
+}
+
+func parseSum(name string) ([sumSize]byte, bool) {
+	var sum [sumSize]byte
+	decoded, err := hex.DecodeString(name)
+	if err != nil || len(decoded) != sumSize {
+		return sum, false
+	}
+	copy(sum[:], decoded)
+	return sum, true
+}
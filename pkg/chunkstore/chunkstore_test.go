@@ -0,0 +1,262 @@
+package chunkstore
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func randomBytes(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func put(t *testing.T, store *Store, name string, data []byte) Index {
+	t.Helper()
+	index, err := store.Put(name, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return index
+}
+
+func get(t *testing.T, store *Store, name string) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	if err := store.Get(name, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// Number and size of every stored chunk, referenced or not
+func storedChunks(t *testing.T, store *Store) (count int, size int64) {
+	t.Helper()
+	usage, err := store.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range usage {
+		count++
+		size += chunk.Size
+	}
+	return count, size
+}
+
+func TestPutGet(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"small", []byte("a single chunk")},
+		{"layer/0123456789abcdef", randomBytes(1, 2<<20)},
+		{"nested/layer/hash", bytes.Repeat([]byte("bext "), 100000)},
+	}
+	store := New(t.TempDir())
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			index := put(t, store, test.name, test.data)
+			if index.Size != int64(len(test.data)) {
+				t.Errorf("indexed %d bytes of %d", index.Size, len(test.data))
+			}
+			if !store.Has(test.name) {
+				t.Errorf("%s is not stored", test.name)
+			}
+			read, err := store.Index(test.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if read.Size != index.Size || len(read.Chunks) != len(index.Chunks) {
+				t.Errorf("read an index of %d bytes in %d chunks, wrote %d in %d", read.Size, len(read.Chunks), index.Size, len(index.Chunks))
+			}
+			if !bytes.Equal(get(t, store, test.name), test.data) {
+				t.Error("got different data back")
+			}
+		})
+	}
+
+	names, err := store.Names()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != len(tests) {
+		t.Errorf("got names %v", names)
+	}
+}
+
+func TestDeduplication(t *testing.T) {
+	store := New(t.TempDir())
+	old := randomBytes(1, 4<<20)
+	updated := append(bytes.Clone(old[:2<<20]), randomBytes(2, 100000)...)
+	updated = append(updated, old[2<<20:]...)
+
+	put(t, store, "layer/old", old)
+	_, old_size := storedChunks(t, store)
+	put(t, store, "layer/updated", updated)
+	_, total_size := storedChunks(t, store)
+
+	if old_size != int64(len(old)) {
+		t.Errorf("stored %d bytes for a %d byte file", old_size, len(old))
+	}
+	// Only the chunks around the inserted data are new
+	if added := total_size - old_size; added > int64(len(updated))/4 {
+		t.Errorf("stored %d more bytes for a file that shares most of its content", added)
+	}
+	if !bytes.Equal(get(t, store, "layer/updated"), updated) {
+		t.Error("got different data back")
+	}
+}
+
+func TestRemovePrune(t *testing.T) {
+	root := t.TempDir()
+	store := New(root)
+	shared := randomBytes(1, 2<<20)
+	put(t, store, "first/a", append(bytes.Clone(shared), randomBytes(2, 1<<20)...))
+	second := append(bytes.Clone(shared), randomBytes(3, 1<<20)...)
+	put(t, store, "second/b", second)
+	chunks, size := storedChunks(t, store)
+
+	prunable, prunable_size, err := store.Prunable([]string{"first/a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining, _ := storedChunks(t, store); remaining != chunks || !store.Has("first/a") {
+		t.Error("Prunable changed the store")
+	}
+
+	if err := store.Remove("first/a"); err != nil {
+		t.Fatal(err)
+	}
+	if store.Has("first/a") {
+		t.Error("first/a is still stored")
+	}
+	if _, err := os.Stat(filepath.Join(root, indexesDir, "first")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("empty index directory was left behind: %v", err)
+	}
+	if remaining, _ := storedChunks(t, store); remaining != chunks {
+		t.Errorf("Remove deleted %d chunks", chunks-remaining)
+	}
+
+	removed, freed, err := store.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remaining, remaining_size := storedChunks(t, store)
+	if removed == 0 || removed != chunks-remaining || freed != size-remaining_size {
+		t.Errorf("pruned %d chunks freeing %d bytes, %d chunks and %d bytes are gone", removed, freed, chunks-remaining, size-remaining_size)
+	}
+	if prunable != removed || prunable_size != freed {
+		t.Errorf("Prunable reported %d chunks and %d bytes, Prune removed %d chunks and %d bytes", prunable, prunable_size, removed, freed)
+	}
+	// The chunks of the shared data are still used by second/b
+	if remaining_size != int64(len(second)) {
+		t.Errorf("%d bytes of chunks are left for a %d byte file", remaining_size, len(second))
+	}
+	if !bytes.Equal(get(t, store, "second/b"), second) {
+		t.Error("got different data back")
+	}
+
+	if err := store.Remove("second/b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.Prune(); err != nil {
+		t.Fatal(err)
+	}
+	if remaining, _ := storedChunks(t, store); remaining != 0 {
+		t.Errorf("%d chunks are left in an empty store", remaining)
+	}
+}
+
+func TestInvalidName(t *testing.T) {
+	store := New(t.TempDir())
+	for _, name := range []string{"", "../escape", "/absolute", "layer/../other", "layer/.partial-1", "layer//hash"} {
+		t.Run(name, func(t *testing.T) {
+			var invalid *InvalidNameError
+			if _, err := store.Put(name, bytes.NewReader([]byte("data"))); !errors.As(err, &invalid) {
+				t.Errorf("got %v, want an InvalidNameError", err)
+			}
+			if store.Has(name) {
+				t.Errorf("%s is stored", name)
+			}
+		})
+	}
+}
+
+func TestCorruptedChunk(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(chunk_path string) error
+	}{
+		{"missing", os.Remove},
+		{"changed", func(chunk_path string) error { return os.WriteFile(chunk_path, randomBytes(3, 100), 0644) }},
+		{"truncated", func(chunk_path string) error { return os.Truncate(chunk_path, 10) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := New(t.TempDir())
+			index := put(t, store, "layer/hash", randomBytes(1, 1<<20))
+			if err := test.corrupt(store.chunkPath(index.Chunks[len(index.Chunks)/2].Sum)); err != nil {
+				t.Fatal(err)
+			}
+			var corrupted *CorruptedChunkError
+			if err := store.Get("layer/hash", &bytes.Buffer{}); !errors.As(err, &corrupted) {
+				t.Errorf("got %v, want a CorruptedChunkError", err)
+			}
+		})
+	}
+}
+
+func TestInvalidIndex(t *testing.T) {
+	root := t.TempDir()
+	store := New(root)
+	put(t, store, "layer/hash", randomBytes(1, 1<<20))
+	index_path := filepath.Join(root, indexesDir, "layer", "hash")
+	encoded, err := os.ReadFile(index_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		index []byte
+	}{
+		{"empty", []byte{}},
+		{"bad magic", append([]byte("BEXTDLT1"), encoded[len(indexMagic):]...)},
+		{"truncated", encoded[:len(encoded)-1]},
+		{"trailing size", append(bytes.Clone(encoded[:len(encoded)-4]), 0, 0, 0, 0)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := os.WriteFile(index_path, test.index, 0644); err != nil {
+				t.Fatal(err)
+			}
+			var invalid *InvalidIndexError
+			if _, err := store.Index("layer/hash"); !errors.As(err, &invalid) {
+				t.Errorf("got %v, want an InvalidIndexError", err)
+			}
+		})
+	}
+}
+
+func TestChtimes(t *testing.T) {
+	store := New(t.TempDir())
+	put(t, store, "layer/hash", []byte("data"))
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := store.Chtimes("layer/hash", mtime); err != nil {
+		t.Fatal(err)
+	}
+	info, err := store.Stat("layer/hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("got modification time %s, want %s", info.ModTime(), mtime)
+	}
+}
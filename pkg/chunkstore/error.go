@@ -0,0 +1,29 @@
+package chunkstore
+
+import "fmt"
+
+type InvalidNameError struct {
+	Name string
+}
+
+func (e *InvalidNameError) Error() string {
+	return fmt.Sprintf("Invalid chunk store name: %s", e.Name)
+}
+
+type InvalidIndexError struct {
+	Name   string
+	Reason string
+}
+
+func (e *InvalidIndexError) Error() string {
+	return fmt.Sprintf("Invalid chunk index %s: %s", e.Name, e.Reason)
+}
+
+// A chunk is missing or does not have the content its name says
+type CorruptedChunkError struct {
+	Sum string
+}
+
+func (e *CorruptedChunkError) Error() string {
+	return fmt.Sprintf("Chunk %s is missing or corrupted", e.Sum)
+}
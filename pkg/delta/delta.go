@@ -0,0 +1,254 @@
+// Binary deltas between two versions of a file, made of ranges copied from the old version (the base) and new data.
+// Both versions are split with content-defined chunking, so data that only moved is still found in the base.
+package delta
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ublue-os/bext/pkg/chunker"
+)
+
+const (
+	magic = "BEXTDLT1"
+
+	opEnd  = 0
+	opCopy = 1
+	opData = 2
+
+	// New data is compressed in runs of at most this size
+	maxDataSize = 4 * 1024 * 1024
+	// Longest header that is accepted, everything in it is short
+	maxHeaderSize = 64 * 1024
+)
+
+// Digests are md5, like the names of cached blobs
+type Header struct {
+	Layer      string `json:"layer"`
+	Base       string `json:"base"`
+	Target     string `json:"target"`
+	TargetSize int64  `json:"target_size"`
+}
+
+type Stats struct {
+	// Bytes of the target copied from the base
+	Copied int64 `json:"copied"`
+	// Bytes of the target stored in the delta, before compression
+	Added int64 `json:"added"`
+	// Size of the delta itself
+	Size int64 `json:"size"`
+}
+
+type location struct {
+	offset int64
+	size   int
+}
+
+// Writes a delta that turns base into target. The target is read once, the base once to index its chunks.
+func Create(out io.Writer, header Header, base io.Reader, target io.Reader) (Stats, error) {
+	index := map[[sha256.Size]byte]location{}
+	base_chunks := chunker.New(base)
+	for {
+		chunk, err := base_chunks.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return Stats{}, err
+		}
+		sum := sha256.Sum256(chunk.Data)
+		if _, exists := index[sum]; !exists {
+			index[sum] = location{offset: chunk.Offset, size: len(chunk.Data)}
+		}
+	}
+
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+	if err != nil {
+		return Stats{}, err
+	}
+	defer encoder.Close()
+
+	counter := &countingWriter{writer: out}
+	writer := bufio.NewWriter(counter)
+	encoded_header, err := json.Marshal(header)
+	if err != nil {
+		return Stats{}, err
+	}
+	writer.WriteString(magic)
+	writeUvarint(writer, uint64(len(encoded_header)))
+	writer.Write(encoded_header)
+
+	stats := Stats{}
+	var pending_copy location
+	var pending_data []byte
+	flushCopy := func() {
+		if pending_copy.size == 0 {
+			return
+		}
+		writer.WriteByte(opCopy)
+		writeUvarint(writer, uint64(pending_copy.offset))
+		writeUvarint(writer, uint64(pending_copy.size))
+		stats.Copied += int64(pending_copy.size)
+		pending_copy = location{}
+	}
+	flushData := func() {
+		if len(pending_data) == 0 {
+			return
+		}
+		compressed := encoder.EncodeAll(pending_data, nil)
+		writer.WriteByte(opData)
+		writeUvarint(writer, uint64(len(pending_data)))
+		writeUvarint(writer, uint64(len(compressed)))
+		writer.Write(compressed)
+		stats.Added += int64(len(pending_data))
+		pending_data = pending_data[:0]
+	}
+
+	hash := md5.New()
+	target_chunks := chunker.New(io.TeeReader(target, hash))
+	var target_size int64
+	for {
+		chunk, err := target_chunks.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return Stats{}, err
+		}
+		target_size += int64(len(chunk.Data))
+
+		found, exists := index[sha256.Sum256(chunk.Data)]
+		if !exists {
+			flushCopy()
+			if len(pending_data)+len(chunk.Data) > maxDataSize {
+				flushData()
+			}
+			pending_data = append(pending_data, chunk.Data...)
+			continue
+		}
+		flushData()
+		// Chunks that follow each other in the base are copied at once
+		if pending_copy.size > 0 && pending_copy.offset+int64(pending_copy.size) == found.offset {
+			pending_copy.size += found.size
+			continue
+		}
+		flushCopy()
+		pending_copy = found
+	}
+	flushCopy()
+	flushData()
+	writer.WriteByte(opEnd)
+	if err := writer.Flush(); err != nil {
+		return Stats{}, err
+	}
+
+	// The header was written before the target was read, so a target that changed in between is caught here
+	if digest := hex.EncodeToString(hash.Sum(nil)); digest != header.Target || target_size != header.TargetSize {
+		return Stats{}, &DigestMismatchError{Expected: header.Target, Actual: digest}
+	}
+	stats.Size = counter.written
+	return stats, nil
+}
+
+func ReadHeader(reader *bufio.Reader) (Header, error) {
+	header := Header{}
+	start := make([]byte, len(magic))
+	if _, err := io.ReadFull(reader, start); err != nil || string(start) != magic {
+		return header, &InvalidDeltaError{Reason: "not a bext delta"}
+	}
+	size, err := binary.ReadUvarint(reader)
+	if err != nil || size > maxHeaderSize {
+		return header, &InvalidDeltaError{Reason: "bad header size"}
+	}
+	encoded_header := make([]byte, size)
+	if _, err := io.ReadFull(reader, encoded_header); err != nil {
+		return header, &InvalidDeltaError{Reason: "truncated header"}
+	}
+	if err := json.Unmarshal(encoded_header, &header); err != nil {
+		return header, &InvalidDeltaError{Reason: "bad header: " + err.Error()}
+	}
+	return header, nil
+}
+
+// Reconstructs the target into out from the rest of the delta, after its header was read.
+// Fails with a DigestMismatchError when the result does not have the target's digest and size.
+func Apply(reader *bufio.Reader, header Header, base io.ReaderAt, out io.Writer) error {
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(2*maxDataSize))
+	if err != nil {
+		return err
+	}
+	defer decoder.Close()
+
+	hash := md5.New()
+	writer := io.MultiWriter(out, hash)
+	var written int64
+	for {
+		op, err := reader.ReadByte()
+		if err != nil {
+			return &InvalidDeltaError{Reason: "truncated"}
+		}
+
+		switch op {
+		case opEnd:
+			digest := hex.EncodeToString(hash.Sum(nil))
+			if digest != header.Target || written != header.TargetSize {
+				return &DigestMismatchError{Expected: header.Target, Actual: digest}
+			}
+			return nil
+		case opCopy:
+			offset, offset_err := binary.ReadUvarint(reader)
+			size, size_err := binary.ReadUvarint(reader)
+			if offset_err != nil || size_err != nil || offset > 1<<62 || size > 1<<62 {
+				return &InvalidDeltaError{Reason: "bad copy"}
+			}
+			copied, err := io.Copy(writer, io.NewSectionReader(base, int64(offset), int64(size)))
+			if err != nil {
+				return err
+			}
+			if copied != int64(size) {
+				return &InvalidDeltaError{Reason: "copy beyond the end of the base"}
+			}
+			written += copied
+		case opData:
+			size, size_err := binary.ReadUvarint(reader)
+			compressed_size, compressed_err := binary.ReadUvarint(reader)
+			if size_err != nil || compressed_err != nil || size > maxDataSize || compressed_size > 2*maxDataSize {
+				return &InvalidDeltaError{Reason: "bad data"}
+			}
+			compressed := make([]byte, compressed_size)
+			if _, err := io.ReadFull(reader, compressed); err != nil {
+				return &InvalidDeltaError{Reason: "truncated data"}
+			}
+			data, err := decoder.DecodeAll(compressed, make([]byte, 0, size))
+			if err != nil || uint64(len(data)) != size {
+				return &InvalidDeltaError{Reason: "bad data"}
+			}
+			if _, err := writer.Write(data); err != nil {
+				return err
+			}
+			written += int64(len(data))
+		default:
+			return &InvalidDeltaError{Reason: "unknown operation"}
+		}
+	}
+}
+
+func writeUvarint(writer *bufio.Writer, value uint64) {
+	buffer := make([]byte, binary.MaxVarintLen64)
+	writer.Write(buffer[:binary.PutUvarint(buffer, value)])
+}
+
+type countingWriter struct {
+	writer  io.Writer
+	written int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.written += int64(n)
+	return n, err
+}
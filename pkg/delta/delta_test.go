@@ -0,0 +1,140 @@
+package delta
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func randomBytes(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func headerFor(base []byte, target []byte) Header {
+	base_sum, target_sum := md5.Sum(base), md5.Sum(target)
+	return Header{Layer: "example", Base: hex.EncodeToString(base_sum[:]), Target: hex.EncodeToString(target_sum[:]), TargetSize: int64(len(target))}
+}
+
+func create(t *testing.T, base []byte, target []byte) ([]byte, Stats) {
+	t.Helper()
+	var out bytes.Buffer
+	stats, err := Create(&out, headerFor(base, target), bytes.NewReader(base), bytes.NewReader(target))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes(), stats
+}
+
+func apply(delta []byte, base []byte) ([]byte, Header, error) {
+	reader := bufio.NewReader(bytes.NewReader(delta))
+	header, err := ReadHeader(reader)
+	if err != nil {
+		return nil, header, err
+	}
+	var out bytes.Buffer
+	err = Apply(reader, header, bytes.NewReader(base), &out)
+	return out.Bytes(), header, err
+}
+
+func TestRoundTrip(t *testing.T) {
+	// Large enough for the chunks around a change to be a small part of it
+	base := randomBytes(1, 4<<20)
+	// Moved data has to be found too, not only data at the same offset
+	shifted := append(randomBytes(2, 1000), base...)
+	modified := bytes.Clone(base)
+	copy(modified[2<<20:], randomBytes(3, 100000))
+
+	tests := []struct {
+		name   string
+		base   []byte
+		target []byte
+		// Share of the target that has to be copied from the base, at least
+		min_copied float64
+	}{
+		{"identical", base, base, 1},
+		{"empty target", base, []byte{}, 0},
+		{"empty base", []byte{}, base, 0},
+		{"both empty", []byte{}, []byte{}, 0},
+		{"shifted", base, shifted, 0.9},
+		{"modified", base, modified, 0.8},
+		{"appended", base, append(bytes.Clone(base), randomBytes(4, 5000)...), 0.9},
+		{"unrelated", base, randomBytes(5, 1<<19), 0},
+		{"compressible", base, bytes.Repeat([]byte("bext "), 100000), 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			delta, stats := create(t, test.base, test.target)
+			if stats.Copied+stats.Added != int64(len(test.target)) {
+				t.Errorf("copied %d and added %d bytes of a %d byte target", stats.Copied, stats.Added, len(test.target))
+			}
+			if stats.Size != int64(len(delta)) {
+				t.Errorf("reported a size of %d for a %d byte delta", stats.Size, len(delta))
+			}
+			if float64(stats.Copied) < test.min_copied*float64(len(test.target)) {
+				t.Errorf("copied %d bytes of a %d byte target, want at least %.0f%%", stats.Copied, len(test.target), test.min_copied*100)
+			}
+
+			result, header, err := apply(delta, test.base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header != headerFor(test.base, test.target) {
+				t.Errorf("got header %+v", header)
+			}
+			if !bytes.Equal(result, test.target) {
+				t.Errorf("reconstructed %d bytes that differ from the %d byte target", len(result), len(test.target))
+			}
+		})
+	}
+}
+
+func TestCreateChecksTarget(t *testing.T) {
+	base, target := randomBytes(1, 100000), randomBytes(2, 100000)
+	header := headerFor(base, target)
+	header.Target = hex.EncodeToString(make([]byte, md5.Size))
+
+	var mismatch *DigestMismatchError
+	_, err := Create(&bytes.Buffer{}, header, bytes.NewReader(base), bytes.NewReader(target))
+	if !errors.As(err, &mismatch) {
+		t.Errorf("got %v, want a DigestMismatchError", err)
+	}
+}
+
+func TestApplyInvalid(t *testing.T) {
+	base := randomBytes(1, 1<<20)
+	target := append(randomBytes(2, 1000), base...)
+	delta, _ := create(t, base, target)
+	other_base := randomBytes(3, 1<<20)
+
+	tests := []struct {
+		name  string
+		delta []byte
+		base  []byte
+		// DigestMismatchError instead of InvalidDeltaError
+		mismatch bool
+	}{
+		{"wrong base", delta, other_base, true},
+		{"short base", delta, base[:1000], false},
+		{"truncated", delta[:len(delta)-1], base, false},
+		{"not a delta", []byte("BEXTIDX1 something else"), base, false},
+		{"empty", []byte{}, base, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := apply(test.delta, test.base)
+			var invalid *InvalidDeltaError
+			var mismatch *DigestMismatchError
+			if test.mismatch && !errors.As(err, &mismatch) {
+				t.Errorf("got %v, want a DigestMismatchError", err)
+			} else if !test.mismatch && !errors.As(err, &invalid) {
+				t.Errorf("got %v, want an InvalidDeltaError", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package delta
+
+import "fmt"
+
+type InvalidDeltaError struct {
+	Reason string
+}
+
+func (e *InvalidDeltaError) Error() string {
+	return fmt.Sprintf("Invalid delta: %s", e.Reason)
+}
+
+// The reconstructed file is not the one the delta was made for, usually because the base is not the one it was made from
+type DigestMismatchError struct {
+	Expected string
+	Actual   string
+}
+
+func (e *DigestMismatchError) Error() string {
+	return fmt.Sprintf("Reconstructed file has digest %s instead of %s", e.Actual, e.Expected)
+}
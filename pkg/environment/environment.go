@@ -0,0 +1,105 @@
+package environment
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// An environment variable that gets extended with directories from mounted layers
+type Variable struct {
+	Name string
+	// Directories that will be appended to the variable
+	Dirs []string
+	// Value assumed when the variable is not set, appending to an unset variable would shadow the system default
+	Default string
+}
+
+// Maps each variable to the subdirectory of a mounted layer that should be appended to it
+var layerSubdirs = []struct {
+	name     string
+	subdir   string
+	fallback string
+}{
+	{name: "PATH", subdir: "bin"},
+	{name: "MANPATH", subdir: "share/man"},
+	{name: "XDG_DATA_DIRS", subdir: "share", fallback: "/usr/local/share:/usr/share"},
+}
+
+var SupportedShells = []string{"bash", "fish", "nu", "sh", "systemd", "zsh"}
+
+// Computes the variables for every layer mounted in mountRoot, skipping directories that do not exist
+func FromLayers(mountRoot string, layers []string) []Variable {
+	var variables []Variable
+	for _, definition := range layerSubdirs {
+		variable := Variable{Name: definition.name, Default: definition.fallback}
+		for _, layer := range layers {
+			layer_dir := path.Join(mountRoot, layer, definition.subdir)
+			if _, err := os.Stat(layer_dir); err != nil {
+				continue
+			}
+			variable.Dirs = append(variable.Dirs, layer_dir)
+		}
+		variables = append(variables, variable)
+	}
+	return variables
+}
+
+// Renders the variables as a snippet that can be evaluated by the target shell
+func Render(shell string, variables []Variable) (string, error) {
+	shell = path.Base(path.Clean(shell))
+	if !slices.Contains(SupportedShells, shell) {
+		return "", &UnsupportedShellError{Shell: shell}
+	}
+
+	var snippet strings.Builder
+	for _, variable := range variables {
+		if len(variable.Dirs) == 0 {
+			continue
+		}
+		joined := strings.Join(variable.Dirs, ":")
+		var quoted_dirs []string
+		for _, dir := range variable.Dirs {
+			quoted_dirs = append(quoted_dirs, Quote(shell, dir))
+		}
+
+		switch shell {
+		case "sh", "bash", "zsh":
+			fmt.Fprintf(&snippet, "export %s=\"${%s:-%s}\":%s\n", variable.Name, variable.Name, variable.Default, Quote(shell, joined))
+		case "fish":
+			fmt.Fprintf(&snippet, "set -q %s; or set -gx %s '%s'\n", variable.Name, variable.Name, variable.Default)
+			// fish treats variables ending in PATH as lists
+			if strings.HasSuffix(variable.Name, "PATH") {
+				fmt.Fprintf(&snippet, "set -gx --append %s %s\n", variable.Name, strings.Join(quoted_dirs, " "))
+				continue
+			}
+			fmt.Fprintf(&snippet, "set -gx %s \"$%s:\"%s\n", variable.Name, variable.Name, Quote(shell, joined))
+		case "nu":
+			// nushell only knows how to convert PATH back from a list by default
+			if variable.Name == "PATH" {
+				fmt.Fprintf(&snippet, "$env.PATH = ($env.PATH | split row (char esep) | append [%s])\n", strings.Join(quoted_dirs, " "))
+				continue
+			}
+			fmt.Fprintf(&snippet, "$env.%s = ($env.%s? | default '%s' | split row (char esep) | append [%s] | str join (char esep))\n", variable.Name, variable.Name, variable.Default, strings.Join(quoted_dirs, " "))
+		case "systemd":
+			// Quotes are only recognized around the whole value, variables are expanded after removing them
+			fmt.Fprintf(&snippet, "%s=%s\n", variable.Name, Quote(shell, "${"+variable.Name+":-"+variable.Default+"}:"+joined))
+		}
+	}
+
+	return snippet.String(), nil
+}
+
+// Quotes a value so the target shell reads it as a single literal word
+func Quote(shell string, value string) string {
+	switch path.Base(path.Clean(shell)) {
+	case "fish":
+		// Only backslashes and single quotes are special inside of single quotes
+		return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(value) + "'"
+	case "nu", "systemd":
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
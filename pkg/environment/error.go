@@ -0,0 +1,11 @@
+package environment
+
+import "fmt"
+
+type UnsupportedShellError struct {
+	Shell string
+}
+
+func (e *UnsupportedShellError) Error() string {
+	return fmt.Sprintf("Unsupported shell: %s", e.Shell)
+}
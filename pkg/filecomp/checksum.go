@@ -32,6 +32,15 @@ func GetFileChecksum(file *os.File, hash hash.Hash) ([]byte, error) {
 	return hash.Sum(nil), nil
 }
 
+// Hashes the reader in chunks instead of loading it whole, for files that can be arbitrarily large
+func StreamChecksum(reader io.Reader, hash hash.Hash) ([]byte, error) {
+	hash.Reset()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
 func CheckExpectedSum(hashing_algo hash.Hash, expectedSum []byte, files ...*os.File) (bool, error) {
 	for _, file := range files {
 		checksum, err := GetFileChecksum(file, hashing_algo)
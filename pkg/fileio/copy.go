@@ -0,0 +1,104 @@
+package fileio
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// How FileCopy copied a file
+type CopyStrategy string
+
+const (
+	// The copy shares its extents with the source (btrfs, XFS)
+	CopyReflink CopyStrategy = "reflink"
+	// The kernel copied the data without passing it through userspace
+	CopyFileRange CopyStrategy = "copy_file_range"
+	CopyStream    CopyStrategy = "stream"
+)
+
+// Copies a file trying a reflink first, then copy_file_range, then a plain read and write
+func FileCopy(sourceFile, destFile string) (CopyStrategy, error) {
+	source, err := os.Open(sourceFile)
+	if err != nil {
+		return "", err
+	}
+	defer source.Close()
+
+	info, err := source.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	dest, err := os.Create(destFile)
+	if err != nil {
+		return "", err
+	}
+	defer dest.Close()
+
+	strategy, err := copyFile(source, dest, info.Size())
+	if err != nil {
+		return "", err
+	}
+	return strategy, dest.Close()
+}
+
+func copyFile(source, dest *os.File, size int64) (CopyStrategy, error) {
+	// Files like those in /proc report no size, only reading them tells
+	if size > 0 {
+		if err := unix.IoctlFileClone(int(dest.Fd()), int(source.Fd())); err == nil {
+			return CopyReflink, nil
+		}
+
+		copied, err := copyFileRange(source, dest, size)
+		if err == nil && copied == size {
+			return CopyFileRange, nil
+		}
+		if err != nil && !isUnsupported(err) {
+			return "", err
+		}
+	}
+
+	// Start over, copy_file_range may have given up halfway through
+	if err := dest.Truncate(0); err != nil {
+		return "", err
+	}
+	if _, err := dest.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if _, err := source.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	// Hiding ReadFrom and WriteTo keeps io.Copy from trying copy_file_range itself
+	if _, err := io.Copy(struct{ io.Writer }{dest}, struct{ io.Reader }{source}); err != nil {
+		return "", err
+	}
+	return CopyStream, nil
+}
+
+func copyFileRange(source, dest *os.File, size int64) (int64, error) {
+	var copied int64
+	for copied < size {
+		n, err := unix.CopyFileRange(int(source.Fd()), nil, int(dest.Fd()), nil, int(min(size-copied, 1<<30)), 0)
+		if err != nil {
+			return copied, err
+		}
+		if n == 0 {
+			break
+		}
+		copied += int64(n)
+	}
+	return copied, nil
+}
+
+// Errors copy_file_range fails with when it can not be used between both files
+func isUnsupported(err error) bool {
+	for _, errno := range []error{unix.ENOSYS, unix.EXDEV, unix.EOPNOTSUPP, unix.EINVAL, unix.EPERM, unix.EBADF} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package fileio
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func randomBytes(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func writeFile(t *testing.T, file_path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(file_path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func copyAndCompare(t *testing.T, source string, dest string, want []byte) CopyStrategy {
+	t.Helper()
+	strategy, err := FileCopy(source, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains([]CopyStrategy{CopyReflink, CopyFileRange, CopyStream}, strategy) {
+		t.Errorf("unknown copy strategy %q", strategy)
+	}
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("copied %d bytes that differ from the %d byte source", len(got), len(want))
+	}
+	return strategy
+}
+
+func TestFileCopy(t *testing.T) {
+	dir := t.TempDir()
+	data := randomBytes(1, 3<<20+17)
+	source := filepath.Join(dir, "source")
+	writeFile(t, source, data)
+
+	strategy := copyAndCompare(t, source, filepath.Join(dir, "dest"), data)
+	t.Logf("copied with %s", strategy)
+}
+
+func TestFileCopyOverwrites(t *testing.T) {
+	dir := t.TempDir()
+	data := randomBytes(2, 1<<20)
+	source := filepath.Join(dir, "source")
+	writeFile(t, source, data)
+
+	// A longer destination must not keep its tail
+	dest := filepath.Join(dir, "dest")
+	writeFile(t, dest, randomBytes(3, 2<<20))
+	copyAndCompare(t, source, dest, data)
+}
+
+func TestFileCopyEmpty(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source")
+	writeFile(t, source, nil)
+
+	dest := filepath.Join(dir, "dest")
+	writeFile(t, dest, randomBytes(4, 4096))
+	if strategy := copyAndCompare(t, source, dest, []byte{}); strategy != CopyStream {
+		t.Errorf("copied an empty file with %s, want %s", strategy, CopyStream)
+	}
+}
+
+func TestFileCopyMissingSource(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := FileCopy(filepath.Join(dir, "missing"), filepath.Join(dir, "dest")); !os.IsNotExist(err) {
+		t.Errorf("got %v for a missing source, want a not exist error", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "dest")); !os.IsNotExist(err) {
+		t.Error("destination was created for a missing source")
+	}
+}
@@ -2,7 +2,6 @@ package fileio
 
 import (
 	"errors"
-	"io"
 	"os"
 )
 
@@ -35,23 +34,3 @@ func FileExist(fileName string) bool {
 	}
 	return false
 }
-
-func FileCopy(sourceFile, destFile string) error {
-	source, err := os.Open(sourceFile)
-	if err != nil {
-		return err
-	}
-	defer source.Close()
-
-	dest, err := os.Create(destFile)
-	if err != nil {
-		return err
-	}
-	defer dest.Close()
-
-	_, err = io.Copy(dest, source)
-	if err != nil {
-		return err
-	}
-	return nil
-}
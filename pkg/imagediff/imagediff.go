@@ -0,0 +1,181 @@
+// Compares the contents of two filesystem images, file by file
+package imagediff
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"sort"
+)
+
+type Kind string
+
+const (
+	KindAdded    Kind = "added"
+	KindRemoved  Kind = "removed"
+	KindModified Kind = "modified"
+)
+
+// What differs about a modified file
+const (
+	DetailType    = "type"
+	DetailMode    = "mode"
+	DetailModTime = "mtime"
+	DetailTarget  = "target"
+	DetailContent = "content"
+)
+
+type Change struct {
+	Path    string   `json:"path"`
+	Kind    Kind     `json:"kind"`
+	Details []string `json:"details,omitempty"`
+	OldSize int64    `json:"old_size"`
+	NewSize int64    `json:"new_size"`
+}
+
+// Filesystems that can tell where symlinks point to, without it targets are not compared
+type ReadlinkFS interface {
+	fs.FS
+	Readlink(name string) (string, error)
+}
+
+// Lists every path that was added, removed or modified going from old to new, sorted by path
+func Compare(old fs.FS, new fs.FS) ([]Change, error) {
+	old_files, err := list(old)
+	if err != nil {
+		return nil, err
+	}
+	new_files, err := list(new)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	for name, old_info := range old_files {
+		new_info, exists := new_files[name]
+		if !exists {
+			changes = append(changes, Change{Path: name, Kind: KindRemoved, OldSize: fileSize(old_info)})
+			continue
+		}
+		details, err := compareFile(old, new, name, old_info, new_info)
+		if err != nil {
+			return nil, err
+		}
+		if len(details) > 0 {
+			changes = append(changes, Change{Path: name, Kind: KindModified, Details: details, OldSize: fileSize(old_info), NewSize: fileSize(new_info)})
+		}
+	}
+	for name, new_info := range new_files {
+		if _, exists := old_files[name]; !exists {
+			changes = append(changes, Change{Path: name, Kind: KindAdded, NewSize: fileSize(new_info)})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+func list(fsys fs.FS) (map[string]fs.FileInfo, error) {
+	files := map[string]fs.FileInfo{}
+	err := fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		files[name] = info
+		return nil
+	})
+	return files, err
+}
+
+// Only regular files have a meaningful size
+func fileSize(info fs.FileInfo) int64 {
+	if !info.Mode().IsRegular() {
+		return 0
+	}
+	return info.Size()
+}
+
+func compareFile(old fs.FS, new fs.FS, name string, old_info fs.FileInfo, new_info fs.FileInfo) ([]string, error) {
+	if old_info.Mode().Type() != new_info.Mode().Type() {
+		return []string{DetailType}, nil
+	}
+
+	var details []string
+	if old_info.Mode() != new_info.Mode() {
+		details = append(details, DetailMode)
+	}
+	if !old_info.ModTime().Equal(new_info.ModTime()) {
+		details = append(details, DetailModTime)
+	}
+
+	switch {
+	case old_info.Mode().Type() == fs.ModeSymlink:
+		old_links, old_ok := old.(ReadlinkFS)
+		new_links, new_ok := new.(ReadlinkFS)
+		if !old_ok || !new_ok {
+			break
+		}
+		old_target, err := old_links.Readlink(name)
+		if err != nil {
+			return nil, err
+		}
+		new_target, err := new_links.Readlink(name)
+		if err != nil {
+			return nil, err
+		}
+		if old_target != new_target {
+			details = append(details, DetailTarget)
+		}
+	case old_info.Mode().IsRegular():
+		same, err := sameContent(old, new, name, old_info.Size(), new_info.Size())
+		if err != nil {
+			return nil, err
+		}
+		if !same {
+			details = append(details, DetailContent)
+		}
+	}
+	return details, nil
+}
+
+const chunkSize = 128 * 1024
+
+func sameContent(old fs.FS, new fs.FS, name string, old_size int64, new_size int64) (bool, error) {
+	if old_size != new_size {
+		return false, nil
+	}
+	old_file, err := old.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer old_file.Close()
+	new_file, err := new.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer new_file.Close()
+
+	old_chunk, new_chunk := make([]byte, chunkSize), make([]byte, chunkSize)
+	for {
+		old_read, old_err := io.ReadFull(old_file, old_chunk)
+		new_read, new_err := io.ReadFull(new_file, new_chunk)
+		if !bytes.Equal(old_chunk[:old_read], new_chunk[:new_read]) {
+			return false, nil
+		}
+		old_done := old_err == io.EOF || old_err == io.ErrUnexpectedEOF
+		new_done := new_err == io.EOF || new_err == io.ErrUnexpectedEOF
+		if old_err != nil && !old_done {
+			return false, old_err
+		}
+		if new_err != nil && !new_done {
+			return false, new_err
+		}
+		if old_done || new_done {
+			return old_done == new_done, nil
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package imagediff
+
+import (
+	"bytes"
+	"io/fs"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// MapFS with the data of symlinks as their target
+type linkFS struct {
+	fstest.MapFS
+}
+
+func (l linkFS) Readlink(name string) (string, error) {
+	file, exists := l.MapFS[name]
+	if !exists {
+		return "", fs.ErrNotExist
+	}
+	if file.Mode.Type() != fs.ModeSymlink {
+		return "", fs.ErrInvalid
+	}
+	return string(file.Data), nil
+}
+
+var (
+	then = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now  = then.Add(time.Hour)
+)
+
+func file(data string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(data), Mode: 0644, ModTime: then}
+}
+
+func link(target string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(target), Mode: fs.ModeSymlink | 0777, ModTime: then}
+}
+
+func dir() *fstest.MapFile {
+	return &fstest.MapFile{Mode: fs.ModeDir | 0755, ModTime: then}
+}
+
+func TestCompare(t *testing.T) {
+	// Larger than the chunks files are compared in, differing only in the last byte
+	large := bytes.Repeat([]byte("a"), chunkSize+10)
+	large_changed := append(bytes.Clone(large[:len(large)-1]), 'b')
+
+	tests := []struct {
+		name    string
+		old     fstest.MapFS
+		new     fstest.MapFS
+		changes []Change
+	}{
+		{"identical", fstest.MapFS{"usr/bin/tool": file("tool")}, fstest.MapFS{"usr/bin/tool": file("tool")}, nil},
+		{"empty", fstest.MapFS{}, fstest.MapFS{}, nil},
+		{
+			"added",
+			fstest.MapFS{"usr/bin/tool": file("tool")},
+			fstest.MapFS{"usr/bin/tool": file("tool"), "usr/bin/other": file("other")},
+			[]Change{{Path: "usr/bin/other", Kind: KindAdded, NewSize: 5}},
+		},
+		{
+			"removed directory",
+			fstest.MapFS{"usr": dir(), "usr/lib": dir(), "usr/lib/libfoo.so": file("library")},
+			fstest.MapFS{"usr": dir()},
+			[]Change{{Path: "usr/lib", Kind: KindRemoved}, {Path: "usr/lib/libfoo.so", Kind: KindRemoved, OldSize: 7}},
+		},
+		{
+			"content",
+			fstest.MapFS{"usr/bin/tool": file("tool")},
+			fstest.MapFS{"usr/bin/tool": file("TOOL")},
+			[]Change{{Path: "usr/bin/tool", Kind: KindModified, Details: []string{DetailContent}, OldSize: 4, NewSize: 4}},
+		},
+		{
+			"size",
+			fstest.MapFS{"usr/bin/tool": file("tool")},
+			fstest.MapFS{"usr/bin/tool": file("tool v2")},
+			[]Change{{Path: "usr/bin/tool", Kind: KindModified, Details: []string{DetailContent}, OldSize: 4, NewSize: 7}},
+		},
+		{
+			"large content",
+			fstest.MapFS{"usr/share/data": {Data: large, Mode: 0644, ModTime: then}},
+			fstest.MapFS{"usr/share/data": {Data: large_changed, Mode: 0644, ModTime: then}},
+			[]Change{{Path: "usr/share/data", Kind: KindModified, Details: []string{DetailContent}, OldSize: int64(len(large)), NewSize: int64(len(large))}},
+		},
+		{
+			"mode and mtime",
+			fstest.MapFS{"usr/bin/tool": file("tool")},
+			fstest.MapFS{"usr/bin/tool": {Data: []byte("tool"), Mode: 0755, ModTime: now}},
+			[]Change{{Path: "usr/bin/tool", Kind: KindModified, Details: []string{DetailMode, DetailModTime}, OldSize: 4, NewSize: 4}},
+		},
+		{
+			"type",
+			fstest.MapFS{"usr/bin/tool": file("tool")},
+			fstest.MapFS{"usr/bin/tool": link("../libexec/tool")},
+			[]Change{{Path: "usr/bin/tool", Kind: KindModified, Details: []string{DetailType}, OldSize: 4}},
+		},
+		{
+			"target",
+			fstest.MapFS{"usr/bin/tool": link("../store/abc/bin/tool")},
+			fstest.MapFS{"usr/bin/tool": link("../store/def/bin/tool")},
+			[]Change{{Path: "usr/bin/tool", Kind: KindModified, Details: []string{DetailTarget}}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changes, err := Compare(linkFS{test.old}, linkFS{test.new})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(changes, test.changes) {
+				t.Errorf("got %+v, want %+v", changes, test.changes)
+			}
+		})
+	}
+}
+
+// Without Readlink only the rest of a symlink is compared
+func TestCompareWithoutReadlink(t *testing.T) {
+	changes, err := Compare(fstest.MapFS{"usr/bin/tool": link("a")}, fstest.MapFS{"usr/bin/tool": link("b")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("got %+v, want no changes", changes)
+	}
+}
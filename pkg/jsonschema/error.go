@@ -0,0 +1,13 @@
+package jsonschema
+
+import "fmt"
+
+// A problem with a single field, Path looks like files[0].path
+type FieldError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
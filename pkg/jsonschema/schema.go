@@ -0,0 +1,130 @@
+package jsonschema
+
+import (
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+// The subset of JSON Schema that Generate produces and Validate understands
+type Schema struct {
+	Schema      string             `json:"$schema,omitempty"`
+	ID          string             `json:"$id,omitempty"`
+	Title       string             `json:"title,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	// Either a *Schema for every other property or false when only Properties are allowed
+	AdditionalProperties any      `json:"additionalProperties,omitempty"`
+	PropertyNames        *Schema  `json:"propertyNames,omitempty"`
+	Items                *Schema  `json:"items,omitempty"`
+	MinItems             *int     `json:"minItems,omitempty"`
+	Pattern              string   `json:"pattern,omitempty"`
+	Enum                 []string `json:"enum,omitempty"`
+	Minimum              *int     `json:"minimum,omitempty"`
+	Maximum              *int     `json:"maximum,omitempty"`
+	// Insertion order of Properties, so errors and documentation follow the struct
+	order []string
+}
+
+// Generates a schema from a struct, reading these tags on its fields besides json:
+//
+//	description:"..."  required:"true"  pattern:"^regex$"  enum:"a|b"  keyPattern:"^regex$"  minItems:"1"  minimum:"1"  maximum:"2"
+//
+// pattern and enum apply to the elements of slices and the values of maps, keyPattern to the keys of maps.
+func Generate(value any, id string, title string) *Schema {
+	schema := forType(reflect.TypeOf(value))
+	schema.Schema = Draft
+	schema.ID = id
+	schema.Title = title
+	return schema
+}
+
+func forType(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: forType(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: forType(t.Elem())}
+	case reflect.Struct:
+		return forStruct(t)
+	}
+	return &Schema{}
+}
+
+func forStruct(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}, AdditionalProperties: false}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := forType(field.Type)
+		property.Description = field.Tag.Get("description")
+		applyConstraints(property, field.Tag)
+
+		schema.Properties[name] = property
+		schema.order = append(schema.order, name)
+		if field.Tag.Get("required") == "true" {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema
+}
+
+func applyConstraints(property *Schema, tag reflect.StructTag) {
+	target := property
+	if property.Items != nil {
+		target = property.Items
+	} else if values, ok := property.AdditionalProperties.(*Schema); ok {
+		target = values
+	}
+
+	target.Pattern = tag.Get("pattern")
+	if enum := tag.Get("enum"); enum != "" {
+		target.Enum = strings.Split(enum, "|")
+	}
+	if key_pattern := tag.Get("keyPattern"); key_pattern != "" {
+		property.PropertyNames = &Schema{Type: "string", Pattern: key_pattern}
+	}
+	property.MinItems = intTag(tag, "minItems")
+	property.Minimum = intTag(tag, "minimum")
+	property.Maximum = intTag(tag, "maximum")
+}
+
+func intTag(tag reflect.StructTag, key string) *int {
+	value, err := strconv.Atoi(tag.Get(key))
+	if err != nil {
+		return nil
+	}
+	return &value
+}
+
+// Names of Properties in the order their fields were declared
+func (s *Schema) PropertyOrder() []string {
+	return slices.Clone(s.order)
+}
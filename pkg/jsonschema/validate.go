@@ -0,0 +1,166 @@
+package jsonschema
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Checks a document decoded from JSON (maps, slices, float64, string, bool and nil) and returns every problem found
+func (s *Schema) Validate(document any) []FieldError {
+	var errors []FieldError
+	s.validate("", document, &errors)
+	return errors
+}
+
+func (s *Schema) validate(field_path string, value any, errors *[]FieldError) {
+	report := func(format string, args ...any) {
+		*errors = append(*errors, FieldError{Path: displayPath(field_path), Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch s.Type {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			report("must be an object, got %s", typeName(value))
+			return
+		}
+		s.validateObject(field_path, object, errors)
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			report("must be an array, got %s", typeName(value))
+			return
+		}
+		if s.MinItems != nil && len(array) < *s.MinItems {
+			if *s.MinItems == 1 {
+				report("must not be empty")
+			} else {
+				report("must have at least %d items", *s.MinItems)
+			}
+		}
+		if s.Items != nil {
+			for i, item := range array {
+				s.Items.validate(fmt.Sprintf("%s[%d]", field_path, i), item, errors)
+			}
+		}
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			report("must be a string, got %s", typeName(value))
+			return
+		}
+		if len(s.Enum) > 0 && !slices.Contains(s.Enum, text) {
+			report("must be one of %s, got %q", strings.Join(quoted(s.Enum), ", "), text)
+		}
+		if s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(text) {
+			report("must match %s, got %q", s.Pattern, text)
+		}
+	case "integer", "number":
+		number, ok := value.(float64)
+		if !ok {
+			report("must be a %s, got %s", s.Type, typeName(value))
+			return
+		}
+		if s.Type == "integer" && number != math.Trunc(number) {
+			report("must be an integer, got %v", number)
+			return
+		}
+		if s.Minimum != nil && number < float64(*s.Minimum) {
+			report("must be at least %d, got %v", *s.Minimum, number)
+		}
+		if s.Maximum != nil && number > float64(*s.Maximum) {
+			report("must be at most %d, got %v", *s.Maximum, number)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			report("must be a boolean, got %s", typeName(value))
+		}
+	}
+}
+
+func (s *Schema) validateObject(field_path string, object map[string]any, errors *[]FieldError) {
+	for _, required := range s.Required {
+		if _, exists := object[required]; !exists {
+			*errors = append(*errors, FieldError{Path: joinPath(field_path, required), Message: "is required"})
+		}
+	}
+
+	// Known properties first in declaration order, then unknown ones sorted, so output is stable
+	for _, name := range s.order {
+		if property, exists := object[name]; exists {
+			s.Properties[name].validate(joinPath(field_path, name), property, errors)
+		}
+	}
+
+	var names []string
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if s.PropertyNames != nil {
+		for _, name := range names {
+			s.PropertyNames.validate(joinPath(field_path, name), name, errors)
+		}
+	}
+
+	var unknown []string
+	for _, name := range names {
+		if _, known := s.Properties[name]; !known {
+			unknown = append(unknown, name)
+		}
+	}
+	for _, name := range unknown {
+		switch additional := s.AdditionalProperties.(type) {
+		case *Schema:
+			additional.validate(joinPath(field_path, name), object[name], errors)
+		case bool:
+			if !additional {
+				*errors = append(*errors, FieldError{Path: joinPath(field_path, name), Message: "is not a known property"})
+			}
+		}
+	}
+}
+
+func joinPath(parent string, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+func displayPath(field_path string) string {
+	if field_path == "" {
+		return "(document)"
+	}
+	return field_path
+}
+
+func typeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func quoted(values []string) []string {
+	var out []string
+	for _, value := range values {
+		out = append(out, fmt.Sprintf("%q", value))
+	}
+	return out
+}
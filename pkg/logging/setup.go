@@ -32,5 +32,5 @@ func SetupAppLogger(writer *os.File, logLevel slog.Leveler, verbose bool) slog.H
 			Level: logLevel,
 		})
 	}
-	return NewUserHandler(&slog.HandlerOptions{Level: logLevel})
+	return NewUserHandler(writer, &slog.HandlerOptions{Level: logLevel})
 }
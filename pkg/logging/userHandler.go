@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"strconv"
 	"sync"
@@ -37,6 +38,7 @@ func colorize(colorCode int, v string) string {
 }
 
 type UserHandler struct {
+	w io.Writer
 	h slog.Handler
 	b *bytes.Buffer
 	m *sync.Mutex
@@ -56,7 +58,7 @@ func (h *UserHandler) Handle(ctx context.Context, r slog.Record) error {
 		level = colorize(lightRed, level)
 	}
 
-	fmt.Println(
+	fmt.Fprintln(h.w,
 		colorize(lightGray, r.Time.Format(timeFormat)),
 		level,
 		colorize(white, r.Message),
@@ -70,11 +72,11 @@ func (h *UserHandler) Enabled(ctx context.Context, level slog.Level) bool {
 }
 
 func (h *UserHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
-	return &UserHandler{h: h.h.WithAttrs(attrs), b: h.b, m: h.m}
+	return &UserHandler{w: h.w, h: h.h.WithAttrs(attrs), b: h.b, m: h.m}
 }
 
 func (h *UserHandler) WithGroup(name string) slog.Handler {
-	return &UserHandler{h: h.h.WithGroup(name), b: h.b, m: h.m}
+	return &UserHandler{w: h.w, h: h.h.WithGroup(name), b: h.b, m: h.m}
 }
 
 func suppressDefaults(
@@ -93,12 +95,13 @@ func suppressDefaults(
 	}
 }
 
-func NewUserHandler(opts *slog.HandlerOptions) *UserHandler {
+func NewUserHandler(writer io.Writer, opts *slog.HandlerOptions) *UserHandler {
 	if opts == nil {
 		opts = &slog.HandlerOptions{}
 	}
 	b := &bytes.Buffer{}
 	return &UserHandler{
+		w: writer,
 		b: b,
 		h: slog.NewJSONHandler(b, &slog.HandlerOptions{
 			Level:       opts.Level,
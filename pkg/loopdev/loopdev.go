@@ -0,0 +1,69 @@
+package loopdev
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+const ControlPath = "/dev/loop-control"
+
+// Another process can take the free device between asking for it and configuring it
+const maxAttachAttempts = 10
+
+type Device struct {
+	Path string
+	file *os.File
+}
+
+// Attaches image read-only to a free loop device. The device is detached automatically once it is
+// closed and nothing (like a mount) is using it anymore, so Close it right after mounting.
+func AttachReadOnly(image string) (*Device, error) {
+	backing_file, err := os.Open(image)
+	if err != nil {
+		return nil, err
+	}
+	defer backing_file.Close()
+
+	control, err := os.OpenFile(ControlPath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer control.Close()
+
+	for attempt := 0; attempt < maxAttachAttempts; attempt++ {
+		index, err := unix.IoctlRetInt(int(control.Fd()), unix.LOOP_CTL_GET_FREE)
+		if err != nil {
+			return nil, err
+		}
+
+		device_path := fmt.Sprintf("/dev/loop%d", index)
+		device_file, err := os.OpenFile(device_path, os.O_RDONLY, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		config := unix.LoopConfig{Fd: uint32(backing_file.Fd())}
+		config.Info.Flags = unix.LO_FLAGS_READ_ONLY | unix.LO_FLAGS_AUTOCLEAR
+		copy(config.Info.File_name[:], image)
+
+		err = unix.IoctlLoopConfigure(int(device_file.Fd()), &config)
+		if errors.Is(err, unix.EBUSY) {
+			device_file.Close()
+			continue
+		} else if err != nil {
+			device_file.Close()
+			return nil, err
+		}
+
+		return &Device{Path: device_path, file: device_file}, nil
+	}
+
+	return nil, fmt.Errorf("could not find a free loop device after %d attempts", maxAttachAttempts)
+}
+
+func (d *Device) Close() error {
+	return d.file.Close()
+}
@@ -0,0 +1,11 @@
+package mountinfo
+
+import "fmt"
+
+type ParseError struct {
+	Line string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("Failed parsing mountinfo line: %s", e.Line)
+}
@@ -0,0 +1,145 @@
+package mountinfo
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const SelfMountInfoPath = "/proc/self/mountinfo"
+
+// A single line from /proc/PID/mountinfo, see proc(5)
+type Mount struct {
+	ID             int
+	ParentID       int
+	MajorMinor     string
+	Root           string
+	MountPoint     string
+	Options        []string
+	OptionalFields []string
+	FSType         string
+	Source         string
+	SuperOptions   []string
+}
+
+func (m *Mount) IsReadOnly() bool {
+	for _, option := range m.Options {
+		if option == "ro" {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads every mount visible to the current process
+func Read() ([]Mount, error) {
+	file, err := os.Open(SelfMountInfoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Parse(file)
+}
+
+func Parse(reader io.Reader) ([]Mount, error) {
+	var mounts []Mount
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		mount, err := parseLine(line)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, mount)
+	}
+
+	return mounts, scanner.Err()
+}
+
+func parseLine(line string) (Mount, error) {
+	fields := strings.Fields(line)
+	separator := -1
+	for i, field := range fields {
+		if field == "-" {
+			separator = i
+			break
+		}
+	}
+	if separator < 6 || len(fields) < separator+3 {
+		return Mount{}, &ParseError{Line: line}
+	}
+
+	id, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return Mount{}, &ParseError{Line: line}
+	}
+	parent_id, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return Mount{}, &ParseError{Line: line}
+	}
+
+	mount := Mount{
+		ID:             id,
+		ParentID:       parent_id,
+		MajorMinor:     fields[2],
+		Root:           unescape(fields[3]),
+		MountPoint:     unescape(fields[4]),
+		Options:        strings.Split(fields[5], ","),
+		OptionalFields: fields[6:separator],
+		FSType:         fields[separator+1],
+		Source:         unescape(fields[separator+2]),
+	}
+	if len(fields) > separator+3 {
+		mount.SuperOptions = strings.Split(fields[separator+3], ",")
+	}
+
+	return mount, nil
+}
+
+// The kernel escapes spaces, tabs, newlines and backslashes as octal sequences
+func unescape(field string) string {
+	if !strings.Contains(field, "\\") {
+		return field
+	}
+
+	var unescaped strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+3 < len(field) {
+			if value, err := strconv.ParseUint(field[i+1:i+4], 8, 8); err == nil {
+				unescaped.WriteByte(byte(value))
+				i += 3
+				continue
+			}
+		}
+		unescaped.WriteByte(field[i])
+	}
+	return unescaped.String()
+}
+
+// Returns the topmost mount on target, since mounts can be stacked on top of each other
+func Lookup(mounts []Mount, target string) (*Mount, bool) {
+	var found *Mount
+	for i := range mounts {
+		if mounts[i].MountPoint == target {
+			found = &mounts[i]
+		}
+	}
+	return found, found != nil
+}
+
+func IsMountPoint(target string) (bool, error) {
+	mounts, err := Read()
+	if err != nil {
+		return false, err
+	}
+	_, found := Lookup(mounts, target)
+	return found, nil
+}
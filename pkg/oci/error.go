@@ -0,0 +1,29 @@
+package oci
+
+import "fmt"
+
+type InvalidReferenceError struct {
+	Reference string
+}
+
+func (e *InvalidReferenceError) Error() string {
+	return fmt.Sprintf("Invalid image reference: %q", e.Reference)
+}
+
+type RegistryError struct {
+	URL    string
+	Status string
+}
+
+func (e *RegistryError) Error() string {
+	return fmt.Sprintf("Registry request %s failed: %s", e.URL, e.Status)
+}
+
+type DigestMismatchError struct {
+	Expected string
+	Actual   string
+}
+
+func (e *DigestMismatchError) Error() string {
+	return fmt.Sprintf("Blob digest %s does not match %s", e.Actual, e.Expected)
+}
@@ -0,0 +1,218 @@
+/*
+A minimal client for pulling manifests and blobs from OCI registries, only with anonymous access.
+*/
+package oci
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	MediaTypeManifest       = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeIndex          = "application/vnd.oci.image.index.v1+json"
+	MediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+	// File name of a layer, set by tools like oras when pushing files
+	AnnotationTitle = "org.opencontainers.image.title"
+)
+
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+}
+
+// Either an image manifest or an index of manifests, depending on its media type
+type Manifest struct {
+	MediaType string       `json:"mediaType"`
+	Layers    []Descriptor `json:"layers"`
+	Manifests []Descriptor `json:"manifests"`
+}
+
+type Client struct {
+	http *http.Client
+	// Bearer tokens by repository
+	tokens map[string]string
+}
+
+func NewClient() *Client {
+	return &Client{
+		http: &http.Client{Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			ResponseHeaderTimeout: 30 * time.Second,
+		}},
+		tokens: map[string]string{},
+	}
+}
+
+// Fetches the image manifest of a reference, picking the one for this machine out of indexes
+func (c *Client) Manifest(ref Reference) (*Manifest, error) {
+	manifest, err := c.manifest(ref)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.MediaType != MediaTypeIndex && manifest.MediaType != MediaTypeDockerList {
+		return manifest, nil
+	}
+	if len(manifest.Manifests) == 0 {
+		return nil, fmt.Errorf("index %s has no manifests", ref)
+	}
+
+	chosen := manifest.Manifests[0]
+	for _, candidate := range manifest.Manifests {
+		if candidate.Platform != nil && candidate.Platform.OS == runtime.GOOS && candidate.Platform.Architecture == runtime.GOARCH {
+			chosen = candidate
+			break
+		}
+	}
+	ref.Reference = chosen.Digest
+	return c.manifest(ref)
+}
+
+func (c *Client) manifest(ref Reference) (*Manifest, error) {
+	response, err := c.get(ref, "manifests/"+ref.Reference, strings.Join([]string{MediaTypeManifest, MediaTypeIndex, MediaTypeDockerManifest, MediaTypeDockerList}, ", "))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	manifest := &Manifest{}
+	if err := json.NewDecoder(response.Body).Decode(manifest); err != nil {
+		return nil, err
+	}
+	if manifest.MediaType == "" {
+		manifest.MediaType = response.Header.Get("Content-Type")
+	}
+	return manifest, nil
+}
+
+// Streams a blob, failing at its end when its content does not match the descriptor's digest
+func (c *Client) Blob(ref Reference, blob Descriptor) (io.ReadCloser, error) {
+	expected, found := strings.CutPrefix(blob.Digest, "sha256:")
+	if !found {
+		return nil, fmt.Errorf("unsupported digest algorithm: %s", blob.Digest)
+	}
+	response, err := c.get(ref, "blobs/"+blob.Digest, "")
+	if err != nil {
+		return nil, err
+	}
+	return &verifier{ReadCloser: response.Body, hash: sha256.New(), expected: expected}, nil
+}
+
+func (c *Client) get(ref Reference, resource string, accept string) (*http.Response, error) {
+	scheme := "https"
+	// Like podman's default for registries on this machine
+	if host := strings.Split(ref.Registry, ":")[0]; host == "localhost" || host == "127.0.0.1" {
+		scheme = "http"
+	}
+	target := fmt.Sprintf("%s://%s/v2/%s/%s", scheme, ref.Registry, ref.Repository, resource)
+
+	request := func() (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodGet, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if token, exists := c.tokens[ref.Registry+"/"+ref.Repository]; exists {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return c.http.Do(req)
+	}
+
+	response, err := request()
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == http.StatusUnauthorized {
+		challenge := response.Header.Get("WWW-Authenticate")
+		response.Body.Close()
+		if err := c.authenticate(ref, challenge); err != nil {
+			return nil, err
+		}
+		if response, err = request(); err != nil {
+			return nil, err
+		}
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, &RegistryError{URL: target, Status: response.Status}
+	}
+	return response, nil
+}
+
+// Gets an anonymous pull token following the registry's Bearer challenge
+func (c *Client) authenticate(ref Reference, challenge string) error {
+	params, found := strings.CutPrefix(challenge, "Bearer ")
+	if !found {
+		return &RegistryError{URL: ref.String(), Status: "unsupported authentication: " + challenge}
+	}
+	values := map[string]string{}
+	for _, param := range strings.Split(params, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		values[key] = strings.Trim(value, `"`)
+	}
+
+	query := url.Values{}
+	if service := values["service"]; service != "" {
+		query.Set("service", service)
+	}
+	query.Set("scope", "repository:"+ref.Repository+":pull")
+	response, err := c.http.Get(values["realm"] + "?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return &RegistryError{URL: values["realm"], Status: response.Status}
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&token); err != nil {
+		return err
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	c.tokens[ref.Registry+"/"+ref.Repository] = token.Token
+	return nil
+}
+
+type verifier struct {
+	io.ReadCloser
+	hash     hash.Hash
+	expected string
+}
+
+func (v *verifier) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF {
+		if actual := hex.EncodeToString(v.hash.Sum(nil)); actual != v.expected {
+			return n, &DigestMismatchError{Expected: "sha256:" + v.expected, Actual: "sha256:" + actual}
+		}
+	}
+	return n, err
+}
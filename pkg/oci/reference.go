@@ -0,0 +1,59 @@
+package oci
+
+import (
+	"strings"
+)
+
+const (
+	defaultRegistry = "registry-1.docker.io"
+	defaultTag      = "latest"
+)
+
+// Image reference like ghcr.io/ublue-os/layers:example or registry/repo@sha256:...
+type Reference struct {
+	Registry   string
+	Repository string
+	// Tag or digest
+	Reference string
+}
+
+func (r Reference) String() string {
+	separator := ":"
+	if strings.Contains(r.Reference, ":") {
+		separator = "@"
+	}
+	return r.Registry + "/" + r.Repository + separator + r.Reference
+}
+
+// Parses references the way podman does, defaulting to Docker Hub and the latest tag
+func ParseReference(ref string) (Reference, error) {
+	ref = strings.TrimPrefix(ref, "docker://")
+	if ref == "" {
+		return Reference{}, &InvalidReferenceError{Reference: ref}
+	}
+
+	parsed := Reference{Registry: defaultRegistry}
+	name := ref
+	if before, digest, found := strings.Cut(ref, "@"); found {
+		name, parsed.Reference = before, digest
+	} else if slash := strings.LastIndex(ref, "/"); strings.LastIndex(ref, ":") > slash {
+		colon := strings.LastIndex(ref, ":")
+		name, parsed.Reference = ref[:colon], ref[colon+1:]
+	}
+	if parsed.Reference == "" {
+		parsed.Reference = defaultTag
+	}
+
+	// The first component is a registry when it looks like a host
+	if first, rest, found := strings.Cut(name, "/"); found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		parsed.Registry, name = first, rest
+	}
+	if parsed.Registry == defaultRegistry && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	if name == "" {
+		return Reference{}, &InvalidReferenceError{Reference: ref}
+	}
+	parsed.Repository = name
+	return parsed, nil
+}
@@ -0,0 +1,16 @@
+package osrelease
+
+import "fmt"
+
+type IncompatibleError struct {
+	Field string
+	Got   string
+	Want  string
+}
+
+func (e *IncompatibleError) Error() string {
+	if e.Got == "" {
+		return fmt.Sprintf("Extension has no %s, host has %q", e.Field, e.Want)
+	}
+	return fmt.Sprintf("Extension %s %q does not match host %q", e.Field, e.Got, e.Want)
+}
@@ -0,0 +1,110 @@
+package osrelease
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+var HostPaths = []string{"/etc/os-release", "/usr/lib/os-release"}
+
+// Key/value pairs from an os-release or extension-release file, see os-release(5)
+type Release map[string]string
+
+func Parse(reader io.Reader) (Release, error) {
+	release := Release{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `'"`)
+		}
+		release[key] = value
+	}
+	return release, scanner.Err()
+}
+
+func Read(release_path string) (Release, error) {
+	file, err := os.Open(release_path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Parse(file)
+}
+
+// Reads the first os-release file that exists on the host
+func ReadHost() (Release, error) {
+	var err error
+	for _, host_path := range HostPaths {
+		var release Release
+		release, err = Read(host_path)
+		if err == nil {
+			return release, nil
+		}
+	}
+	return nil, err
+}
+
+// Same rules systemd-sysext uses before merging an extension
+func Compatible(host Release, extension Release) error {
+	extension_id := extension["ID"]
+	if extension_id == "" {
+		return &IncompatibleError{Field: "ID", Want: host["ID"]}
+	}
+
+	if architecture := extension["ARCHITECTURE"]; architecture != "" && architecture != "_any" && architecture != HostArchitecture() {
+		return &IncompatibleError{Field: "ARCHITECTURE", Got: architecture, Want: HostArchitecture()}
+	}
+
+	if extension_id == "_any" {
+		return nil
+	}
+	if extension_id != host["ID"] {
+		return &IncompatibleError{Field: "ID", Got: extension_id, Want: host["ID"]}
+	}
+
+	if level := extension["SYSEXT_LEVEL"]; level != "" {
+		if level != host["SYSEXT_LEVEL"] {
+			return &IncompatibleError{Field: "SYSEXT_LEVEL", Got: level, Want: host["SYSEXT_LEVEL"]}
+		}
+		return nil
+	}
+	if version := extension["VERSION_ID"]; version != "" && version != host["VERSION_ID"] {
+		return &IncompatibleError{Field: "VERSION_ID", Got: version, Want: host["VERSION_ID"]}
+	}
+	return nil
+}
+
+// Architecture names as used by systemd, which differ from Go's
+func HostArchitecture() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86-64"
+	case "386":
+		return "x86"
+	case "arm64":
+		return "arm64"
+	case "arm":
+		return "arm"
+	case "ppc64le":
+		return "ppc64-le"
+	case "riscv64":
+		return "riscv64"
+	case "s390x":
+		return "s390x"
+	}
+	return runtime.GOARCH
+}
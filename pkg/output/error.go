@@ -0,0 +1,14 @@
+package output
+
+import (
+	"fmt"
+	"strings"
+)
+
+type InvalidFormatError struct {
+	Format string
+}
+
+func (e *InvalidFormatError) Error() string {
+	return fmt.Sprintf("Invalid output format: %s (valid formats: %s)", e.Format, strings.Join(ValidFormats, ", "))
+}
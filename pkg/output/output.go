@@ -0,0 +1,105 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/ublue-os/bext/pkg/structures"
+)
+
+type Format string
+
+const (
+	FormatTable Format = "table"
+	FormatJSON  Format = "json"
+	FormatYAML  Format = "yaml"
+	FormatTSV   Format = "tsv"
+)
+
+var ValidFormats = []string{string(FormatTable), string(FormatJSON), string(FormatYAML), string(FormatTSV)}
+
+func ParseFormat(format string) (Format, error) {
+	if !slices.Contains(ValidFormats, format) {
+		return "", &InvalidFormatError{Format: format}
+	}
+	return Format(format), nil
+}
+
+// Whether the format is meant to be read by programs instead of humans
+func (f Format) IsMachineReadable() bool {
+	return f != FormatTable
+}
+
+// Human-readable (and tsv) view of a result
+type Table struct {
+	Title  string
+	Header []string
+	Rows   [][]string
+}
+
+// Writes data as json or yaml, or the table as a rendered table or tsv
+func Write(writer io.Writer, format Format, data any, view Table) error {
+	switch format {
+	case FormatJSON:
+		out, err := json.MarshalIndent(data, "", structures.INDENTATION)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(writer, "%s\n", out)
+		return err
+	case FormatYAML:
+		json_data, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		// Going through json keeps the same field names in both formats
+		var generic any
+		out, err := structures.JsonToYaml(json_data, &generic)
+		if err != nil {
+			return err
+		}
+		_, err = writer.Write(out)
+		return err
+	case FormatTSV:
+		if len(view.Header) > 0 {
+			if _, err := fmt.Fprintln(writer, strings.Join(view.Header, "\t")); err != nil {
+				return err
+			}
+		}
+		for _, row := range view.Rows {
+			cleaned := make([]string, 0, len(row))
+			for _, cell := range row {
+				cleaned = append(cleaned, strings.NewReplacer("\t", " ", "\n", ",").Replace(cell))
+			}
+			if _, err := fmt.Fprintln(writer, strings.Join(cleaned, "\t")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	t := table.NewWriter()
+	t.SetStyle(table.StyleRounded)
+	t.SetTitle(view.Title)
+	t.Style().Options.SeparateRows = true
+	if len(view.Header) > 0 {
+		header := table.Row{}
+		for _, column := range view.Header {
+			header = append(header, column)
+		}
+		t.AppendHeader(header)
+	}
+	for _, row := range view.Rows {
+		table_row := table.Row{}
+		for _, cell := range row {
+			table_row = append(table_row, cell)
+		}
+		t.AppendRow(table_row)
+	}
+	_, err := fmt.Fprintf(writer, "%s\n", t.Render())
+	return err
+}
@@ -0,0 +1,12 @@
+package retention
+
+import "fmt"
+
+type ParseError struct {
+	Kind  string
+	Value string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("Invalid %s: %s", e.Kind, e.Value)
+}
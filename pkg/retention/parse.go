@@ -0,0 +1,108 @@
+package retention
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30}, {"TIB", 1 << 40},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000}, {"TB", 1000 * 1000 * 1000 * 1000},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"B", 1},
+}
+
+// Parses sizes like "512M", "10GiB" or "1000000", single letter units are binary like in du
+func ParseSize(value string) (int64, error) {
+	upper := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper = strings.TrimSuffix(upper, unit.suffix)
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(strings.TrimSpace(upper), 64)
+	if err != nil || number < 0 || math.IsNaN(number) || math.IsInf(number, 0) {
+		return 0, &ParseError{Kind: "size", Value: value}
+	}
+	// float64(math.MaxInt64) rounds up to 2^63, which no longer fits
+	size := number * float64(multiplier)
+	if size >= math.MaxInt64 {
+		return 0, &ParseError{Kind: "size", Value: value}
+	}
+	return int64(size), nil
+}
+
+// Same as time.ParseDuration, with support for days ("7d") and weeks ("2w")
+func ParseAge(value string) (time.Duration, error) {
+	trimmed := strings.TrimSpace(value)
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if number, found := strings.CutSuffix(trimmed, suffix); found {
+			count, err := strconv.ParseFloat(number, 64)
+			if err != nil || count < 0 || math.IsNaN(count) || math.IsInf(count, 0) || count*float64(unit) >= math.MaxInt64 {
+				return 0, &ParseError{Kind: "age", Value: value}
+			}
+			return time.Duration(count * float64(unit)), nil
+		}
+	}
+
+	duration, err := time.ParseDuration(trimmed)
+	if err != nil || duration < 0 {
+		return 0, &ParseError{Kind: "age", Value: value}
+	}
+	return duration, nil
+}
+
+// Parses "LAYER:keep-last=N,keep-newer-than=AGE", unspecified rules are inherited from base
+func ParseLayerPolicy(value string, base Policy) (string, Policy, error) {
+	layer, rules, found := strings.Cut(value, ":")
+	if !found || layer == "" {
+		return "", Policy{}, &ParseError{Kind: "layer policy", Value: value}
+	}
+	policy, err := ParsePolicy(rules, base)
+	if err != nil {
+		return "", Policy{}, err
+	}
+	return layer, policy, nil
+}
+
+// Parses "keep-last=N,keep-newer-than=AGE", unspecified rules are inherited from base
+func ParsePolicy(rules string, base Policy) (Policy, error) {
+	policy := base
+	for _, rule := range strings.Split(rules, ",") {
+		key, rule_value, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch key {
+		case "keep-last":
+			keep_last, err := ParseKeepLast(rule_value)
+			if err != nil {
+				return Policy{}, err
+			}
+			policy.KeepLast = keep_last
+		case "keep-newer-than":
+			age, err := ParseAge(rule_value)
+			if err != nil {
+				return Policy{}, err
+			}
+			policy.KeepNewerThan = age
+		default:
+			return Policy{}, &ParseError{Kind: "layer policy rule", Value: rule}
+		}
+	}
+	return policy, nil
+}
+
+func ParseKeepLast(value string) (int, error) {
+	keep_last, err := strconv.Atoi(value)
+	if err != nil || keep_last < 0 {
+		return 0, &ParseError{Kind: "keep-last", Value: value}
+	}
+	return keep_last, nil
+}
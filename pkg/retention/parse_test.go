@@ -0,0 +1,138 @@
+package retention
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		want  int64
+	}{
+		{"0", 0},
+		{"1000000", 1000000},
+		{"512B", 512},
+		{"1K", 1 << 10},
+		{"512M", 512 << 20},
+		{"10g", 10 << 30},
+		{"1.5G", 3 << 29},
+		{"2T", 2 << 40},
+		{"8388607T", 8388607 << 40},
+		{"1KiB", 1 << 10},
+		{"10GiB", 10 << 30},
+		{"1kb", 1000},
+		{"5MB", 5 * 1000 * 1000},
+		{" 3 GB ", 3 * 1000 * 1000 * 1000},
+	} {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := ParseSize(test.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseSizeInvalid(t *testing.T) {
+	for _, value := range []string{"", "M", "-1", "-5G", "ten", "10X", "1G2", "inf", "NaN", "8388608T", "9223372036854775808", "1e30"} {
+		t.Run(value, func(t *testing.T) {
+			var parse_err *ParseError
+			if _, err := ParseSize(value); !errors.As(err, &parse_err) {
+				t.Errorf("got %v, want a ParseError", err)
+			}
+		})
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		want  time.Duration
+	}{
+		{"0", 0},
+		{"0d", 0},
+		{"90s", 90 * time.Second},
+		{"12h", 12 * time.Hour},
+		{"1h30m", 90 * time.Minute},
+		{"7d", 7 * 24 * time.Hour},
+		{"1.5d", 36 * time.Hour},
+		{"2w", 14 * 24 * time.Hour},
+		{" 30d ", 30 * 24 * time.Hour},
+	} {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := ParseAge(test.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseAgeInvalid(t *testing.T) {
+	for _, value := range []string{"", "d", "-1h", "-7d", "7", "week", "7x", "infd", "NaNw", "106752d", "15251w", "1e30d", "9999999999h"} {
+		t.Run(value, func(t *testing.T) {
+			var parse_err *ParseError
+			if _, err := ParseAge(value); !errors.As(err, &parse_err) {
+				t.Errorf("got %v, want a ParseError", err)
+			}
+		})
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	base := Policy{KeepLast: 2, KeepNewerThan: time.Hour}
+	for _, test := range []struct {
+		rules string
+		want  Policy
+	}{
+		{"keep-last=5", Policy{KeepLast: 5, KeepNewerThan: time.Hour}},
+		{"keep-newer-than=30d", Policy{KeepLast: 2, KeepNewerThan: 30 * 24 * time.Hour}},
+		{"keep-last=0, keep-newer-than=0", Policy{}},
+	} {
+		t.Run(test.rules, func(t *testing.T) {
+			got, err := ParsePolicy(test.rules, base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+
+	for _, rules := range []string{"", "keep-last", "keep-last=-1", "keep-last=many", "keep-first=1", "keep-newer-than=soon"} {
+		t.Run(rules, func(t *testing.T) {
+			var parse_err *ParseError
+			if _, err := ParsePolicy(rules, base); !errors.As(err, &parse_err) {
+				t.Errorf("got %v, want a ParseError", err)
+			}
+		})
+	}
+}
+
+func TestParseLayerPolicy(t *testing.T) {
+	layer, policy, err := ParseLayerPolicy("firefox:keep-last=5,keep-newer-than=2w", Policy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Policy{KeepLast: 5, KeepNewerThan: 14 * 24 * time.Hour}); layer != "firefox" || policy != want {
+		t.Errorf("got %s %+v, want firefox %+v", layer, policy, want)
+	}
+
+	for _, value := range []string{"keep-last=5", ":keep-last=5", "firefox:"} {
+		t.Run(value, func(t *testing.T) {
+			var parse_err *ParseError
+			if _, _, err := ParseLayerPolicy(value, Policy{}); !errors.As(err, &parse_err) {
+				t.Errorf("got %v, want a ParseError", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package retention
+
+import (
+	"slices"
+	"time"
+)
+
+// How many blobs to keep for a layer, zero values disable the rule
+type Policy struct {
+	KeepLast      int
+	KeepNewerThan time.Duration
+}
+
+type Blob struct {
+	Layer   string
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+type Decision struct {
+	Blob   Blob
+	Delete bool
+	Reason string
+}
+
+type Engine struct {
+	Global Policy
+	// Overrides the global policy for specific layers
+	Layers map[string]Policy
+	// Evicts the oldest blobs not otherwise protected until the cache fits, zero means unlimited
+	MaxCacheSize int64
+	// Blobs that must never be deleted, mapped to why (current blob, activated, pinned...)
+	Protected map[string]string
+	Now       time.Time
+}
+
+func NewEngine(global Policy) *Engine {
+	return &Engine{
+		Global:    global,
+		Layers:    map[string]Policy{},
+		Protected: map[string]string{},
+		Now:       time.Now(),
+	}
+}
+
+func (e *Engine) Protect(path string, reason string) {
+	if _, exists := e.Protected[path]; !exists {
+		e.Protected[path] = reason
+	}
+}
+
+func (e *Engine) policyFor(layer string) Policy {
+	if policy, exists := e.Layers[layer]; exists {
+		return policy
+	}
+	return e.Global
+}
+
+// Decides what happens to every blob, newest blobs come first for each layer
+func (e *Engine) Evaluate(blobs []Blob) []Decision {
+	sorted := slices.Clone(blobs)
+	slices.SortStableFunc(sorted, func(a, b Blob) int {
+		if a.Layer != b.Layer {
+			if a.Layer < b.Layer {
+				return -1
+			}
+			return 1
+		}
+		return b.ModTime.Compare(a.ModTime)
+	})
+
+	decisions := make([]Decision, 0, len(sorted))
+	seen_per_layer := map[string]int{}
+	for _, blob := range sorted {
+		policy := e.policyFor(blob.Layer)
+		seen_per_layer[blob.Layer]++
+
+		decision := Decision{Blob: blob, Delete: true, Reason: "unused"}
+		if reason, protected := e.Protected[blob.Path]; protected {
+			decision = Decision{Blob: blob, Reason: reason}
+		} else if policy.KeepLast > 0 && seen_per_layer[blob.Layer] <= policy.KeepLast {
+			decision = Decision{Blob: blob, Reason: "within last versions"}
+		} else if policy.KeepNewerThan > 0 && e.Now.Sub(blob.ModTime) < policy.KeepNewerThan {
+			decision = Decision{Blob: blob, Reason: "newer than age limit"}
+		}
+		decisions = append(decisions, decision)
+	}
+
+	if e.MaxCacheSize > 0 {
+		e.enforceMaxSize(decisions)
+	}
+	return decisions
+}
+
+// Evicts kept but unprotected blobs, oldest first, until the kept size fits the cap
+func (e *Engine) enforceMaxSize(decisions []Decision) {
+	var kept_size int64
+	var evictable []int
+	for i, decision := range decisions {
+		if decision.Delete {
+			continue
+		}
+		kept_size += decision.Blob.Size
+		if _, protected := e.Protected[decision.Blob.Path]; !protected {
+			evictable = append(evictable, i)
+		}
+	}
+
+	slices.SortStableFunc(evictable, func(a, b int) int {
+		return decisions[a].Blob.ModTime.Compare(decisions[b].Blob.ModTime)
+	})
+
+	for _, i := range evictable {
+		if kept_size <= e.MaxCacheSize {
+			return
+		}
+		decisions[i].Delete = true
+		decisions[i].Reason = "over cache size limit"
+		kept_size -= decisions[i].Blob.Size
+	}
+}
@@ -0,0 +1,194 @@
+package retention
+
+import (
+	"testing"
+	"time"
+)
+
+var now = time.Date(2026, 1, 31, 12, 0, 0, 0, time.UTC)
+
+func blob(layer string, name string, size int64, age time.Duration) Blob {
+	return Blob{Layer: layer, Path: "/cache/" + layer + "/" + name, Size: size, ModTime: now.Add(-age)}
+}
+
+func newTestEngine(global Policy) *Engine {
+	engine := NewEngine(global)
+	engine.Now = now
+	return engine
+}
+
+// Paths of the blobs decided to be deleted
+func deleted(decisions []Decision) map[string]bool {
+	paths := map[string]bool{}
+	for _, decision := range decisions {
+		if decision.Delete {
+			paths[decision.Blob.Path] = true
+		}
+	}
+	return paths
+}
+
+func assertDeleted(t *testing.T, decisions []Decision, want ...string) {
+	t.Helper()
+	got := deleted(decisions)
+	if len(got) != len(want) {
+		t.Errorf("deleted %v, want %v", got, want)
+		return
+	}
+	for _, path := range want {
+		if !got[path] {
+			t.Errorf("deleted %v, want %v", got, want)
+			return
+		}
+	}
+}
+
+const day = 24 * time.Hour
+
+func TestEvaluate(t *testing.T) {
+	blobs := []Blob{
+		blob("a", "oldest", 10, 30*day),
+		blob("a", "newest", 10, time.Hour),
+		blob("a", "older", 10, 10*day),
+		blob("a", "newer", 10, 2*day),
+		blob("b", "only", 10, 60*day),
+	}
+
+	for _, test := range []struct {
+		name   string
+		global Policy
+		layers map[string]Policy
+		want   []string
+	}{
+		{
+			name: "no policy",
+			want: []string{"/cache/a/oldest", "/cache/a/older", "/cache/a/newer", "/cache/a/newest", "/cache/b/only"},
+		},
+		{
+			name:   "keep last",
+			global: Policy{KeepLast: 2},
+			want:   []string{"/cache/a/oldest", "/cache/a/older"},
+		},
+		{
+			name:   "keep newer than",
+			global: Policy{KeepNewerThan: 7 * day},
+			want:   []string{"/cache/a/oldest", "/cache/a/older", "/cache/b/only"},
+		},
+		{
+			name:   "either rule keeps",
+			global: Policy{KeepLast: 1, KeepNewerThan: 14 * day},
+			want:   []string{"/cache/a/oldest"},
+		},
+		{
+			name:   "layer override",
+			global: Policy{KeepLast: 1},
+			layers: map[string]Policy{"a": {KeepLast: 3}},
+			want:   []string{"/cache/a/oldest"},
+		},
+		{
+			name:   "layer override disables the global policy",
+			global: Policy{KeepLast: 5},
+			layers: map[string]Policy{"b": {}},
+			want:   []string{"/cache/b/only"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			engine := newTestEngine(test.global)
+			for layer, policy := range test.layers {
+				engine.Layers[layer] = policy
+			}
+			assertDeleted(t, engine.Evaluate(blobs), test.want...)
+		})
+	}
+}
+
+func TestEvaluateOrder(t *testing.T) {
+	engine := newTestEngine(Policy{})
+	decisions := engine.Evaluate([]Blob{
+		blob("b", "old", 1, 2*day),
+		blob("a", "old", 1, 2*day),
+		blob("b", "new", 1, day),
+		blob("a", "new", 1, day),
+	})
+	want := []string{"/cache/a/new", "/cache/a/old", "/cache/b/new", "/cache/b/old"}
+	for i, decision := range decisions {
+		if decision.Blob.Path != want[i] {
+			t.Errorf("decision %d is for %s, want %s", i, decision.Blob.Path, want[i])
+		}
+	}
+}
+
+func TestEvaluateMaxSize(t *testing.T) {
+	blobs := []Blob{
+		blob("a", "new", 100, day),
+		blob("a", "old", 100, 5*day),
+		blob("b", "new", 100, 2*day),
+		blob("b", "old", 100, 4*day),
+	}
+
+	for _, test := range []struct {
+		name     string
+		max_size int64
+		want     []string
+	}{
+		{name: "fits", max_size: 400},
+		{name: "oldest first across layers", max_size: 300, want: []string{"/cache/a/old"}},
+		{name: "until it fits", max_size: 150, want: []string{"/cache/a/old", "/cache/b/old", "/cache/b/new"}},
+		{name: "everything", max_size: 1, want: []string{"/cache/a/old", "/cache/b/old", "/cache/b/new", "/cache/a/new"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			engine := newTestEngine(Policy{KeepLast: 2})
+			engine.MaxCacheSize = test.max_size
+			decisions := engine.Evaluate(blobs)
+			assertDeleted(t, decisions, test.want...)
+			for _, decision := range decisions {
+				if decision.Delete && decision.Reason != "over cache size limit" {
+					t.Errorf("%s deleted because %s", decision.Blob.Path, decision.Reason)
+				}
+			}
+		})
+	}
+}
+
+func TestEvaluateProtected(t *testing.T) {
+	blobs := []Blob{
+		blob("a", "current", 100, 90*day),
+		blob("a", "pinned", 100, 60*day),
+		blob("a", "other", 100, 30*day),
+		blob("b", "staged", 100, 120*day),
+	}
+
+	for _, test := range []struct {
+		name     string
+		global   Policy
+		max_size int64
+		want     []string
+	}{
+		{name: "no policy", want: []string{"/cache/a/other"}},
+		// Protected blobs count towards the last versions kept
+		{name: "keep last", global: Policy{KeepLast: 1}},
+		{name: "keep newer than", global: Policy{KeepNewerThan: time.Hour}, want: []string{"/cache/a/other"}},
+		{name: "max size", max_size: 1, want: []string{"/cache/a/other"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			engine := newTestEngine(test.global)
+			engine.MaxCacheSize = test.max_size
+			engine.Protect("/cache/a/current", "current blob")
+			engine.Protect("/cache/a/pinned", "pinned")
+			engine.Protect("/cache/b/staged", "staged")
+			// The first reason given is kept
+			engine.Protect("/cache/a/pinned", "excluded")
+
+			decisions := engine.Evaluate(blobs)
+			assertDeleted(t, decisions, test.want...)
+			for _, decision := range decisions {
+				if want, protected := engine.Protected[decision.Blob.Path]; protected && decision.Reason != want {
+					t.Errorf("%s kept because %s, want %s", decision.Blob.Path, decision.Reason, want)
+				}
+			}
+			if engine.Protected["/cache/a/pinned"] != "pinned" {
+				t.Errorf("protecting again replaced the reason with %s", engine.Protected["/cache/a/pinned"])
+			}
+		})
+	}
+}
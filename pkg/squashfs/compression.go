@@ -0,0 +1,71 @@
+package squashfs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+const (
+	compressionGzip uint16 = 1
+	compressionLzma uint16 = 2
+	compressionLzo  uint16 = 3
+	compressionXz   uint16 = 4
+	compressionLz4  uint16 = 5
+	compressionZstd uint16 = 6
+)
+
+var compressionNames = map[uint16]string{
+	compressionGzip: "gzip",
+	compressionLzma: "lzma",
+	compressionLzo:  "lzo",
+	compressionXz:   "xz",
+	compressionLz4:  "lz4",
+	compressionZstd: "zstd",
+}
+
+type decompressor func(data []byte) ([]byte, error)
+
+func newDecompressor(id uint16) (decompressor, error) {
+	switch id {
+	case compressionGzip:
+		return func(data []byte) ([]byte, error) {
+			reader, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			defer reader.Close()
+			return io.ReadAll(reader)
+		}, nil
+	case compressionLzma:
+		return func(data []byte) ([]byte, error) {
+			reader, err := lzma.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			return io.ReadAll(reader)
+		}, nil
+	case compressionXz:
+		return func(data []byte) ([]byte, error) {
+			reader, err := xz.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			return io.ReadAll(reader)
+		}, nil
+	case compressionZstd:
+		// DecodeAll can be used by several goroutines at once
+		decoder, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		return func(data []byte) ([]byte, error) {
+			return decoder.DecodeAll(data, nil)
+		}, nil
+	}
+	return nil, &UnsupportedCompressionError{ID: id}
+}
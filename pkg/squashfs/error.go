@@ -0,0 +1,23 @@
+package squashfs
+
+import "fmt"
+
+type InvalidImageError struct {
+	Reason string
+}
+
+func (e *InvalidImageError) Error() string {
+	return fmt.Sprintf("Invalid squashfs image: %s", e.Reason)
+}
+
+type UnsupportedCompressionError struct {
+	ID uint16
+}
+
+func (e *UnsupportedCompressionError) Error() string {
+	name, known := compressionNames[e.ID]
+	if !known {
+		name = fmt.Sprintf("unknown (%d)", e.ID)
+	}
+	return fmt.Sprintf("Unsupported squashfs compression: %s", name)
+}
@@ -0,0 +1,316 @@
+package squashfs
+
+import (
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Symlinks are never followed, Open and Stat return the link itself
+var (
+	_ fs.FS        = (*Image)(nil)
+	_ fs.ReadDirFS = (*Image)(nil)
+	_ fs.StatFS    = (*Image)(nil)
+)
+
+// Inodes of entries are only read when needed, so that looking up a path does not read every inode of every directory on the way
+type dirEntry struct {
+	name  string
+	ref   uint64
+	kind  uint16
+	image *Image
+}
+
+func (e *dirEntry) Name() string { return e.name }
+func (e *dirEntry) IsDir() bool  { return e.kind == typeDir }
+
+func (e *dirEntry) Type() fs.FileMode {
+	switch e.kind {
+	case typeDir:
+		return fs.ModeDir
+	case typeSymlink:
+		return fs.ModeSymlink
+	case typeBlockDev:
+		return fs.ModeDevice
+	case typeCharDev:
+		return fs.ModeDevice | fs.ModeCharDevice
+	case typeFifo:
+		return fs.ModeNamedPipe
+	case typeSocket:
+		return fs.ModeSocket
+	}
+	return 0
+}
+
+func (e *dirEntry) Info() (fs.FileInfo, error) {
+	node, err := e.image.readInode(e.ref)
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{name: e.name, node: node}, nil
+}
+
+type fileInfo struct {
+	name string
+	node *inode
+}
+
+func (i *fileInfo) Name() string       { return i.name }
+func (i *fileInfo) Size() int64        { return int64(i.node.size) }
+func (i *fileInfo) Mode() fs.FileMode  { return i.node.mode }
+func (i *fileInfo) ModTime() time.Time { return i.node.modTime() }
+func (i *fileInfo) IsDir() bool        { return i.node.isDir() }
+
+// Owner of the file, as *Owner
+func (i *fileInfo) Sys() any { return &Owner{UID: i.node.uid, GID: i.node.gid} }
+
+type Owner struct {
+	UID uint32
+	GID uint32
+}
+
+// Directory listings are made of headers, each followed by entries sharing an inode metadata block
+func (img *Image) readDir(node *inode) ([]*dirEntry, error) {
+	// The size includes the . and .. entries, which are not stored
+	if node.dir_size <= 3 {
+		return nil, nil
+	}
+	reader, err := img.newMetadataReader(img.super.DirectoryTableStart+uint64(node.dir_block), int(node.dir_offset))
+	if err != nil {
+		return nil, err
+	}
+	limited := &io.LimitedReader{R: reader, N: int64(node.dir_size) - 3}
+
+	var entries []*dirEntry
+	for limited.N > 0 {
+		var header struct {
+			Count       uint32
+			Start       uint32
+			InodeNumber int32
+		}
+		if err := binary.Read(limited, binary.LittleEndian, &header); err != nil {
+			return nil, &InvalidImageError{Reason: "could not read directory header: " + err.Error()}
+		}
+		for i := uint32(0); i <= header.Count; i++ {
+			var entry struct {
+				Offset      uint16
+				InodeOffset int16
+				Type        uint16
+				NameSize    uint16
+			}
+			if err := binary.Read(limited, binary.LittleEndian, &entry); err != nil {
+				return nil, &InvalidImageError{Reason: "could not read directory entry: " + err.Error()}
+			}
+			name := make([]byte, int(entry.NameSize)+1)
+			if _, err := io.ReadFull(limited, name); err != nil {
+				return nil, &InvalidImageError{Reason: "could not read directory entry: " + err.Error()}
+			}
+			// Entries always have the basic type, even when their inode is an extended one
+			ref := uint64(header.Start)<<16 | uint64(entry.Offset)
+			entries = append(entries, &dirEntry{name: string(name), ref: ref, kind: entry.Type, image: img})
+		}
+	}
+	return entries, nil
+}
+
+func (img *Image) lookup(op string, name string) (*inode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	node := img.root
+	if name == "." {
+		return node, nil
+	}
+	for _, part := range strings.Split(name, "/") {
+		if !node.isDir() {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		entries, err := img.readDir(node)
+		if err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		index, found := slices.BinarySearchFunc(entries, part, func(entry *dirEntry, target string) int { return strings.Compare(entry.name, target) })
+		if !found {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		node, err = img.readInode(entries[index].ref)
+		if err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+	}
+	return node, nil
+}
+
+func (img *Image) Open(name string) (fs.File, error) {
+	node, err := img.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	info := &fileInfo{name: path.Base(name), node: node}
+	if node.isDir() {
+		return &dir{image: img, info: info}, nil
+	}
+	return &file{image: img, info: info}, nil
+}
+
+func (img *Image) Stat(name string) (fs.FileInfo, error) {
+	node, err := img.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{name: path.Base(name), node: node}, nil
+}
+
+// Entries are sorted by name, as squashfs stores them
+func (img *Image) ReadDir(name string) ([]fs.DirEntry, error) {
+	node, err := img.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !node.isDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	entries, err := img.readDir(node)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	dir_entries := make([]fs.DirEntry, len(entries))
+	for i, entry := range entries {
+		dir_entries[i] = entry
+	}
+	return dir_entries, nil
+}
+
+func (img *Image) Readlink(name string) (string, error) {
+	node, err := img.lookup("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	if node.mode.Type() != fs.ModeSymlink {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return node.target, nil
+}
+
+type dir struct {
+	image   *Image
+	info    *fileInfo
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dir) Close() error               { return nil }
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *dir) ReadDir(count int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.image.readDir(d.info.node)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			d.entries = append(d.entries, entry)
+		}
+		d.read = true
+	}
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	count = min(count, len(d.entries))
+	entries := d.entries[:count]
+	d.entries = d.entries[count:]
+	return entries, nil
+}
+
+// Reads a regular file block by block, the last partial block may come from a fragment
+type file struct {
+	image  *Image
+	info   *fileInfo
+	block  int
+	buffer []byte
+	offset uint64
+	// Where the next data block starts in the image
+	block_start uint64
+}
+
+func (f *file) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *file) Close() error               { return nil }
+
+func (f *file) Read(p []byte) (int, error) {
+	node := f.info.node
+	if !node.mode.IsRegular() {
+		return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: fs.ErrInvalid}
+	}
+	for len(f.buffer) == 0 {
+		if f.offset >= node.size {
+			return 0, io.EOF
+		}
+		data, err := f.nextBlock()
+		if err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: err}
+		}
+		f.buffer = data
+	}
+	n := copy(p, f.buffer)
+	f.buffer = f.buffer[n:]
+	f.offset += uint64(n)
+	return n, nil
+}
+
+func (f *file) nextBlock() ([]byte, error) {
+	node := f.info.node
+	block_size := uint64(f.image.super.BlockSize)
+	remaining := node.size - f.offset
+
+	if f.block < len(node.block_sizes) {
+		if f.block == 0 {
+			f.block_start = node.blocks_start
+		}
+		start := f.block_start
+		size := node.block_sizes[f.block]
+		f.block_start += uint64(size &^ dataUncompressed)
+		f.block++
+		expected := min(block_size, remaining)
+		// Blocks of zeroes are not stored at all
+		if size == 0 {
+			return make([]byte, expected), nil
+		}
+		data, err := f.image.dataBlock(start, size)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(len(data)) < expected {
+			return nil, &InvalidImageError{Reason: "data block is too short"}
+		}
+		return data[:expected], nil
+	}
+
+	if node.fragment == noFragment {
+		return nil, &InvalidImageError{Reason: "file is missing blocks"}
+	}
+	entry, err := f.image.fragment(node.fragment)
+	if err != nil {
+		return nil, err
+	}
+	data, err := f.image.fragmentBlock(entry)
+	if err != nil {
+		return nil, err
+	}
+	end := uint64(node.fragment_offset) + remaining
+	if end > uint64(len(data)) {
+		return nil, &InvalidImageError{Reason: "fragment is too short"}
+	}
+	return data[node.fragment_offset:end], nil
+}
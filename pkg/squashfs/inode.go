@@ -0,0 +1,175 @@
+package squashfs
+
+import (
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"time"
+)
+
+const (
+	typeDir         = 1
+	typeFile        = 2
+	typeSymlink     = 3
+	typeBlockDev    = 4
+	typeCharDev     = 5
+	typeFifo        = 6
+	typeSocket      = 7
+	typeExtDir      = 8
+	typeExtFile     = 9
+	typeExtSymlink  = 10
+	typeExtBlockDev = 11
+	typeExtCharDev  = 12
+	typeExtFifo     = 13
+	typeExtSocket   = 14
+)
+
+type inodeHeader struct {
+	Type        uint16
+	Permissions uint16
+	UIDIndex    uint16
+	GIDIndex    uint16
+	ModTime     uint32
+	Number      uint32
+}
+
+type inode struct {
+	header inodeHeader
+	mode   fs.FileMode
+	uid    uint32
+	gid    uint32
+	links  uint32
+
+	// Directories
+	dir_block  uint32
+	dir_offset uint16
+	dir_size   uint32
+
+	// Regular files, size is also set for symlinks
+	size            uint64
+	blocks_start    uint64
+	fragment        uint32
+	fragment_offset uint32
+	block_sizes     []uint32
+
+	target string
+	device uint32
+}
+
+// Reads the inode a reference points to, the upper bits are the metadata block relative to the inode table and the lower 16 the offset in it
+func (img *Image) readInode(ref uint64) (*inode, error) {
+	reader, err := img.newMetadataReader(img.super.InodeTableStart+(ref>>16), int(ref&0xffff))
+	if err != nil {
+		return nil, err
+	}
+
+	node := &inode{}
+	if err := binary.Read(reader, binary.LittleEndian, &node.header); err != nil {
+		return nil, &InvalidImageError{Reason: "could not read inode: " + err.Error()}
+	}
+	if int(node.header.UIDIndex) >= len(img.ids) || int(node.header.GIDIndex) >= len(img.ids) {
+		return nil, &InvalidImageError{Reason: "inode id index out of range"}
+	}
+	node.uid, node.gid = img.ids[node.header.UIDIndex], img.ids[node.header.GIDIndex]
+	node.mode = fs.FileMode(node.header.Permissions & 0o777)
+	if node.header.Permissions&0o4000 != 0 {
+		node.mode |= fs.ModeSetuid
+	}
+	if node.header.Permissions&0o2000 != 0 {
+		node.mode |= fs.ModeSetgid
+	}
+	if node.header.Permissions&0o1000 != 0 {
+		node.mode |= fs.ModeSticky
+	}
+
+	read := func(values ...any) error {
+		for _, value := range values {
+			if err := binary.Read(reader, binary.LittleEndian, value); err != nil {
+				return &InvalidImageError{Reason: "could not read inode: " + err.Error()}
+			}
+		}
+		return nil
+	}
+
+	switch node.header.Type {
+	case typeDir:
+		var file_size, offset uint16
+		var parent uint32
+		err = read(&node.dir_block, &node.links, &file_size, &offset, &parent)
+		node.dir_size, node.dir_offset = uint32(file_size), offset
+		node.mode |= fs.ModeDir
+	case typeExtDir:
+		var parent, xattr uint32
+		var index_count uint16
+		err = read(&node.links, &node.dir_size, &node.dir_block, &parent, &index_count, &node.dir_offset, &xattr)
+		node.mode |= fs.ModeDir
+	case typeFile:
+		var blocks_start, file_size uint32
+		err = read(&blocks_start, &node.fragment, &node.fragment_offset, &file_size)
+		node.blocks_start, node.size, node.links = uint64(blocks_start), uint64(file_size), 1
+		if err == nil {
+			err = img.readBlockSizes(reader, node)
+		}
+	case typeExtFile:
+		var sparse uint64
+		var xattr uint32
+		err = read(&node.blocks_start, &node.size, &sparse, &node.links, &node.fragment, &node.fragment_offset, &xattr)
+		if err == nil {
+			err = img.readBlockSizes(reader, node)
+		}
+	case typeSymlink, typeExtSymlink:
+		var target_size uint32
+		err = read(&node.links, &target_size)
+		if err == nil {
+			target := make([]byte, target_size)
+			if _, err = io.ReadFull(reader, target); err == nil {
+				node.target, node.size = string(target), uint64(target_size)
+			}
+		}
+		node.mode |= fs.ModeSymlink
+	case typeBlockDev, typeExtBlockDev:
+		err = read(&node.links, &node.device)
+		node.mode |= fs.ModeDevice
+	case typeCharDev, typeExtCharDev:
+		err = read(&node.links, &node.device)
+		node.mode |= fs.ModeDevice | fs.ModeCharDevice
+	case typeFifo, typeExtFifo:
+		err = read(&node.links)
+		node.mode |= fs.ModeNamedPipe
+	case typeSocket, typeExtSocket:
+		err = read(&node.links)
+		node.mode |= fs.ModeSocket
+	default:
+		return nil, &InvalidImageError{Reason: "unknown inode type"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// Files have one size per full block, and one for the last partial block unless it is stored in a fragment
+func (img *Image) readBlockSizes(reader io.Reader, node *inode) error {
+	block_size := uint64(img.super.BlockSize)
+	count := node.size / block_size
+	if node.fragment == noFragment && node.size%block_size != 0 {
+		count++
+	}
+	// Every size takes 4 bytes of the image, more than fit in it means the inode is corrupted
+	if count*4 > img.super.BytesUsed {
+		return &InvalidImageError{Reason: "file has more blocks than the image"}
+	}
+	node.block_sizes = make([]uint32, count)
+	if err := binary.Read(reader, binary.LittleEndian, node.block_sizes); err != nil {
+		return &InvalidImageError{Reason: "could not read block sizes: " + err.Error()}
+	}
+	return nil
+}
+
+func (node *inode) isDir() bool {
+	return node.mode.IsDir()
+}
+
+func (node *inode) modTime() time.Time {
+	return time.Unix(int64(node.header.ModTime), 0)
+}
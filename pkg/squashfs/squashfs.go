@@ -0,0 +1,286 @@
+// Reads squashfs (version 4) images, like the .sysext.raw files of layers, without mounting them
+package squashfs
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	magic             = 0x73717368
+	superblockSize    = 96
+	metadataBlockSize = 8192
+	// Set in the size of metadata blocks and data blocks that are stored as they are
+	metadataUncompressed = 1 << 15
+	dataUncompressed     = 1 << 24
+	noFragment           = 0xffffffff
+	fragmentEntrySize    = 16
+)
+
+type superblock struct {
+	Magic               uint32
+	InodeCount          uint32
+	ModificationTime    uint32
+	BlockSize           uint32
+	FragmentEntryCount  uint32
+	CompressionID       uint16
+	BlockLog            uint16
+	Flags               uint16
+	IDCount             uint16
+	VersionMajor        uint16
+	VersionMinor        uint16
+	RootInodeRef        uint64
+	BytesUsed           uint64
+	IDTableStart        uint64
+	XattrIDTableStart   uint64
+	InodeTableStart     uint64
+	DirectoryTableStart uint64
+	FragmentTableStart  uint64
+	ExportTableStart    uint64
+}
+
+type fragmentEntry struct {
+	Start  uint64
+	Size   uint32
+	Unused uint32
+}
+
+type metadataBlock struct {
+	data []byte
+	// Offset of the block following this one
+	next uint64
+}
+
+// A read-only squashfs image, safe for use by several goroutines at once
+type Image struct {
+	reader     io.ReaderAt
+	closer     io.Closer
+	super      superblock
+	decompress decompressor
+	ids        []uint32
+	root       *inode
+
+	mutex     sync.Mutex
+	metadata  map[uint64]metadataBlock
+	fragments []fragmentEntry
+	// Most files in a directory share a fragment block, so the last one is kept
+	fragment_start uint64
+	fragment_data  []byte
+}
+
+func Open(image_path string) (*Image, error) {
+	image_file, err := os.Open(image_path)
+	if err != nil {
+		return nil, err
+	}
+	image, err := New(image_file)
+	if err != nil {
+		image_file.Close()
+		return nil, err
+	}
+	image.closer = image_file
+	return image, nil
+}
+
+func New(reader io.ReaderAt) (*Image, error) {
+	image := &Image{reader: reader, metadata: map[uint64]metadataBlock{}}
+	if err := binary.Read(io.NewSectionReader(reader, 0, superblockSize), binary.LittleEndian, &image.super); err != nil {
+		return nil, &InvalidImageError{Reason: "could not read superblock: " + err.Error()}
+	}
+	if image.super.Magic != magic {
+		return nil, &InvalidImageError{Reason: "bad magic"}
+	}
+	if image.super.VersionMajor != 4 {
+		return nil, &InvalidImageError{Reason: "only version 4 is supported"}
+	}
+	if image.super.BlockSize == 0 || image.super.BlockSize != 1<<image.super.BlockLog {
+		return nil, &InvalidImageError{Reason: "bad block size"}
+	}
+
+	var err error
+	image.decompress, err = newDecompressor(image.super.CompressionID)
+	if err != nil {
+		return nil, err
+	}
+
+	id_table, err := image.readTable(image.super.IDTableStart, int(image.super.IDCount)*4)
+	if err != nil {
+		return nil, err
+	}
+	image.ids = make([]uint32, image.super.IDCount)
+	for i := range image.ids {
+		image.ids[i] = binary.LittleEndian.Uint32(id_table[i*4:])
+	}
+
+	image.root, err = image.readInode(image.super.RootInodeRef)
+	if err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+func (img *Image) Close() error {
+	if img.closer == nil {
+		return nil
+	}
+	return img.closer.Close()
+}
+
+func (img *Image) BlockSize() uint32 {
+	return img.super.BlockSize
+}
+
+// Reads (and caches) the metadata block at an absolute offset
+func (img *Image) metadataBlock(offset uint64) (metadataBlock, error) {
+	img.mutex.Lock()
+	block, cached := img.metadata[offset]
+	img.mutex.Unlock()
+	if cached {
+		return block, nil
+	}
+
+	header := make([]byte, 2)
+	if _, err := img.reader.ReadAt(header, int64(offset)); err != nil {
+		return metadataBlock{}, &InvalidImageError{Reason: "could not read metadata block: " + err.Error()}
+	}
+	size := binary.LittleEndian.Uint16(header)
+	stored_size := uint64(size &^ metadataUncompressed)
+	if stored_size == 0 || stored_size > metadataBlockSize {
+		return metadataBlock{}, &InvalidImageError{Reason: "bad metadata block size"}
+	}
+
+	data := make([]byte, stored_size)
+	if _, err := img.reader.ReadAt(data, int64(offset)+2); err != nil {
+		return metadataBlock{}, &InvalidImageError{Reason: "could not read metadata block: " + err.Error()}
+	}
+	if size&metadataUncompressed == 0 {
+		var err error
+		data, err = img.decompress(data)
+		if err != nil {
+			return metadataBlock{}, &InvalidImageError{Reason: "could not decompress metadata block: " + err.Error()}
+		}
+	}
+
+	block = metadataBlock{data: data, next: offset + 2 + stored_size}
+	img.mutex.Lock()
+	img.metadata[offset] = block
+	img.mutex.Unlock()
+	return block, nil
+}
+
+// Reads metadata that can span several consecutive blocks, starting at offset bytes into the block at block_start
+type metadataReader struct {
+	image *Image
+	block metadataBlock
+	pos   int
+}
+
+func (img *Image) newMetadataReader(block_start uint64, offset int) (*metadataReader, error) {
+	block, err := img.metadataBlock(block_start)
+	if err != nil {
+		return nil, err
+	}
+	if offset > len(block.data) {
+		return nil, &InvalidImageError{Reason: "metadata offset out of range"}
+	}
+	return &metadataReader{image: img, block: block, pos: offset}, nil
+}
+
+func (r *metadataReader) Read(p []byte) (int, error) {
+	if r.pos >= len(r.block.data) {
+		block, err := r.image.metadataBlock(r.block.next)
+		if err != nil {
+			return 0, err
+		}
+		r.block, r.pos = block, 0
+	}
+	n := copy(p, r.block.data[r.pos:])
+	r.pos += n
+	return n, nil
+}
+
+// Lookup tables (ids, fragments) are a list of pointers to metadata blocks, which follow each other
+func (img *Image) readTable(start uint64, size int) ([]byte, error) {
+	if size == 0 {
+		return nil, nil
+	}
+	pointer := make([]byte, 8)
+	if _, err := img.reader.ReadAt(pointer, int64(start)); err != nil {
+		return nil, &InvalidImageError{Reason: "could not read lookup table: " + err.Error()}
+	}
+	reader, err := img.newMetadataReader(binary.LittleEndian.Uint64(pointer), 0)
+	if err != nil {
+		return nil, err
+	}
+	table := make([]byte, size)
+	if _, err := io.ReadFull(reader, table); err != nil {
+		return nil, &InvalidImageError{Reason: "could not read lookup table: " + err.Error()}
+	}
+	return table, nil
+}
+
+func (img *Image) fragment(index uint32) (fragmentEntry, error) {
+	img.mutex.Lock()
+	loaded := img.fragments != nil
+	img.mutex.Unlock()
+
+	if !loaded {
+		table, err := img.readTable(img.super.FragmentTableStart, int(img.super.FragmentEntryCount)*fragmentEntrySize)
+		if err != nil {
+			return fragmentEntry{}, err
+		}
+		fragments := make([]fragmentEntry, img.super.FragmentEntryCount)
+		for i := range fragments {
+			entry := table[i*fragmentEntrySize:]
+			fragments[i] = fragmentEntry{Start: binary.LittleEndian.Uint64(entry), Size: binary.LittleEndian.Uint32(entry[8:])}
+		}
+		img.mutex.Lock()
+		img.fragments = fragments
+		img.mutex.Unlock()
+	}
+
+	if index >= img.super.FragmentEntryCount {
+		return fragmentEntry{}, &InvalidImageError{Reason: "fragment index out of range"}
+	}
+	img.mutex.Lock()
+	defer img.mutex.Unlock()
+	return img.fragments[index], nil
+}
+
+// Reads a data or fragment block, size carries the uncompressed flag
+func (img *Image) dataBlock(start uint64, size uint32) ([]byte, error) {
+	stored_size := size &^ dataUncompressed
+	data := make([]byte, stored_size)
+	if _, err := img.reader.ReadAt(data, int64(start)); err != nil {
+		return nil, &InvalidImageError{Reason: "could not read data block: " + err.Error()}
+	}
+	if size&dataUncompressed != 0 {
+		return data, nil
+	}
+	data, err := img.decompress(data)
+	if err != nil {
+		return nil, &InvalidImageError{Reason: "could not decompress data block: " + err.Error()}
+	}
+	return data, nil
+}
+
+func (img *Image) fragmentBlock(entry fragmentEntry) ([]byte, error) {
+	img.mutex.Lock()
+	if img.fragment_data != nil && img.fragment_start == entry.Start {
+		data := img.fragment_data
+		img.mutex.Unlock()
+		return data, nil
+	}
+	img.mutex.Unlock()
+
+	data, err := img.dataBlock(entry.Start, entry.Size)
+	if err != nil {
+		return nil, err
+	}
+	img.mutex.Lock()
+	img.fragment_start, img.fragment_data = entry.Start, data
+	img.mutex.Unlock()
+	return data, nil
+}
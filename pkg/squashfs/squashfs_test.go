@@ -0,0 +1,168 @@
+package squashfs
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+)
+
+// Written by testdata/gen.py
+const fixturePath = "testdata/layer.sqfs"
+
+func openFixture(t *testing.T) *Image {
+	t.Helper()
+	image, err := Open(fixturePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { image.Close() })
+	return image
+}
+
+func TestFS(t *testing.T) {
+	image := openFixture(t)
+	if err := fstest.TestFS(image, "usr/bin/tool", "usr/sparse", "usr/ext", "usr/many/f0599", "usr/extensions.d/foo/metadata.json"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWalkDir(t *testing.T) {
+	image := openFixture(t)
+
+	var files, dirs, links int
+	err := fs.WalkDir(image, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch {
+		case entry.IsDir():
+			dirs++
+		case entry.Type() == fs.ModeSymlink:
+			links++
+		default:
+			files++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The root and usr, bin, many, extensions.d, foo, lib, extension-release.d
+	if dirs != 8 || files != 607 || links != 1 {
+		t.Errorf("walked %d directories, %d files and %d links, want 8, 607 and 1", dirs, files, links)
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	image := openFixture(t)
+
+	tests := []struct {
+		name string
+		size int64
+		md5  string
+		mode fs.FileMode
+	}{
+		{"usr/bin/tool", 3*4096 + 100, "91c64be96a95be0352cd98351383486d", 0644},
+		{"usr/sparse", 3*4096 + 10, "1147208b3f15dfa3e5719e1411d73a31", 0644},
+		{"usr/exact", 2 * 4096, "b05c6ecc504ec541f2664cccd08bb137", 0644},
+		{"usr/empty", 0, "d41d8cd98f00b204e9800998ecf8427e", 0644},
+		{"usr/ext", 10000, "975f1af00285ad8b8df2f1928514bda8", fs.ModeSetuid | 0755},
+		{"usr/many/f0599", 5, "b1b1d0036d9f68e0c12ad2055fb8f3c4", 0644},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := image.Stat(test.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != test.size || info.Mode() != test.mode {
+				t.Errorf("got size %d and mode %s, want %d and %s", info.Size(), info.Mode(), test.size, test.mode)
+			}
+			data, err := fs.ReadFile(image, test.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sum := md5.Sum(data); hex.EncodeToString(sum[:]) != test.md5 {
+				t.Errorf("got md5 %x, want %s", sum, test.md5)
+			}
+		})
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	image := openFixture(t)
+
+	entries, err := image.ReadDir("usr/many")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 600 {
+		t.Fatalf("got %d entries, want 600", len(entries))
+	}
+	if entries[0].Name() != "f0000" || entries[599].Name() != "f0599" {
+		t.Errorf("entries are not sorted: %s ... %s", entries[0].Name(), entries[599].Name())
+	}
+}
+
+func TestReadlink(t *testing.T) {
+	image := openFixture(t)
+
+	tests := []struct {
+		name   string
+		target string
+		err    error
+	}{
+		{"usr/bin/link", "../store/abc/bin/tool", nil},
+		{"usr/bin/tool", "", fs.ErrInvalid},
+		{"usr/bin/missing", "", fs.ErrNotExist},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target, err := image.Readlink(test.name)
+			if !errors.Is(err, test.err) || target != test.target {
+				t.Errorf("got %q and %v, want %q and %v", target, err, test.target, test.err)
+			}
+		})
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	fixture, err := os.ReadFile(fixturePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lz4 := bytes.Clone(fixture)
+	binary.LittleEndian.PutUint16(lz4[20:], 5)
+	bad_version := bytes.Clone(fixture)
+	binary.LittleEndian.PutUint16(bad_version[28:], 3)
+
+	tests := []struct {
+		name  string
+		image []byte
+		// UnsupportedCompressionError instead of InvalidImageError
+		unsupported bool
+	}{
+		{"empty", []byte{}, false},
+		{"not squashfs", bytes.Repeat([]byte("not an image"), 16), false},
+		{"version 3", bad_version, false},
+		{"lz4", lz4, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := New(bytes.NewReader(test.image))
+			var invalid *InvalidImageError
+			var unsupported *UnsupportedCompressionError
+			if test.unsupported && !errors.As(err, &unsupported) {
+				t.Errorf("got %v, want an UnsupportedCompressionError", err)
+			} else if !test.unsupported && !errors.As(err, &invalid) {
+				t.Errorf("got %v, want an InvalidImageError", err)
+			}
+		})
+	}
+}
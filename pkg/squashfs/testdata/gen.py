@@ -0,0 +1,156 @@
+#!/usr/bin/env python3
+# Writes layer.sqfs, the image the squashfs tests read. Kept as a script so the fixture can be
+# written again without squashfs-tools. Images are gzip compressed with 4K blocks.
+#
+#     python3 gen.py layer.sqfs
+import random
+import struct
+import sys
+import zlib
+BS = 4096
+BLOG = 12
+
+class Meta:
+    def __init__(self):
+        self.out = bytearray(); self.cur = bytearray()
+    def pos(self):
+        return (len(self.out), len(self.cur))
+    def write(self, b):
+        b = bytes(b)
+        while b:
+            n = min(8192 - len(self.cur), len(b))
+            self.cur += b[:n]; b = b[n:]
+            if len(self.cur) == 8192: self.flush()
+    def flush(self):
+        if not self.cur: return
+        c = zlib.compress(bytes(self.cur))
+        if len(c) < len(self.cur):
+            self.out += struct.pack('<H', len(c)) + c
+        else:
+            self.out += struct.pack('<H', len(self.cur) | 0x8000) + self.cur
+        self.cur = bytearray()
+
+def build(tree, path, ext_file=None):
+    # tree: dict name -> bytes (file) | ('link', target) | dict (dir) | ('sparse', size)
+    data = bytearray(b'\0' * 96)
+    frag_entries = []
+    frag_cur = bytearray()
+    def flush_frag():
+        nonlocal frag_cur
+        if not frag_cur: return
+        start = len(data)
+        c = zlib.compress(bytes(frag_cur))
+        if len(c) < len(frag_cur):
+            data.extend(c); size = len(c)
+        else:
+            data.extend(frag_cur); size = len(frag_cur) | (1 << 24)
+        frag_entries.append((start, size)); frag_cur = bytearray()
+    file_info = {}
+    # write file data first, in walk order
+    def walk_data(node, prefix):
+        nonlocal frag_cur
+        for name in sorted(node):
+            v = node[name]; p = prefix + '/' + name
+            if isinstance(v, dict): walk_data(v, p); continue
+            if isinstance(v, tuple) and v[0] == 'link': continue
+            content = v if isinstance(v, bytes) else bytes(v[1])
+            start = len(data); sizes = []
+            full = len(content) // BS
+            for i in range(full):
+                blk = content[i*BS:(i+1)*BS]
+                if blk == b'\0' * BS: sizes.append(0); continue
+                c = zlib.compress(blk)
+                if len(c) < len(blk): data.extend(c); sizes.append(len(c))
+                else: data.extend(blk); sizes.append(len(blk) | (1 << 24))
+            tail = content[full*BS:]
+            frag = (0xffffffff, 0)
+            if tail:
+                if len(frag_cur) + len(tail) > BS: flush_frag()
+                frag = (len(frag_entries), len(frag_cur)); frag_cur += tail
+            file_info[p] = (start, sizes, frag, len(content))
+    walk_data(tree, '')
+    flush_frag()
+
+    inodes = Meta(); dirs = Meta()
+    counter = [0]
+    def inode_hdr(t, perm):
+        counter[0] += 1
+        return counter[0], struct.pack('<HHHHII', t, perm, 0, 1, 1234567, counter[0])
+    def write_node(node, prefix):
+        entries = []
+        for name in sorted(node):
+            v = node[name]; p = prefix + '/' + name
+            if isinstance(v, dict):
+                ref, num = write_node(v, p); t = 1
+            elif isinstance(v, tuple) and v[0] == 'link':
+                blk, off = inodes.pos(); num, h = inode_hdr(3, 0o777)
+                tgt = v[1].encode()
+                inodes.write(h + struct.pack('<II', 1, len(tgt)) + tgt)
+                ref = (blk << 16) | off; t = 3
+            else:
+                start, sizes, frag, size = file_info[p]
+                blk, off = inodes.pos()
+                if p == ext_file:
+                    num, h = inode_hdr(9, 0o4755)
+                    inodes.write(h + struct.pack('<QQQIIII', start, size, 0, 1, frag[0], frag[1], 0xffffffff) + struct.pack('<%dI' % len(sizes), *sizes))
+                else:
+                    num, h = inode_hdr(2, 0o644)
+                    inodes.write(h + struct.pack('<IIII', start, frag[0], frag[1], size) + struct.pack('<%dI' % len(sizes), *sizes))
+                ref = (blk << 16) | off; t = 2
+            entries.append((name, ref, num, t))
+        # directory listing
+        dblk, doff = dirs.pos()
+        listing = bytearray(); i = 0
+        while i < len(entries):
+            base_blk = entries[i][1] >> 16; base_num = entries[i][2]
+            group = []
+            while i < len(entries) and len(group) < 256 and entries[i][1] >> 16 == base_blk and abs(entries[i][2] - base_num) < 32767:
+                group.append(entries[i]); i += 1
+            listing += struct.pack('<IIi', len(group) - 1, base_blk, base_num)
+            for name, ref, num, t in group:
+                n = name.encode()
+                listing += struct.pack('<HhHH', ref & 0xffff, num - base_num, t, len(n) - 1) + n
+        dirs.write(listing)
+        blk, off = inodes.pos()
+        num, h = inode_hdr(1, 0o755)
+        inodes.write(h + struct.pack('<IIHHI', dblk, 2, len(listing) + 3, doff, 0))
+        return (blk << 16) | off, num
+    root_ref, _ = write_node(tree, '')
+    inodes.flush(); dirs.flush()
+    inode_start = len(data); data += inodes.out
+    dir_start = len(data); data += dirs.out
+    # fragment table
+    ft = Meta(); ft_blk = len(data)
+    for s, sz in frag_entries: ft.write(struct.pack('<QII', s, sz, 0))
+    ft.flush(); data += ft.out
+    frag_table = len(data); data += struct.pack('<Q', ft_blk)
+    idm = Meta(); id_blk = len(data); idm.write(struct.pack('<I', 0)); idm.write(struct.pack('<I', 1000)); idm.flush(); data += idm.out
+    id_table = len(data); data += struct.pack('<Q', id_blk)
+    sb = struct.pack('<IIIIIHHHHHHQQQQQQQQ', 0x73717368, counter[0], 0, BS, len(frag_entries), 1, BLOG, 0, 2, 4, 0,
+                     root_ref, len(data), id_table, 0xffffffffffffffff, inode_start, dir_start, frag_table, 0xffffffffffffffff)
+    data[0:96] = sb
+    open(path, 'wb').write(bytes(data))
+
+random.seed(1)
+def rnd(n): return bytes(random.getrandbits(8) for _ in range(n))
+
+tree = {
+  'usr': {
+    'bin': {
+      # Three full blocks and a fragment
+      'tool': rnd(BS * 3 + 100),
+      'link': ('link', '../store/abc/bin/tool'),
+    },
+    # The zero block is stored as a hole
+    'sparse': b'A' * BS + b'\0' * BS * 2 + b'B' * 10,
+    'exact': b'C' * BS * 2,
+    'empty': b'',
+    # Too many entries for a single metadata block
+    'many': {('f%04d' % i): ('x%d\n' % i).encode() for i in range(600)},
+    # Written as an extended inode with the setuid bit
+    'ext': b'hello ext\n' * 1000,
+    'extensions.d': {'foo': {'metadata.json': b'{"sysext-name":"foo","packages":["git","vim"],"arch":"x86-64","os":"_any"}'}},
+    'lib': {'extension-release.d': {'extension-release.foo.sysext': b'ID=_any\nARCHITECTURE=x86-64\nSYSEXT_LEVEL=1.0\n'}},
+  }
+}
+build(tree, sys.argv[1], ext_file='/usr/ext')
@@ -0,0 +1,27 @@
+package structures
+
+import (
+	"fmt"
+	"strings"
+)
+
+type InvalidFormatError struct {
+	Format string
+}
+
+func (e *InvalidFormatError) Error() string {
+	return fmt.Sprintf("Invalid configuration format: %s (valid formats: %s)", e.Format, strings.Join(ValidFormats, ", "))
+}
+
+type DecodeError struct {
+	Format Format
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("Invalid %s: %s", e.Format, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
@@ -2,7 +2,9 @@ package structures
 
 import (
 	"encoding/json"
+	"fmt"
 	"gopkg.in/yaml.v3"
+	"io"
 	"net/http"
 	"time"
 )
@@ -50,3 +52,18 @@ func FetchYamlConfig(url string, format interface{}) ([]byte, error) {
 	}
 	return outval, nil
 }
+
+// Fetches a configuration as it is, for callers that detect its format themselves
+func FetchConfig(url string) ([]byte, error) {
+	var myClient = &http.Client{Timeout: 10 * time.Second}
+	response, err := myClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, response.Status)
+	}
+	return io.ReadAll(response.Body)
+}
@@ -0,0 +1,136 @@
+package structures
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Formats configuration files can be written in, they are all converted to json for reading
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+	FormatTOML Format = "toml"
+)
+
+var ValidFormats = []string{string(FormatJSON), string(FormatYAML), string(FormatTOML)}
+
+func ParseFormat(format string) (Format, error) {
+	if !slices.Contains(ValidFormats, format) {
+		return "", &InvalidFormatError{Format: format}
+	}
+	return Format(format), nil
+}
+
+// Format matching the extension of a file, empty when the extension is not known
+func FormatFromPath(file_path string) Format {
+	switch strings.ToLower(filepath.Ext(file_path)) {
+	case ".json":
+		return FormatJSON
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	}
+	return ""
+}
+
+// Uses the extension when there is one, otherwise the content: json starts with a brace,
+// everything that parses as toml is toml and the rest is left to yaml
+func DetectFormat(file_path string, data []byte) Format {
+	if format := FormatFromPath(file_path); format != "" {
+		return format
+	}
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("{")) || bytes.HasPrefix(trimmed, []byte("[")) {
+		return FormatJSON
+	}
+	var document map[string]any
+	if err := toml.Unmarshal(data, &document); err == nil && len(document) > 0 {
+		return FormatTOML
+	}
+	return FormatYAML
+}
+
+// Converts a document in any format to json, keeping json as it is
+func ToJson(data []byte, format Format) ([]byte, error) {
+	var document any
+	switch format {
+	case FormatJSON:
+		return data, nil
+	case FormatYAML:
+		if err := yaml.Unmarshal(data, &document); err != nil {
+			return nil, &DecodeError{Format: format, Err: err}
+		}
+	case FormatTOML:
+		toml_document := map[string]any{}
+		if err := toml.Unmarshal(data, &toml_document); err != nil {
+			return nil, &DecodeError{Format: format, Err: err}
+		}
+		document = toml_document
+	default:
+		return nil, &InvalidFormatError{Format: string(format)}
+	}
+
+	out, err := json.MarshalIndent(document, "", INDENTATION)
+	if err != nil {
+		return nil, &DecodeError{Format: format, Err: err}
+	}
+	return out, nil
+}
+
+// Converts a json document to another format, yaml keeps the order of keys while toml sorts them
+func FromJson(data []byte, format Format) ([]byte, error) {
+	switch format {
+	case FormatJSON:
+		var out bytes.Buffer
+		if err := json.Indent(&out, data, "", INDENTATION); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	case FormatYAML:
+		node, err := YamlNodeFromJson(data)
+		if err != nil {
+			return nil, err
+		}
+		return yaml.Marshal(node)
+	case FormatTOML:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		// Keeps integers from becoming floats
+		decoder.UseNumber()
+		document := map[string]any{}
+		if err := decoder.Decode(&document); err != nil {
+			return nil, err
+		}
+		var out bytes.Buffer
+		if err := toml.NewEncoder(&out).Encode(document); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	}
+	return nil, &InvalidFormatError{Format: string(format)}
+}
+
+// Json is valid yaml, parsing it into a node keeps the order of keys. Styles are reset so it is written as block yaml.
+func YamlNodeFromJson(data []byte) (*yaml.Node, error) {
+	node := &yaml.Node{}
+	if err := yaml.Unmarshal(data, node); err != nil {
+		return nil, err
+	}
+	resetStyle(node)
+	return node, nil
+}
+
+func resetStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		resetStyle(child)
+	}
+}
@@ -0,0 +1,15 @@
+package sysext
+
+import "fmt"
+
+type StatusParseError struct {
+	Err error
+}
+
+func (e *StatusParseError) Error() string {
+	return fmt.Sprintf("Failed parsing systemd-sysext status: %s", e.Err)
+}
+
+func (e *StatusParseError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,104 @@
+package sysext
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strings"
+)
+
+const ExtensionReleaseDir = "/usr/lib/extension-release.d"
+
+// A hierarchy as reported by systemd-sysext status
+type Hierarchy struct {
+	Hierarchy  string   `json:"hierarchy"`
+	Extensions []string `json:"extensions"`
+}
+
+func (h *Hierarchy) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Hierarchy  string          `json:"hierarchy"`
+		Extensions json.RawMessage `json:"extensions"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	h.Hierarchy = raw.Hierarchy
+	h.Extensions = []string{}
+
+	// Unmerged hierarchies report the string "none" instead of a list
+	if len(raw.Extensions) == 0 || raw.Extensions[0] != '[' {
+		return nil
+	}
+	return json.Unmarshal(raw.Extensions, &h.Extensions)
+}
+
+func ParseStatus(data []byte) ([]Hierarchy, error) {
+	var hierarchies []Hierarchy
+	if err := json.Unmarshal(data, &hierarchies); err != nil {
+		return nil, &StatusParseError{Err: err}
+	}
+	return hierarchies, nil
+}
+
+func Status() ([]Hierarchy, error) {
+	out, err := exec.Command("systemd-sysext", "status", "--json=short").Output()
+	if err != nil {
+		return nil, err
+	}
+	return ParseStatus(out)
+}
+
+// Lists every merged extension, falling back to the merged extension-release files when systemd-sysext cannot be run
+func MergedExtensions() ([]string, error) {
+	hierarchies, err := Status()
+	var parse_err *StatusParseError
+	if errors.As(err, &parse_err) {
+		return nil, err
+	} else if err != nil {
+		return mergedFromReleaseFiles(ExtensionReleaseDir)
+	}
+
+	var merged []string
+	for _, hierarchy := range hierarchies {
+		for _, extension := range hierarchy.Extensions {
+			extension = imageName(extension)
+			if !slices.Contains(merged, extension) {
+				merged = append(merged, extension)
+			}
+		}
+	}
+	slices.Sort(merged)
+	return merged, nil
+}
+
+func mergedFromReleaseFiles(release_dir string) ([]string, error) {
+	entries, err := os.ReadDir(release_dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var merged []string
+	for _, entry := range entries {
+		if name, found := strings.CutPrefix(path.Base(entry.Name()), "extension-release."); found {
+			merged = append(merged, imageName(name))
+		}
+	}
+	slices.Sort(merged)
+	return merged, nil
+}
+
+// systemd only strips .raw from image names, so foo.sysext.raw is merged as foo.sysext
+func imageName(extension string) string {
+	return strings.TrimSuffix(extension, ".sysext")
+}
+
+// Path of the extension-release file inside of an image built by bext, relative to the image root
+func ReleaseFilePath(layer string) string {
+	return path.Join(strings.TrimPrefix(ExtensionReleaseDir, "/"), "extension-release."+layer+".sysext")
+}